@@ -0,0 +1,545 @@
+package archeserde
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// binaryMagic is the magic number at the start of binary files.
+const binaryMagic = "ARCHEBIN"
+
+// binaryVersion is the current version of the binary format.
+const binaryVersion uint16 = 1
+
+const binaryFlagWorld byte = 1
+
+// SerializeBinary serializes an Arche [ecs.World] to a compact binary format.
+//
+// The binary format contains the same information as the JSON produced by [Serialize]:
+//   - Entities and the entity pool
+//   - All components of all entities, including relation targets
+//   - All resources
+//
+// All numbers are stored in little-endian byte order,
+// and Go's platform-dependent types int and uint are always stored with 64 bits.
+// Thus, files can be exchanged between machines.
+//
+// Components and resources are encoded field by field, using reflection.
+// Like with [encoding/json], only exported fields are considered, and fields tagged with `json:"-"` are skipped.
+// Types implementing [encoding.BinaryMarshaler] and [encoding.BinaryUnmarshaler] are encoded using these methods.
+// Interfaces and types with custom JSON or text marshalling are embedded as JSON.
+//
+// The same options as for [Serialize] are supported.
+//
+// See [SerializeBinaryTo] for writing directly to an [io.Writer].
+// Deserialize with [DeserializeBinary].
+func SerializeBinary(world *ecs.World, options ...Option) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	if err := SerializeBinaryTo(world, &buffer, options...); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// SerializeBinaryTo serializes an Arche [ecs.World] to a compact binary format,
+// and writes it to the given [io.Writer].
+//
+// See [SerializeBinary] for details.
+func SerializeBinaryTo(world *ecs.World, w io.Writer, options ...Option) error {
+	opts := newSerdeOptions(options...)
+
+	compressor := compressWriter(w, opts.compression)
+	writer := bufio.NewWriter(compressor)
+
+	writer.WriteString(binaryMagic)
+	writer.Write(byteOrder.AppendUint16(nil, binaryVersion))
+
+	dump := entityDump(world, &opts)
+	saved, local := savedEntities(&dump, &opts)
+	infos, err := componentInfos(world, &opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.skipEntities {
+		writer.WriteByte(0)
+	} else {
+		writer.WriteByte(binaryFlagWorld)
+		writeBinaryWorld(&saved, writer)
+	}
+
+	writeBinaryTypes(infos, writer)
+
+	if err := writeBinaryComponents(world, &dump, infos, writer, &opts, local); err != nil {
+		return err
+	}
+
+	if err := writeBinaryResources(world, writer, &opts, local); err != nil {
+		return err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return compressor.Close()
+}
+
+func writeBinaryWorld(dump *ecs.EntityDump, writer *bufio.Writer) {
+	buf := binary.AppendUvarint(nil, uint64(len(dump.Entities)))
+	for _, e := range dump.Entities {
+		buf = appendEntity(buf, e)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(dump.Alive)))
+	for _, idx := range dump.Alive {
+		buf = byteOrder.AppendUint32(buf, idx)
+	}
+	buf = byteOrder.AppendUint32(buf, dump.Next)
+	buf = byteOrder.AppendUint32(buf, dump.Available)
+
+	writer.Write(buf)
+}
+
+func writeBinaryTypes(infos []compType, writer *bufio.Writer) {
+	buf := binary.AppendUvarint(nil, uint64(len(infos)))
+	for _, info := range infos {
+		buf = appendString(buf, info.Name)
+		if info.IsRelation {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+	}
+	writer.Write(buf)
+}
+
+func writeBinaryComponents(world *ecs.World, dump *ecs.EntityDump, infos []compType, writer *bufio.Writer, opts *serdeOptions, local entityRemap) error {
+	if opts.skipEntities {
+		writer.WriteByte(0)
+		return nil
+	}
+
+	writer.Write(binary.AppendUvarint(nil, uint64(len(dump.Alive))))
+
+	var buf, scratch []byte
+	var err error
+	tempIndices := []int{}
+	for counter, idx := range dump.Alive {
+		entity := dump.Entities[idx]
+
+		mask := world.Mask(entity)
+		tempIndices = tempIndices[:0]
+		for i, info := range infos {
+			if mask.Get(info.ID) {
+				tempIndices = append(tempIndices, i)
+			}
+		}
+
+		buf = binary.AppendUvarint(buf[:0], uint64(len(tempIndices)))
+		for _, i := range tempIndices {
+			info := infos[i]
+			buf = binary.AppendUvarint(buf, uint64(i))
+			if info.IsRelation {
+				buf = appendEntity(buf, relationTarget(world, dump, entity, info.ID, local))
+			}
+
+			comp := local.localCopy(reflect.NewAt(info.Type, world.GetUnchecked(entity, info.ID))).Elem()
+			if buf, scratch, err = opts.codecs.appendBinary(buf, scratch, comp); err != nil {
+				return newError(SectionComponents, counter, entity, info.Name, err)
+			}
+		}
+		if _, err := writer.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBinaryResources(world *ecs.World, writer *bufio.Writer, opts *serdeOptions, local entityRemap) error {
+	if opts.skipAllResources {
+		writer.WriteByte(0)
+		return nil
+	}
+
+	resources, err := resourceInfos(world, opts)
+	if err != nil {
+		return err
+	}
+
+	buf := binary.AppendUvarint(nil, uint64(len(resources)))
+	var scratch []byte
+	for _, info := range resources {
+		res := world.Resources().Get(info.ID)
+		ptr := reflect.ValueOf(res).UnsafePointer()
+
+		buf = appendString(buf, info.Name)
+		if buf, scratch, err = opts.codecs.appendBinary(buf, scratch, local.localCopy(reflect.NewAt(info.Type, ptr)).Elem()); err != nil {
+			return newError(SectionResources, -1, ecs.Entity{}, info.Name, err)
+		}
+	}
+	writer.Write(buf)
+	return nil
+}
+
+// DeserializeBinary deserializes an Arche [ecs.World] from the binary format written by [SerializeBinary].
+//
+// The world must be prepared the same way as for [Deserialize],
+// and the same options are supported.
+// Compressed input is detected and decompressed automatically.
+//
+// See [DeserializeBinaryFrom] for reading directly from an [io.Reader].
+func DeserializeBinary(data []byte, world *ecs.World, options ...Option) error {
+	data, err := decompressBytes(data)
+	if err != nil {
+		return err
+	}
+	reader := binStreamReader{r: bufio.NewReader(bytes.NewReader(data)), remaining: int64(len(data))}
+	return deserializeBinary(&reader, world, options...)
+}
+
+// DeserializeBinaryFrom deserializes an Arche [ecs.World] from the binary format,
+// read from the given [io.Reader].
+//
+// See [DeserializeBinary] for details.
+func DeserializeBinaryFrom(r io.Reader, world *ecs.World, options ...Option) error {
+	r, err := decompressReader(r)
+	if err != nil {
+		return err
+	}
+	reader := binStreamReader{r: bufio.NewReader(r), remaining: -1}
+	return deserializeBinary(&reader, world, options...)
+}
+
+// deserializeBinary deserializes a world from the binary format, read from the given reader.
+func deserializeBinary(reader *binStreamReader, world *ecs.World, options ...Option) error {
+	opts := newSerdeOptions(options...)
+
+	header, err := reader.bytes(len(binaryMagic) + 2)
+	if err != nil {
+		return err
+	}
+	if string(header[:len(binaryMagic)]) != binaryMagic {
+		return fmt.Errorf("invalid binary data: missing header")
+	}
+	if version := byteOrder.Uint16(header[len(binaryMagic):]); version != binaryVersion {
+		return fmt.Errorf("unsupported binary format version %d", version)
+	}
+
+	deserial := deserializer{}
+
+	flags, err := reader.byte()
+	if err != nil {
+		return err
+	}
+	if flags&binaryFlagWorld != 0 {
+		if err := readBinaryWorld(reader, &deserial.World); err != nil {
+			return sectionError(SectionWorld, err)
+		}
+		if !opts.skipEntities {
+			deserial.loadEntities(world)
+		}
+	}
+
+	relations, err := readBinaryTypes(reader, &deserial)
+	if err != nil {
+		return sectionError(SectionTypes, err)
+	}
+
+	if err := readBinaryComponents(reader, world, relations, &deserial, &opts); err != nil {
+		return sectionError(SectionComponents, err)
+	}
+
+	return sectionError(SectionResources, readBinaryResources(reader, world, &deserial, &opts))
+}
+
+func readBinaryWorld(reader *binStreamReader, dump *ecs.EntityDump) error {
+	n, err := reader.count()
+	if err != nil {
+		return err
+	}
+	data, err := reader.bytes(8 * n)
+	if err != nil {
+		return err
+	}
+	r := binReader{data: data}
+	dump.Entities = make([]ecs.Entity, n)
+	for i := range dump.Entities {
+		dump.Entities[i], _ = r.entity()
+	}
+
+	if n, err = reader.count(); err != nil {
+		return err
+	}
+	if data, err = reader.bytes(4*n + 8); err != nil {
+		return err
+	}
+	r = binReader{data: data}
+	dump.Alive = make([]uint32, n)
+	for i := range dump.Alive {
+		dump.Alive[i], _ = r.uint32()
+	}
+	dump.Next, _ = r.uint32()
+	dump.Available, _ = r.uint32()
+
+	for _, idx := range dump.Alive {
+		if int(idx) >= len(dump.Entities) {
+			return fmt.Errorf("alive entity %d is out of range of %d entities", idx, len(dump.Entities))
+		}
+	}
+
+	return nil
+}
+
+// readBinaryTypes reads the type table, and returns for each type whether it is a relation.
+func readBinaryTypes(reader *binStreamReader, deserial *deserializer) ([]bool, error) {
+	n, err := reader.count()
+	if err != nil {
+		return nil, err
+	}
+	// Grown while reading, as the count is not checked against the input size for streams.
+	deserial.Types = []string{}
+	relations := []bool{}
+	for i := 0; i < n; i++ {
+		name, err := reader.string()
+		if err != nil {
+			return nil, err
+		}
+		flag, err := reader.byte()
+		if err != nil {
+			return nil, err
+		}
+		deserial.Types = append(deserial.Types, name)
+		relations = append(relations, flag != 0)
+	}
+	return relations, nil
+}
+
+func readBinaryComponents(reader *binStreamReader, world *ecs.World, relations []bool, deserial *deserializer, opts *serdeOptions) error {
+	n, err := reader.count()
+	if err != nil {
+		return err
+	}
+
+	var loader *componentLoader
+	if !opts.skipEntities {
+		if loader, err = newComponentLoader(world, deserial, opts); err != nil {
+			return err
+		}
+		if n != len(deserial.World.Alive) {
+			return fmt.Errorf("found components for %d entities, but world has %d alive entities", n, len(deserial.World.Alive))
+		}
+	}
+
+	ids := []ecs.ID{}
+	components := []reflect.Value{}
+	for i := 0; i < n; i++ {
+		entity := ecs.Entity{}
+		if i < len(deserial.World.Alive) {
+			entity = deserial.World.Entities[deserial.World.Alive[i]]
+		}
+
+		numComps, err := reader.count()
+		if err != nil {
+			return newError("", i, entity, "", err)
+		}
+
+		ids = ids[:0]
+		components = components[:0]
+		target := ecs.Entity{}
+		var targetComp ecs.ID
+		hasRelation := false
+
+		for j := 0; j < numComps; j++ {
+			idx, err := reader.count()
+			if err != nil {
+				return newError("", i, entity, "", err)
+			}
+			if idx >= len(deserial.Types) {
+				return newError("", i, entity, "", fmt.Errorf("component type index %d out of range of %d types", idx, len(deserial.Types)))
+			}
+			tpName := deserial.Types[idx]
+			var compTarget ecs.Entity
+			if relations[idx] {
+				if compTarget, err = reader.entity(); err != nil {
+					return newError("", i, entity, tpName, err)
+				}
+			}
+			data, err := reader.block()
+			if err != nil {
+				return newError("", i, entity, tpName, err)
+			}
+
+			if loader == nil {
+				continue
+			}
+			id := loader.ids[tpName]
+			if loader.skipComponents.Get(id) {
+				continue
+			}
+			info := loader.infos[id]
+			if info.IsRelation {
+				target = compTarget
+				targetComp = id
+				hasRelation = true
+			}
+
+			comp := reflect.New(info.Type).Elem()
+			if err := loader.codecs.decodeBinary(data, comp); err != nil {
+				return newError("", i, entity, tpName, err)
+			}
+			ids = append(ids, id)
+			components = append(components, comp)
+		}
+
+		if loader == nil {
+			continue
+		}
+		loader.apply(loader.entity(deserial.World.Alive[i]), ids, components, targetComp, hasRelation, target)
+	}
+	return nil
+}
+
+func readBinaryResources(reader *binStreamReader, world *ecs.World, deserial *deserializer, opts *serdeOptions) error {
+	n, err := reader.count()
+	if err != nil {
+		return err
+	}
+
+	loader := newResourceLoader(world, deserial, opts)
+	for i := 0; i < n; i++ {
+		tpName, err := reader.string()
+		if err != nil {
+			return err
+		}
+		data, err := reader.block()
+		if err != nil {
+			return err
+		}
+		if opts.skipAllResources {
+			continue
+		}
+
+		value, err := loader.target(tpName)
+		if err != nil {
+			return typeError(tpName, err)
+		}
+		if !value.IsValid() {
+			continue
+		}
+		if err := opts.codecs.decodeBinary(data, value.Elem()); err != nil {
+			return typeError(tpName, err)
+		}
+		loader.remap(value)
+	}
+	return nil
+}
+
+// binStreamReader reads binary data from a buffered reader.
+type binStreamReader struct {
+	r         *bufio.Reader
+	buffer    []byte
+	remaining int64 // Number of bytes left to read, or -1 if unknown.
+}
+
+// binChunkSize is the size of the chunks for reading blocks of unknown remaining input.
+// It limits allocations for corrupt lengths to the size of the actual input.
+const binChunkSize = 1 << 16
+
+// bytes reads the given number of bytes.
+// The result is only valid until the next read.
+func (r *binStreamReader) bytes(n int) ([]byte, error) {
+	if r.remaining >= 0 && int64(n) > r.remaining {
+		return nil, errUnexpectedEnd
+	}
+	r.buffer = r.buffer[:0]
+	for len(r.buffer) < n {
+		chunk := n - len(r.buffer)
+		if r.remaining < 0 {
+			chunk = min(chunk, max(binChunkSize, len(r.buffer)))
+		}
+		start := len(r.buffer)
+		r.buffer = slices.Grow(r.buffer, chunk)[:start+chunk]
+		if _, err := io.ReadFull(r.r, r.buffer[start:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, errUnexpectedEnd
+			}
+			return nil, err
+		}
+	}
+	r.consume(n)
+	return r.buffer, nil
+}
+
+func (r *binStreamReader) byte() (byte, error) {
+	b, err := r.ReadByte()
+	if err == io.EOF {
+		return 0, errUnexpectedEnd
+	}
+	return b, err
+}
+
+// ReadByte implements [io.ByteReader], for reading varints.
+func (r *binStreamReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.consume(1)
+	}
+	return b, err
+}
+
+// consume accounts for n bytes read.
+func (r *binStreamReader) consume(n int) {
+	if r.remaining >= 0 {
+		r.remaining -= int64(n)
+	}
+}
+
+// count reads an unsigned varint, used for counts and lengths.
+//
+// Each counted item takes at least one byte,
+// so counts that exceed the remaining input are rejected if its size is known.
+func (r *binStreamReader) count() (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, errUnexpectedEnd
+		}
+		return 0, err
+	}
+	if n > 1<<31 {
+		return 0, fmt.Errorf("invalid count %d in binary data", n)
+	}
+	if r.remaining >= 0 && n > uint64(r.remaining) {
+		return 0, errUnexpectedEnd
+	}
+	return int(n), nil
+}
+
+// block reads a length-prefixed block of bytes.
+// The result is only valid until the next read.
+func (r *binStreamReader) block() ([]byte, error) {
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+	return r.bytes(n)
+}
+
+func (r *binStreamReader) string() (string, error) {
+	b, err := r.block()
+	return string(b), err
+}
+
+func (r *binStreamReader) entity() (ecs.Entity, error) {
+	data, err := r.bytes(8)
+	if err != nil {
+		return ecs.Entity{}, err
+	}
+	return newEntity(byteOrder.Uint32(data), byteOrder.Uint32(data[4:])), nil
+}
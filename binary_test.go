@@ -0,0 +1,267 @@
+package archeserde_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/mlange-42/arche/generic"
+	"github.com/stretchr/testify/assert"
+)
+
+type Samples struct {
+	V []int64
+}
+
+type SampleMap struct {
+	V map[int64]int64
+}
+
+type embedded struct {
+	Inner int16
+}
+
+type Node struct {
+	Value int
+	Next  *Node
+}
+
+type Complex struct {
+	embedded
+	Name     string
+	Flag     bool
+	Small    int8
+	Unsigned uint
+	Float    float32
+	Bytes    []byte
+	Nil      []int
+	Slice    []Position
+	Array    [3]uint16
+	Map      map[string]int
+	Entities map[int]ecs.Entity
+	Pointer  *Velocity
+	List     *Node
+	Time     time.Time
+	Any      any
+	Skipped  int `json:"-"`
+	private  int
+}
+
+func TestSerializeBinary(t *testing.T) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	compId := ecs.ComponentID[Complex](&w)
+	relId := ecs.ComponentID[ChildRelation](&w)
+
+	parent := w.NewEntity(posId)
+	*(*Position)(w.Get(parent, posId)) = Position{X: 1, Y: 2}
+
+	tm := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	comp := Complex{
+		embedded: embedded{Inner: -3},
+		Name:     "test",
+		Flag:     true,
+		Small:    -5,
+		Unsigned: 1 << 40,
+		Float:    0.5,
+		Bytes:    []byte{1, 2, 3},
+		Slice:    []Position{{X: 1}, {Y: 2}},
+		Array:    [3]uint16{1, 2, 3},
+		Map:      map[string]int{"a": 1, "b": 2},
+		Entities: map[int]ecs.Entity{1: parent},
+		Pointer:  &Velocity{X: 7},
+		List:     &Node{Value: 1, Next: &Node{Value: 2}},
+		Time:     tm,
+		Any:      "text",
+		Skipped:  10,
+		private:  11,
+	}
+
+	child := w.NewEntity(posId, compId, relId)
+	*(*Position)(w.Get(child, posId)) = Position{X: 3, Y: 4}
+	*(*Complex)(w.Get(child, compId)) = comp
+	w.Relations().Set(child, relId, parent)
+
+	w.NewEntity()
+	w.RemoveEntity(w.NewEntity())
+
+	_ = ecs.AddResource(&w, &Velocity{X: 1000})
+
+	data, err := archeserde.SerializeBinary(&w)
+	assert.Nil(t, err)
+
+	w2 := ecs.NewWorld()
+	posId = ecs.ComponentID[Position](&w2)
+	compId = ecs.ComponentID[Complex](&w2)
+	relId = ecs.ComponentID[ChildRelation](&w2)
+	_ = ecs.AddResource(&w2, &Velocity{})
+
+	err = archeserde.DeserializeBinary(data, &w2)
+	assert.Nil(t, err)
+
+	assert.Equal(t, w.DumpEntities(), w2.DumpEntities())
+
+	assert.Equal(t, Position{X: 1, Y: 2}, *(*Position)(w2.Get(parent, posId)))
+	assert.Equal(t, Position{X: 3, Y: 4}, *(*Position)(w2.Get(child, posId)))
+	assert.Equal(t, parent, w2.Relations().Get(child, relId))
+
+	comp.Skipped = 0
+	comp.private = 0
+	assert.Equal(t, comp, *(*Complex)(w2.Get(child, compId)))
+
+	assert.Equal(t, Velocity{X: 1000}, *ecs.GetResource[Velocity](&w2))
+
+	data2, err := archeserde.SerializeBinary(&w2)
+	assert.Nil(t, err)
+	assert.Equal(t, data, data2)
+}
+
+func TestSerializeBinaryOptions(t *testing.T) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	velId := ecs.ComponentID[Velocity](&w)
+
+	e := w.NewEntity(posId, velId)
+	*(*Position)(w.Get(e, posId)) = Position{X: 1, Y: 2}
+	*(*Velocity)(w.Get(e, velId)) = Velocity{X: 3, Y: 4}
+	_ = ecs.AddResource(&w, &Velocity{X: 1000})
+	_ = ecs.AddResource(&w, &Position{X: 2000})
+
+	data, err := archeserde.SerializeBinary(&w,
+		archeserde.Opts.SkipComponents(generic.T[Velocity]()),
+		archeserde.Opts.SkipResources(generic.T[Position]()),
+	)
+	assert.Nil(t, err)
+
+	w2 := ecs.NewWorld()
+	posId = ecs.ComponentID[Position](&w2)
+	velId = ecs.ComponentID[Velocity](&w2)
+	_ = ecs.AddResource(&w2, &Velocity{})
+	_ = ecs.AddResource(&w2, &Position{})
+
+	err = archeserde.DeserializeBinary(data, &w2)
+	assert.Nil(t, err)
+	assert.True(t, w2.Has(e, posId))
+	assert.False(t, w2.Has(e, velId))
+	assert.Equal(t, Position{}, *ecs.GetResource[Position](&w2))
+	assert.Equal(t, Velocity{X: 1000}, *ecs.GetResource[Velocity](&w2))
+
+	data, err = archeserde.SerializeBinary(&w)
+	assert.Nil(t, err)
+
+	w2 = ecs.NewWorld()
+	posId = ecs.ComponentID[Position](&w2)
+	velId = ecs.ComponentID[Velocity](&w2)
+	_ = ecs.AddResource(&w2, &Velocity{})
+	_ = ecs.ResourceID[Position](&w2)
+
+	err = archeserde.DeserializeBinary(data, &w2,
+		archeserde.Opts.SkipComponents(generic.T[Position]()),
+		archeserde.Opts.SkipResources(generic.T[Position]()),
+	)
+	assert.Nil(t, err)
+	assert.False(t, w2.Has(e, posId))
+	assert.True(t, w2.Has(e, velId))
+
+	for _, opt := range []archeserde.Option{
+		archeserde.Opts.SkipEntities(),
+		archeserde.Opts.SkipAllComponents(),
+		archeserde.Opts.SkipAllResources(),
+	} {
+		data, err = archeserde.SerializeBinary(&w, opt)
+		assert.Nil(t, err)
+
+		w2 = ecs.NewWorld()
+		_ = ecs.ComponentID[Position](&w2)
+		_ = ecs.ComponentID[Velocity](&w2)
+		_ = ecs.AddResource(&w2, &Velocity{})
+		_ = ecs.AddResource(&w2, &Position{})
+		err = archeserde.DeserializeBinary(data, &w2)
+		assert.Nil(t, err)
+
+		data, err = archeserde.SerializeBinary(&w)
+		assert.Nil(t, err)
+
+		w2 = ecs.NewWorld()
+		_ = ecs.ComponentID[Position](&w2)
+		_ = ecs.ComponentID[Velocity](&w2)
+		_ = ecs.AddResource(&w2, &Velocity{})
+		_ = ecs.AddResource(&w2, &Position{})
+		err = archeserde.DeserializeBinaryFrom(bytes.NewReader(data), &w2, opt)
+		assert.Nil(t, err)
+	}
+}
+
+func TestDeserializeBinaryErrors(t *testing.T) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	w.NewEntity(posId)
+	_ = ecs.AddResource(&w, &Velocity{X: 1000})
+
+	data, err := archeserde.SerializeBinary(&w)
+	assert.Nil(t, err)
+
+	w2 := ecs.NewWorld()
+	err = archeserde.DeserializeBinary([]byte("{}"), &w2)
+	assert.Contains(t, err.Error(), "unexpected end of binary data")
+
+	err = archeserde.DeserializeBinary([]byte("0123456789"), &w2)
+	assert.Contains(t, err.Error(), "missing header")
+
+	err = archeserde.DeserializeBinary(append([]byte("ARCHEBIN"), 99, 0), &w2)
+	assert.Contains(t, err.Error(), "unsupported binary format version 99")
+
+	// Counts beyond the input size must not lead to huge allocations.
+	hugeCount := binary.AppendUvarint(nil, 1<<31)
+	for _, corrupt := range [][]byte{
+		append(append([]byte("ARCHEBIN"), 1, 0, 1), hugeCount...),
+		append(append([]byte("ARCHEBIN"), 1, 0, 0), hugeCount...),
+	} {
+		err = archeserde.DeserializeBinary(corrupt, &w2)
+		assert.Contains(t, err.Error(), "unexpected end of binary data")
+		err = archeserde.DeserializeBinaryFrom(iotest.OneByteReader(bytes.NewReader(corrupt)), &w2)
+		assert.Contains(t, err.Error(), "unexpected end of binary data")
+	}
+
+	// The same applies to lengths of slices and maps inside components.
+	for _, comp := range []generic.Comp{generic.T[Samples](), generic.T[SampleMap]()} {
+		sw := ecs.NewWorld()
+		id := ecs.TypeID(&sw, comp)
+		sw.NewEntity(id)
+		sData, err := archeserde.SerializeBinary(&sw, archeserde.Opts.SkipAllResources())
+		assert.Nil(t, err)
+
+		// The data ends with the block of the empty value, and the resource count.
+		hugeLength := binary.AppendUvarint(nil, 1<<30+1)
+		corrupt := append([]byte{}, sData[:len(sData)-3]...)
+		corrupt = append(binary.AppendUvarint(corrupt, uint64(len(hugeLength))), hugeLength...)
+		corrupt = append(corrupt, 0)
+
+		sw2 := ecs.NewWorld()
+		_ = ecs.TypeID(&sw2, comp)
+		assert.Nil(t, archeserde.DeserializeBinary(sData, &sw2))
+
+		sw2 = ecs.NewWorld()
+		_ = ecs.TypeID(&sw2, comp)
+		err = archeserde.DeserializeBinary(corrupt, &sw2)
+		assert.Contains(t, err.Error(), "unexpected end of binary data")
+	}
+
+	err = archeserde.DeserializeBinary(data[:len(data)-3], &w2)
+	assert.Contains(t, err.Error(), "component type is not registered")
+
+	w2 = ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&w2)
+	_ = ecs.AddResource(&w2, &Velocity{})
+	err = archeserde.DeserializeBinary(data[:len(data)-3], &w2)
+	assert.Contains(t, err.Error(), "unexpected end of binary data")
+
+	w2 = ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&w2)
+	err = archeserde.DeserializeBinary(data, &w2)
+	assert.Contains(t, err.Error(), "resource type is not registered")
+}
@@ -0,0 +1,613 @@
+package archeserde
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+var byteOrder = binary.LittleEndian
+
+var errUnexpectedEnd = errors.New("unexpected end of binary data")
+
+var (
+	jsonMarshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonUnmarshalerType   = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textMarshalerType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// binCodec encodes and decodes values of a certain type in the binary format.
+type binCodec struct {
+	encode func(buf []byte, v reflect.Value) ([]byte, error)
+	decode func(r *binReader, v reflect.Value) error
+}
+
+var (
+	binCodecs     = map[reflect.Type]*binCodec{}
+	binCodecsLock sync.RWMutex
+)
+
+// binCodecFor returns the cached binary codec for a type, and creates it if necessary.
+func binCodecFor(tp reflect.Type) *binCodec {
+	binCodecsLock.RLock()
+	c, ok := binCodecs[tp]
+	binCodecsLock.RUnlock()
+	if ok {
+		return c
+	}
+
+	binCodecsLock.Lock()
+	defer binCodecsLock.Unlock()
+	return buildBinCodec(tp)
+}
+
+// buildBinCodec creates a binary codec. Must be called with the lock held.
+//
+// The codec is registered before it is filled, to support recursive types.
+func buildBinCodec(tp reflect.Type) *binCodec {
+	if c, ok := binCodecs[tp]; ok {
+		return c
+	}
+	c := &binCodec{}
+	binCodecs[tp] = c
+
+	ptr := reflect.PointerTo(tp)
+	switch {
+	case tp == entityType:
+		c.encode, c.decode = encodeEntity, decodeEntity
+	case ptr.Implements(binaryMarshalerType) && ptr.Implements(binaryUnmarshalerType):
+		c.encode, c.decode = encodeBinaryMarshaler, decodeBinaryMarshaler
+	case tp.Kind() == reflect.Interface ||
+		ptr.Implements(jsonMarshalerType) || ptr.Implements(jsonUnmarshalerType) || ptr.Implements(textMarshalerType):
+		c.encode, c.decode = encodeJSONValue, decodeJSONValue
+	default:
+		buildKindCodec(c, tp)
+	}
+	return c
+}
+
+// buildKindCodec fills a binary codec based on the kind of a type.
+func buildKindCodec(c *binCodec, tp reflect.Type) {
+	switch tp.Kind() {
+	case reflect.Bool:
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			if v.Bool() {
+				return append(buf, 1), nil
+			}
+			return append(buf, 0), nil
+		}
+		c.decode = func(r *binReader, v reflect.Value) error {
+			b, err := r.byte()
+			v.SetBool(b != 0)
+			return err
+		}
+	case reflect.Int8:
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return append(buf, byte(v.Int())), nil
+		}
+		c.decode = func(r *binReader, v reflect.Value) error {
+			b, err := r.byte()
+			v.SetInt(int64(int8(b)))
+			return err
+		}
+	case reflect.Uint8:
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return append(buf, byte(v.Uint())), nil
+		}
+		c.decode = func(r *binReader, v reflect.Value) error {
+			b, err := r.byte()
+			v.SetUint(uint64(b))
+			return err
+		}
+	case reflect.Int16:
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return byteOrder.AppendUint16(buf, uint16(v.Int())), nil
+		}
+		c.decode = func(r *binReader, v reflect.Value) error {
+			x, err := r.uint16()
+			v.SetInt(int64(int16(x)))
+			return err
+		}
+	case reflect.Uint16:
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return byteOrder.AppendUint16(buf, uint16(v.Uint())), nil
+		}
+		c.decode = func(r *binReader, v reflect.Value) error {
+			x, err := r.uint16()
+			v.SetUint(uint64(x))
+			return err
+		}
+	case reflect.Int32:
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return byteOrder.AppendUint32(buf, uint32(v.Int())), nil
+		}
+		c.decode = func(r *binReader, v reflect.Value) error {
+			x, err := r.uint32()
+			v.SetInt(int64(int32(x)))
+			return err
+		}
+	case reflect.Uint32:
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return byteOrder.AppendUint32(buf, uint32(v.Uint())), nil
+		}
+		c.decode = func(r *binReader, v reflect.Value) error {
+			x, err := r.uint32()
+			v.SetUint(uint64(x))
+			return err
+		}
+	case reflect.Int, reflect.Int64:
+		// Always 64 bit, independent of the platform.
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return byteOrder.AppendUint64(buf, uint64(v.Int())), nil
+		}
+		c.decode = func(r *binReader, v reflect.Value) error {
+			x, err := r.uint64()
+			if err != nil {
+				return err
+			}
+			if v.OverflowInt(int64(x)) {
+				return fmt.Errorf("value %d overflows %s", int64(x), v.Type())
+			}
+			v.SetInt(int64(x))
+			return nil
+		}
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return byteOrder.AppendUint64(buf, v.Uint()), nil
+		}
+		c.decode = func(r *binReader, v reflect.Value) error {
+			x, err := r.uint64()
+			if err != nil {
+				return err
+			}
+			if v.OverflowUint(x) {
+				return fmt.Errorf("value %d overflows %s", x, v.Type())
+			}
+			v.SetUint(x)
+			return nil
+		}
+	case reflect.Float32:
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return byteOrder.AppendUint32(buf, math.Float32bits(float32(v.Float()))), nil
+		}
+		c.decode = func(r *binReader, v reflect.Value) error {
+			x, err := r.uint32()
+			v.SetFloat(float64(math.Float32frombits(x)))
+			return err
+		}
+	case reflect.Float64:
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return byteOrder.AppendUint64(buf, math.Float64bits(v.Float())), nil
+		}
+		c.decode = func(r *binReader, v reflect.Value) error {
+			x, err := r.uint64()
+			v.SetFloat(math.Float64frombits(x))
+			return err
+		}
+	case reflect.String:
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			return appendString(buf, v.String()), nil
+		}
+		c.decode = func(r *binReader, v reflect.Value) error {
+			s, err := r.string()
+			v.SetString(s)
+			return err
+		}
+	case reflect.Pointer:
+		buildPointerCodec(c, tp)
+	case reflect.Slice:
+		buildSliceCodec(c, tp)
+	case reflect.Array:
+		buildArrayCodec(c, tp)
+	case reflect.Map:
+		buildMapCodec(c, tp)
+	case reflect.Struct:
+		buildStructCodec(c, tp)
+	default:
+		err := fmt.Errorf("unsupported type for binary serialization: %s", tp)
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) { return buf, err }
+		c.decode = func(r *binReader, v reflect.Value) error { return err }
+	}
+}
+
+func buildPointerCodec(c *binCodec, tp reflect.Type) {
+	elem := buildBinCodec(tp.Elem())
+	c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		return elem.encode(append(buf, 1), v.Elem())
+	}
+	c.decode = func(r *binReader, v reflect.Value) error {
+		b, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if b == 0 {
+			v.SetZero()
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(tp.Elem()))
+		}
+		return elem.decode(r, v.Elem())
+	}
+}
+
+// buildSliceCodec creates a codec for slices.
+// The length is stored incremented by one, with zero indicating a nil slice.
+func buildSliceCodec(c *binCodec, tp reflect.Type) {
+	if tp.Elem().Kind() == reflect.Uint8 && tp.Elem().PkgPath() == "" {
+		c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+			if v.IsNil() {
+				return binary.AppendUvarint(buf, 0), nil
+			}
+			buf = binary.AppendUvarint(buf, uint64(v.Len())+1)
+			return append(buf, v.Bytes()...), nil
+		}
+		c.decode = func(r *binReader, v reflect.Value) error {
+			n, isNil, err := r.length(true)
+			if err != nil || isNil {
+				v.SetZero()
+				return err
+			}
+			data, err := r.bytes(n)
+			v.SetBytes(bytes.Clone(data))
+			return err
+		}
+		return
+	}
+
+	elem := buildBinCodec(tp.Elem())
+	c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+		if v.IsNil() {
+			return binary.AppendUvarint(buf, 0), nil
+		}
+		buf = binary.AppendUvarint(buf, uint64(v.Len())+1)
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if buf, err = elem.encode(buf, v.Index(i)); err != nil {
+				return buf, err
+			}
+		}
+		return buf, nil
+	}
+	bounded := tp.Elem().Size() > 0
+	c.decode = func(r *binReader, v reflect.Value) error {
+		n, isNil, err := r.length(bounded)
+		if err != nil || isNil {
+			v.SetZero()
+			return err
+		}
+		v.Set(reflect.MakeSlice(tp, n, n))
+		for i := 0; i < n; i++ {
+			if err := elem.decode(r, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func buildArrayCodec(c *binCodec, tp reflect.Type) {
+	elem := buildBinCodec(tp.Elem())
+	c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if buf, err = elem.encode(buf, v.Index(i)); err != nil {
+				return buf, err
+			}
+		}
+		return buf, nil
+	}
+	c.decode = func(r *binReader, v reflect.Value) error {
+		for i := 0; i < v.Len(); i++ {
+			if err := elem.decode(r, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// buildMapCodec creates a codec for maps.
+// Entries are sorted by their encoded keys, to make the output deterministic.
+func buildMapCodec(c *binCodec, tp reflect.Type) {
+	key := buildBinCodec(tp.Key())
+	elem := buildBinCodec(tp.Elem())
+	c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+		if v.IsNil() {
+			return binary.AppendUvarint(buf, 0), nil
+		}
+		buf = binary.AppendUvarint(buf, uint64(v.Len())+1)
+
+		type kv struct {
+			key   []byte
+			value reflect.Value
+		}
+		entries := make([]kv, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			k, err := key.encode(nil, iter.Key())
+			if err != nil {
+				return buf, err
+			}
+			entries = append(entries, kv{k, iter.Value()})
+		}
+		slices.SortFunc(entries, func(a, b kv) int { return bytes.Compare(a.key, b.key) })
+
+		var err error
+		for _, e := range entries {
+			buf = append(buf, e.key...)
+			if buf, err = elem.encode(buf, e.value); err != nil {
+				return buf, err
+			}
+		}
+		return buf, nil
+	}
+	// Maps with zero-size keys and values have at most one entry.
+	bounded := tp.Key().Size()+tp.Elem().Size() > 0
+	c.decode = func(r *binReader, v reflect.Value) error {
+		n, isNil, err := r.length(bounded)
+		if err != nil || isNil {
+			v.SetZero()
+			return err
+		}
+		if !bounded && n > 1 {
+			return fmt.Errorf("invalid length %d of map with zero-size entries in binary data", n)
+		}
+		v.Set(reflect.MakeMapWithSize(tp, n))
+		k := reflect.New(tp.Key()).Elem()
+		e := reflect.New(tp.Elem()).Elem()
+		for i := 0; i < n; i++ {
+			k.SetZero()
+			e.SetZero()
+			if err := key.decode(r, k); err != nil {
+				return err
+			}
+			if err := elem.decode(r, e); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, e)
+		}
+		return nil
+	}
+}
+
+// buildStructCodec creates a codec for structs.
+// Like in [encoding/json], only exported and embedded fields are considered,
+// and fields tagged with `json:"-"` are skipped.
+func buildStructCodec(c *binCodec, tp reflect.Type) {
+	type field struct {
+		index int
+		codec *binCodec
+	}
+	fields := []field{}
+	for i := 0; i < tp.NumField(); i++ {
+		f := tp.Field(i)
+		if f.Tag.Get("json") == "-" {
+			continue
+		}
+		if !f.IsExported() && !(f.Anonymous && f.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		fields = append(fields, field{i, buildBinCodec(f.Type)})
+	}
+
+	c.encode = func(buf []byte, v reflect.Value) ([]byte, error) {
+		var err error
+		for _, f := range fields {
+			if buf, err = f.codec.encode(buf, v.Field(f.index)); err != nil {
+				return buf, err
+			}
+		}
+		return buf, nil
+	}
+	c.decode = func(r *binReader, v reflect.Value) error {
+		for _, f := range fields {
+			if err := f.codec.decode(r, v.Field(f.index)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func encodeEntity(buf []byte, v reflect.Value) ([]byte, error) {
+	e := addressable(v).Addr().Interface().(*ecs.Entity)
+	return appendEntity(buf, *e), nil
+}
+
+func decodeEntity(r *binReader, v reflect.Value) error {
+	e, err := r.entity()
+	v.Set(reflect.ValueOf(e))
+	return err
+}
+
+func encodeBinaryMarshaler(buf []byte, v reflect.Value) ([]byte, error) {
+	data, err := addressable(v).Addr().Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return buf, err
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...), nil
+}
+
+func decodeBinaryMarshaler(r *binReader, v reflect.Value) error {
+	data, err := r.block()
+	if err != nil {
+		return err
+	}
+	return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+}
+
+// encodeJSONValue encodes values that can't be handled by reflection,
+// like interfaces and types with custom JSON marshalling, as embedded JSON.
+func encodeJSONValue(buf []byte, v reflect.Value) ([]byte, error) {
+	data, err := json.Marshal(addressable(v).Addr().Interface())
+	if err != nil {
+		return buf, err
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...), nil
+}
+
+func decodeJSONValue(r *binReader, v reflect.Value) error {
+	data, err := r.block()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v.Addr().Interface())
+}
+
+// addressable returns an addressable copy of a value, or the value itself if it is addressable.
+func addressable(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	return ptr.Elem()
+}
+
+// encodeBinaryValue appends the binary encoding of a value to the buffer.
+func encodeBinaryValue(buf []byte, v reflect.Value) ([]byte, error) {
+	return binCodecFor(v.Type()).encode(buf, v)
+}
+
+// decodeBinaryValue decodes a value from its complete binary encoding.
+func decodeBinaryValue(data []byte, v reflect.Value) error {
+	r := binReader{data: data}
+	if err := binCodecFor(v.Type()).decode(&r, v); err != nil {
+		return err
+	}
+	if r.pos != len(data) {
+		return fmt.Errorf("found %d unused bytes after decoding %s", len(data)-r.pos, v.Type())
+	}
+	return nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendEntity(buf []byte, e ecs.Entity) []byte {
+	buf = byteOrder.AppendUint32(buf, e.ID())
+	return byteOrder.AppendUint32(buf, e.Generation())
+}
+
+// binReader reads binary data from a byte slice.
+type binReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *binReader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, errUnexpectedEnd
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *binReader) byte() (byte, error) {
+	b, err := r.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *binReader) uint16() (uint16, error) {
+	b, err := r.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return byteOrder.Uint16(b), nil
+}
+
+func (r *binReader) uint32() (uint32, error) {
+	b, err := r.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return byteOrder.Uint32(b), nil
+}
+
+func (r *binReader) uint64() (uint64, error) {
+	b, err := r.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return byteOrder.Uint64(b), nil
+}
+
+func (r *binReader) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, errUnexpectedEnd
+	}
+	r.pos += n
+	return x, nil
+}
+
+// length reads a length that is incremented by one, with zero indicating nil.
+//
+// If bounded is true, each element takes at least one byte,
+// so lengths that exceed the remaining data are rejected before anything is allocated.
+// Only elements of zero-size types are not bounded.
+func (r *binReader) length(bounded bool) (int, bool, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return 0, false, err
+	}
+	if n == 0 {
+		return 0, true, nil
+	}
+	if n-1 > math.MaxInt32 {
+		return 0, false, fmt.Errorf("invalid length %d in binary data", n-1)
+	}
+	if bounded && n-1 > uint64(len(r.data)-r.pos) {
+		return 0, false, errUnexpectedEnd
+	}
+	return int(n - 1), false, nil
+}
+
+// block reads a length-prefixed block of bytes.
+func (r *binReader) block() ([]byte, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, errUnexpectedEnd
+	}
+	return r.bytes(int(n))
+}
+
+func (r *binReader) string() (string, error) {
+	b, err := r.block()
+	return string(b), err
+}
+
+func (r *binReader) entity() (ecs.Entity, error) {
+	id, err := r.uint32()
+	if err != nil {
+		return ecs.Entity{}, err
+	}
+	gen, err := r.uint32()
+	return newEntity(id, gen), err
+}
@@ -0,0 +1,371 @@
+// Command arche-serde inspects, validates, compares and converts JSON files written by arche-serde.
+//
+// It works on the type-agnostic file model [archeserde.File],
+// so it does not require the Go types of the components and resources.
+// Files in the binary format are not supported for the same reason.
+// Compressed files are detected automatically.
+//
+// Usage:
+//
+//	arche-serde info FILE
+//	arche-serde validate FILE
+//	arche-serde diff BASE TARGET
+//	arche-serde convert [flags] INPUT OUTPUT
+//
+// Command info prints the number of entities, component types with their counts, and resources.
+//
+// Command validate checks the structure of a file, like [archeserde.ValidateStructure].
+// It exits with status 1 if problems are found.
+//
+// Command diff compares two files by entity and component, and prints the differences.
+// It exits with status 1 if the files differ.
+// Component and resource values are compared by their JSON, ignoring formatting.
+//
+// Command convert writes a file with another layout, formatting or compression.
+// Use "-" as file name for standard input or output. Flags are:
+//
+//	-layout       entity, archetype or record (default entity)
+//	-format       pretty or compact (default pretty)
+//	-compression  none, gzip or zlib (default none)
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+)
+
+const usage = `Usage:
+  arche-serde info FILE
+  arche-serde validate FILE
+  arche-serde diff BASE TARGET
+  arche-serde convert [flags] INPUT OUTPUT
+
+Run 'arche-serde convert -h' for the flags of convert.
+`
+
+// errUsage is returned for invalid command lines.
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	status, err := run(os.Args[1:], os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "arche-serde: %s\n", err)
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "\n%s", usage)
+		}
+		os.Exit(2)
+	}
+	os.Exit(status)
+}
+
+// run runs a command, and returns the exit status.
+// Errors result in exit status 2.
+func run(args []string, stdin io.Reader, stdout io.Writer) (int, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("missing command: %w", errUsage)
+	}
+
+	switch args[0] {
+	case "info":
+		return info(args[1:], stdin, stdout)
+	case "validate":
+		return validate(args[1:], stdin, stdout)
+	case "diff":
+		return diff(args[1:], stdin, stdout)
+	case "convert":
+		return convert(args[1:], stdin, stdout)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unknown command '%s': %w", args[0], errUsage)
+	}
+}
+
+// info prints an overview of a file.
+func info(args []string, stdin io.Reader, stdout io.Writer) (int, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("wrong number of arguments: %w", errUsage)
+	}
+	file, err := readFile(args[0], stdin)
+	if err != nil {
+		return 0, err
+	}
+
+	counts := map[string]int{}
+	for _, comps := range file.Components {
+		for name := range comps {
+			counts[name]++
+		}
+	}
+	resources := make([]string, 0, len(file.Resources))
+	for name := range file.Resources {
+		resources = append(resources, name)
+	}
+	slices.Sort(resources)
+
+	pool := len(file.World.Entities) - 1
+	alive := len(file.World.Alive)
+
+	writer := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "Version\t%d\n", file.Version)
+	fmt.Fprintf(writer, "Entities\t%d\n", pool)
+	fmt.Fprintf(writer, "  alive\t%d\n", alive)
+	fmt.Fprintf(writer, "  dead\t%d\n", pool-alive)
+	fmt.Fprintf(writer, "  with relation target\t%d\n", len(file.Targets))
+	fmt.Fprintf(writer, "Components\t%d types\n", len(file.Types))
+	for _, name := range file.Types {
+		fmt.Fprintf(writer, "  %s\t%d\n", name, counts[name])
+	}
+	fmt.Fprintf(writer, "Resources\t%d\n", len(resources))
+	for _, name := range resources {
+		fmt.Fprintf(writer, "  %s\n", name)
+	}
+	return 0, writer.Flush()
+}
+
+// validate checks the structure of a file, and prints all problems found.
+func validate(args []string, stdin io.Reader, stdout io.Writer) (int, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("wrong number of arguments: %w", errUsage)
+	}
+	data, err := readInput(args[0], stdin)
+	if err != nil {
+		return 0, err
+	}
+
+	errs := archeserde.ValidateStructure(data)
+	for _, err := range errs {
+		fmt.Fprintln(stdout, err)
+	}
+	if len(errs) > 0 {
+		problems := "problems"
+		if len(errs) == 1 {
+			problems = "problem"
+		}
+		fmt.Fprintf(stdout, "%s: %d %s found\n", args[0], len(errs), problems)
+		return 1, nil
+	}
+	fmt.Fprintf(stdout, "%s: ok\n", args[0])
+	return 0, nil
+}
+
+// diff compares two files by entity and component, and prints the differences.
+//
+// Lines start with '+' for added, '-' for removed and '~' for changed entities and resources.
+// For changed entities, the added, removed and changed components are listed.
+func diff(args []string, stdin io.Reader, stdout io.Writer) (int, error) {
+	if len(args) != 2 {
+		return 0, fmt.Errorf("wrong number of arguments: %w", errUsage)
+	}
+	if args[0] == "-" && args[1] == "-" {
+		return 0, fmt.Errorf("standard input can be used for only one file: %w", errUsage)
+	}
+	base, err := readFile(args[0], stdin)
+	if err != nil {
+		return 0, err
+	}
+	target, err := readFile(args[1], stdin)
+	if err != nil {
+		return 0, err
+	}
+
+	lines := []string{}
+	if !slices.Equal(base.World.Entities, target.World.Entities) ||
+		base.World.Next != target.World.Next || base.World.Available != target.World.Available {
+		lines = append(lines, "~ entity pool")
+	}
+
+	entities := append(base.Entities(), target.Entities()...)
+	slices.SortFunc(entities, compareEntities)
+	entities = slices.Compact(entities)
+
+	for _, entity := range entities {
+		baseComps, inBase := base.Components[entity]
+		targetComps, inTarget := target.Components[entity]
+		switch {
+		case !inBase:
+			lines = append(lines, fmt.Sprintf("+ entity %v: %s", entity, strings.Join(sortedKeys(targetComps), ", ")))
+		case !inTarget:
+			lines = append(lines, fmt.Sprintf("- entity %v: %s", entity, strings.Join(sortedKeys(baseComps), ", ")))
+		default:
+			changes := compareValues(baseComps, targetComps)
+			baseTarget, baseOk := base.Targets[entity]
+			targetTarget, targetOk := target.Targets[entity]
+			if baseTarget != targetTarget || baseOk != targetOk {
+				changes = append(changes, fmt.Sprintf("target %v -> %v", baseTarget, targetTarget))
+			}
+			if len(changes) > 0 {
+				lines = append(lines, fmt.Sprintf("~ entity %v: %s", entity, strings.Join(changes, ", ")))
+			}
+		}
+	}
+
+	for _, change := range compareValues(base.Resources, target.Resources) {
+		lines = append(lines, change[:2]+"resource "+change[2:])
+	}
+
+	for _, line := range lines {
+		fmt.Fprintln(stdout, line)
+	}
+	if len(lines) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// compareValues compares values by name, and returns a list of "+ name" for added,
+// "- name" for removed and "~ name" for changed values, sorted by name.
+func compareValues(base, target map[string]json.RawMessage) []string {
+	names := append(sortedKeys(base), sortedKeys(target)...)
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	changes := []string{}
+	for _, name := range names {
+		baseValue, inBase := base[name]
+		targetValue, inTarget := target[name]
+		switch {
+		case !inBase:
+			changes = append(changes, "+ "+name)
+		case !inTarget:
+			changes = append(changes, "- "+name)
+		case !equalJSON(baseValue, targetValue):
+			changes = append(changes, "~ "+name)
+		}
+	}
+	return changes
+}
+
+// convert writes a file with another layout, formatting or compression.
+func convert(args []string, stdin io.Reader, stdout io.Writer) (int, error) {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	layout := flags.String("layout", "entity", "component layout: entity, archetype or record")
+	format := flags.String("format", "pretty", "formatting: pretty or compact")
+	compression := flags.String("compression", "none", "compression: none, gzip or zlib")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), "Usage:\n  arche-serde convert [flags] INPUT OUTPUT\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("%s: %w", err, errUsage)
+	}
+	if flags.NArg() != 2 {
+		return 0, fmt.Errorf("wrong number of arguments: %w", errUsage)
+	}
+
+	options := []archeserde.Option{}
+	switch *layout {
+	case "entity":
+		options = append(options, archeserde.Opts.Layout(archeserde.EntityLayout))
+	case "archetype":
+		options = append(options, archeserde.Opts.Layout(archeserde.ArchetypeLayout))
+	case "record":
+		options = append(options, archeserde.Opts.Layout(archeserde.RecordLayout))
+	default:
+		return 0, fmt.Errorf("unknown layout '%s', expected entity, archetype or record", *layout)
+	}
+	if *format != "pretty" && *format != "compact" {
+		return 0, fmt.Errorf("unknown format '%s', expected pretty or compact", *format)
+	}
+	compressions := map[string]archeserde.Compression{"none": archeserde.NoCompression, "gzip": archeserde.Gzip, "zlib": archeserde.Zlib}
+	comp, ok := compressions[*compression]
+	if !ok {
+		return 0, fmt.Errorf("unknown compression '%s', expected none, gzip or zlib", *compression)
+	}
+
+	file, err := readFile(flags.Arg(0), stdin)
+	if err != nil {
+		return 0, err
+	}
+	if *format == "pretty" {
+		options = append(options, archeserde.Opts.Compression(comp))
+	}
+	data, err := file.Marshal(options...)
+	if err != nil {
+		return 0, err
+	}
+	if *format == "compact" {
+		// Compacted before compression, which is therefore applied afterwards.
+		buffer := bytes.Buffer{}
+		if err := json.Compact(&buffer, data); err != nil {
+			return 0, err
+		}
+		buffer.WriteString("\n")
+		if data, err = archeserde.Compress(buffer.Bytes(), comp); err != nil {
+			return 0, err
+		}
+	}
+
+	if flags.Arg(1) == "-" {
+		_, err = stdout.Write(data)
+		return 0, err
+	}
+	return 0, os.WriteFile(flags.Arg(1), data, 0o644)
+}
+
+// readInput reads a file, or standard input for "-".
+func readInput(path string, stdin io.Reader) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// readFile reads and parses a file, or standard input for "-".
+func readFile(path string, stdin io.Reader) (*archeserde.File, error) {
+	data, err := readInput(path, stdin)
+	if err != nil {
+		return nil, err
+	}
+	file, err := archeserde.ReadFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+// equalJSON checks whether two JSON values are equal, ignoring formatting.
+func equalJSON(a, b json.RawMessage) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	bufA, bufB := bytes.Buffer{}, bytes.Buffer{}
+	if json.Compact(&bufA, a) != nil || json.Compact(&bufB, b) != nil {
+		return false
+	}
+	return bytes.Equal(bufA.Bytes(), bufB.Bytes())
+}
+
+// compareEntities orders entities by ID and generation.
+func compareEntities(a, b ecs.Entity) int {
+	if c := cmp.Compare(a.ID(), b.ID()); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.Generation(), b.Generation())
+}
+
+// sortedKeys returns the keys of a map, sorted.
+func sortedKeys(mp map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(mp))
+	for key := range mp {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+type Position struct {
+	X, Y float64
+}
+
+type Velocity struct {
+	X, Y float64
+}
+
+type ChildOf struct {
+	ecs.Relation
+}
+
+func writeWorld(t *testing.T, dir string, name string, modify func(w *ecs.World), options ...archeserde.Option) string {
+	w := ecs.NewWorld()
+	posID := ecs.ComponentID[Position](&w)
+	velID := ecs.ComponentID[Velocity](&w)
+	childID := ecs.ComponentID[ChildOf](&w)
+
+	parent := w.NewEntity(posID)
+	for i := 0; i < 4; i++ {
+		e := w.NewEntity(posID, velID, childID)
+		*(*Position)(w.Get(e, posID)) = Position{X: float64(i)}
+		w.Relations().Set(e, childID, parent)
+	}
+	w.RemoveEntity(w.NewEntity())
+	ecs.AddResource(&w, &Velocity{X: 1})
+
+	if modify != nil {
+		modify(&w)
+	}
+
+	jsonData, err := archeserde.Serialize(&w, options...)
+	assert.Nil(t, err)
+	path := filepath.Join(dir, name)
+	assert.Nil(t, os.WriteFile(path, jsonData, 0o644))
+	return path
+}
+
+func runCommand(args ...string) (int, string, error) {
+	out := bytes.Buffer{}
+	status, err := run(args, strings.NewReader(""), &out)
+	return status, out.String(), err
+}
+
+func TestInfo(t *testing.T) {
+	dir := t.TempDir()
+	path := writeWorld(t, dir, "world.json", nil, archeserde.Opts.Layout(archeserde.ArchetypeLayout))
+
+	status, out, err := runCommand("info", path)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, `Version                 1
+Entities                6
+  alive                 5
+  dead                  1
+  with relation target  4
+Components              3 types
+  main.Position         5
+  main.Velocity         4
+  main.ChildOf          4
+Resources               1
+  main.Velocity
+`, out)
+
+	_, _, err = runCommand("info", filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+
+	_, _, err = runCommand("info")
+	assert.ErrorIs(t, err, errUsage)
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	path := writeWorld(t, dir, "world.json", nil)
+
+	status, out, err := runCommand("validate", path)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, status)
+	assert.Contains(t, out, "world.json: ok")
+
+	broken := filepath.Join(dir, "broken.json")
+	assert.Nil(t, os.WriteFile(broken, []byte(`{"Version":1,"World":{"Entities":[[0,4294967295],[1,0]],"Alive":[1,1],"Next":0,"Available":0}}`), 0o644))
+	status, out, err = runCommand("validate", broken)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, status)
+	assert.Contains(t, out, "alive entity {1 0} is listed multiple times")
+	assert.Contains(t, out, "broken.json: 1 problem found")
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	base := writeWorld(t, dir, "base.json", nil)
+	same := writeWorld(t, dir, "same.json", nil, archeserde.Opts.Layout(archeserde.ArchetypeLayout), archeserde.Opts.Compression(archeserde.Gzip))
+	target := writeWorld(t, dir, "target.json", func(w *ecs.World) {
+		posID := ecs.ComponentID[Position](w)
+		velID := ecs.ComponentID[Velocity](w)
+		childID := ecs.ComponentID[ChildOf](w)
+		query := w.Query(ecs.All(velID))
+		entities := []ecs.Entity{}
+		for query.Next() {
+			entities = append(entities, query.Entity())
+		}
+		(*Position)(w.Get(entities[0], posID)).Y = 10
+		w.Remove(entities[1], velID)
+		w.Relations().Set(entities[2], childID, entities[0])
+		w.RemoveEntity(entities[3])
+		w.NewEntity(posID)
+		ecs.AddResource(w, &Position{})
+	})
+
+	status, out, err := runCommand("diff", base, same)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "", out)
+
+	status, out, err = runCommand("diff", base, target)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, status)
+	assert.Equal(t, `~ entity pool
+~ entity {2 0}: ~ main.Position
+~ entity {3 0}: - main.Velocity
+~ entity {4 0}: target {1 0} -> {2 0}
+- entity {5 0}: main.ChildOf, main.Position, main.Velocity
++ entity {5 1}: main.Position
++ resource main.Position
+`, out)
+
+	_, _, err = runCommand("diff", "-", "-")
+	assert.ErrorIs(t, err, errUsage)
+}
+
+func TestConvert(t *testing.T) {
+	dir := t.TempDir()
+	path := writeWorld(t, dir, "world.json", nil)
+
+	for _, args := range [][]string{
+		{},
+		{"-layout", "archetype"},
+		{"-compression", "gzip"},
+		{"-format", "compact", "-compression", "gzip"},
+		{"-layout", "archetype", "-format", "compact", "-compression", "zlib"},
+		{"-layout", "record"},
+	} {
+		out := filepath.Join(dir, "out.json")
+		status, _, err := runCommand(append(append([]string{"convert"}, args...), path, out)...)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, status)
+
+		status, diff, err := runCommand("diff", path, out)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, status)
+		assert.Equal(t, "", diff)
+
+		status, _, err = runCommand("validate", out)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, status)
+	}
+
+	status, out, err := runCommand("convert", "-format", "compact", path, "-")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, 1, strings.Count(out, "\n"))
+
+	_, _, err = runCommand("convert", "-layout", "columns", path, "-")
+	assert.Contains(t, err.Error(), "unknown layout 'columns'")
+
+	_, _, err = runCommand("convert", path)
+	assert.ErrorIs(t, err, errUsage)
+}
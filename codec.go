@@ -0,0 +1,114 @@
+package archeserde
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Codec provides custom encoding and decoding for a component or resource type.
+//
+// Codecs are used for types that can't be described with [encoding/json] tags,
+// like bitsets, fixed-point numbers or third-party types.
+// Register them with [Options.Codec].
+//
+// Encode receives a pointer to the value to encode, and returns its encoded form.
+// The encoded data must be valid JSON, so it can be embedded in JSON documents.
+// In the binary format, it is stored as is.
+//
+// Decode receives the encoded data, and a pointer to the value to decode into.
+type Codec struct {
+	Encode func(value any) ([]byte, error)
+	Decode func(data []byte, value any) error
+}
+
+// codecs maps types to their custom codecs.
+type codecs map[reflect.Type]Codec
+
+// marshalJSON encodes a value, given as a pointer, to JSON.
+// Uses the custom codec for the type if there is one, and [json.Marshal] otherwise.
+// Types with maps keyed by [ecs.Entity] are encoded via their shadow type.
+func (c codecs) marshalJSON(value reflect.Value) ([]byte, error) {
+	codec, ok := c[value.Type().Elem()]
+	if !ok {
+		if hasEntityMaps.of(value.Type().Elem()) {
+			return marshalShadow(value)
+		}
+		return json.Marshal(value.Interface())
+	}
+	data, err := codec.Encode(value.Interface())
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("codec for %s returned invalid JSON: %q", value.Type().Elem(), data)
+	}
+	return data, nil
+}
+
+// unmarshalJSON decodes JSON into a value, given as a pointer.
+// Uses the custom codec for the type if there is one, and [json.Unmarshal] otherwise.
+func (c codecs) unmarshalJSON(data []byte, value reflect.Value) error {
+	if codec, ok := c[value.Type().Elem()]; ok {
+		return codec.Decode(data, value.Interface())
+	}
+	if hasEntityMaps.of(value.Type().Elem()) {
+		return unmarshalShadow(data, value)
+	}
+	return json.Unmarshal(data, value.Interface())
+}
+
+// unmarshalColumn decodes a JSON array of values of the given type into a slice.
+// Uses the custom codec for the type if there is one, for each value.
+// Values are also decoded one by one for types with maps keyed by [ecs.Entity].
+func (c codecs) unmarshalColumn(data []byte, tp reflect.Type) (reflect.Value, error) {
+	if _, ok := c[tp]; !ok && !hasEntityMaps.of(tp) {
+		column := reflect.New(reflect.SliceOf(tp))
+		if err := json.Unmarshal(data, column.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+		return column.Elem(), nil
+	}
+
+	values := []entry{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return reflect.Value{}, err
+	}
+	column := reflect.MakeSlice(reflect.SliceOf(tp), len(values), len(values))
+	for i, value := range values {
+		if err := c.unmarshalJSON(value.Bytes, column.Index(i).Addr()); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	return column, nil
+}
+
+// appendBinary encodes an addressable value in the binary format,
+// and appends it to buf, prefixed by its length.
+// Uses the custom codec for the type if there is one.
+// The scratch buffer is used for intermediate encoding, and returned for reuse.
+func (c codecs) appendBinary(buf, scratch []byte, value reflect.Value) ([]byte, []byte, error) {
+	data := scratch[:0]
+	var err error
+	if codec, ok := c[value.Type()]; ok {
+		if data, err = codec.Encode(value.Addr().Interface()); err != nil {
+			return buf, scratch, err
+		}
+	} else if data, err = encodeBinaryValue(data, value); err != nil {
+		return buf, scratch, err
+	} else {
+		scratch = data
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...), scratch, nil
+}
+
+// decodeBinary decodes binary data into an addressable value.
+// Uses the custom codec for the type if there is one.
+func (c codecs) decodeBinary(data []byte, value reflect.Value) error {
+	if codec, ok := c[value.Type()]; ok {
+		return codec.Decode(data, value.Addr().Interface())
+	}
+	return decodeBinaryValue(data, value)
+}
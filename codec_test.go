@@ -0,0 +1,109 @@
+package archeserde_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/mlange-42/arche/generic"
+	"github.com/stretchr/testify/assert"
+)
+
+// Bits is a bitset without exported fields.
+type Bits struct {
+	bits uint64
+}
+
+var bitsCodec = archeserde.Codec{
+	Encode: func(value any) ([]byte, error) {
+		return json.Marshal(strconv.FormatUint(value.(*Bits).bits, 2))
+	},
+	Decode: func(data []byte, value any) error {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		bits, err := strconv.ParseUint(str, 2, 64)
+		if err != nil {
+			return err
+		}
+		value.(*Bits).bits = bits
+		return nil
+	},
+}
+
+func TestCodec(t *testing.T) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	bitsId := ecs.ComponentID[Bits](&w)
+
+	e1 := w.NewEntity(posId, bitsId)
+	*(*Bits)(w.Get(e1, bitsId)) = Bits{bits: 5}
+	e2 := w.NewEntity(bitsId)
+	*(*Bits)(w.Get(e2, bitsId)) = Bits{bits: 6}
+	_ = ecs.AddResource(&w, &Bits{bits: 7})
+
+	opt := archeserde.Opts.Codec(generic.T[Bits](), bitsCodec)
+
+	newWorld := func() ecs.World {
+		w2 := ecs.NewWorld()
+		_ = ecs.ComponentID[Position](&w2)
+		_ = ecs.ComponentID[Bits](&w2)
+		_ = ecs.ResourceID[Bits](&w2)
+		return w2
+	}
+	check := func(w2 *ecs.World) {
+		assert.Equal(t, Bits{bits: 5}, *(*Bits)(w2.Get(e1, bitsId)))
+		assert.Equal(t, Bits{bits: 6}, *(*Bits)(w2.Get(e2, bitsId)))
+		assert.Equal(t, Bits{bits: 7}, *ecs.GetResource[Bits](w2))
+	}
+
+	for _, layout := range []archeserde.Layout{archeserde.EntityLayout, archeserde.ArchetypeLayout} {
+		jsonData, err := archeserde.Serialize(&w, opt, archeserde.Opts.Layout(layout))
+		assert.Nil(t, err)
+		assert.Contains(t, string(jsonData), `"101"`)
+		assert.Contains(t, string(jsonData), `"111"`)
+
+		w2 := newWorld()
+		err = archeserde.Deserialize(jsonData, &w2, opt)
+		assert.Nil(t, err)
+		check(&w2)
+	}
+
+	binData, err := archeserde.SerializeBinary(&w, opt)
+	assert.Nil(t, err)
+
+	w2 := newWorld()
+	err = archeserde.DeserializeBinary(binData, &w2, opt)
+	assert.Nil(t, err)
+	check(&w2)
+}
+
+func TestCodecErrors(t *testing.T) {
+	w := ecs.NewWorld()
+	bitsId := ecs.ComponentID[Bits](&w)
+	w.NewEntity(bitsId)
+
+	_, err := archeserde.Serialize(&w, archeserde.Opts.Codec(generic.T[Bits](), archeserde.Codec{
+		Encode: func(value any) ([]byte, error) { return []byte("0b101"), nil },
+	}))
+	assert.Contains(t, err.Error(), "codec for archeserde_test.Bits returned invalid JSON")
+
+	_, err = archeserde.SerializeBinary(&w, archeserde.Opts.Codec(generic.T[Bits](), archeserde.Codec{
+		Encode: func(value any) ([]byte, error) { return nil, fmt.Errorf("test error") },
+	}))
+	assert.Contains(t, err.Error(), "test error")
+
+	jsonData, err := archeserde.Serialize(&w, archeserde.Opts.Codec(generic.T[Bits](), bitsCodec))
+	assert.Nil(t, err)
+
+	w2 := ecs.NewWorld()
+	_ = ecs.ComponentID[Bits](&w2)
+	err = archeserde.Deserialize(jsonData, &w2, archeserde.Opts.Codec(generic.T[Bits](), archeserde.Codec{
+		Decode: func(data []byte, value any) error { return fmt.Errorf("test error") },
+	}))
+	assert.Contains(t, err.Error(), "test error")
+}
@@ -0,0 +1,111 @@
+package archeserde
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+)
+
+// Compression of serialized output.
+//
+// Set it with [Options.Compression].
+// When deserializing, compressed input is detected automatically.
+type Compression uint8
+
+const (
+	// NoCompression writes uncompressed output. This is the default.
+	NoCompression Compression = iota
+	// Gzip compresses output with [compress/gzip].
+	Gzip
+	// Zlib compresses output with [compress/zlib].
+	Zlib
+)
+
+// Compress compresses data, like serialized output with [Options.Compression].
+// Returns the data unchanged for [NoCompression].
+//
+// Use it for output that is processed after serializing, e.g. to reformat it.
+func Compress(data []byte, compression Compression) ([]byte, error) {
+	if compression == NoCompression {
+		return data, nil
+	}
+	buffer := bytes.Buffer{}
+	writer := compressWriter(&buffer, compression)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// compressWriter wraps a writer for compressed output.
+// The returned writer must be closed to flush the compressed data.
+func compressWriter(w io.Writer, compression Compression) io.WriteCloser {
+	switch compression {
+	case Gzip:
+		return gzip.NewWriter(w)
+	case Zlib:
+		return zlib.NewWriter(w)
+	default:
+		return nopWriteCloser{w}
+	}
+}
+
+// decompressReader detects compressed input by its magic bytes,
+// and wraps the reader for decompression if required.
+// Uncompressed input is returned as is, apart from buffering.
+func decompressReader(r io.Reader) (io.Reader, error) {
+	reader := bufio.NewReader(r)
+	magic, _ := reader.Peek(2)
+
+	switch detectCompression(magic) {
+	case Gzip:
+		return gzip.NewReader(reader)
+	case Zlib:
+		return zlib.NewReader(reader)
+	default:
+		return reader, nil
+	}
+}
+
+// decompressBytes decompresses data if it is compressed, and returns it as is otherwise.
+func decompressBytes(data []byte) ([]byte, error) {
+	if detectCompression(data) == NoCompression {
+		return data, nil
+	}
+	reader, err := decompressReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// detectCompression detects the compression of data from its first bytes.
+//
+// Neither JSON nor the binary format can start with these magic bytes,
+// so there is no ambiguity with uncompressed data.
+func detectCompression(magic []byte) Compression {
+	if len(magic) < 2 {
+		return NoCompression
+	}
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		return Gzip
+	}
+	if magic[0]&0x0f == 8 && (uint16(magic[0])<<8|uint16(magic[1]))%31 == 0 {
+		return Zlib
+	}
+	return NoCompression
+}
+
+// nopWriteCloser is an [io.WriteCloser] with a no-op Close method.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package archeserde_test
+
+import (
+	"bytes"
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompression(t *testing.T) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	for i := 0; i < 100; i++ {
+		e := w.NewEntity(posId)
+		*(*Position)(w.Get(e, posId)) = Position{X: float64(i)}
+	}
+	_ = ecs.AddResource(&w, &Velocity{X: 1000})
+
+	plain, err := archeserde.Serialize(&w)
+	assert.Nil(t, err)
+	plainBin, err := archeserde.SerializeBinary(&w)
+	assert.Nil(t, err)
+
+	newWorld := func() ecs.World {
+		w2 := ecs.NewWorld()
+		_ = ecs.ComponentID[Position](&w2)
+		_ = ecs.ResourceID[Velocity](&w2)
+		return w2
+	}
+
+	for _, compression := range []archeserde.Compression{archeserde.NoCompression, archeserde.Gzip, archeserde.Zlib} {
+		opt := archeserde.Opts.Compression(compression)
+
+		jsonData, err := archeserde.Serialize(&w, opt)
+		assert.Nil(t, err)
+		if compression != archeserde.NoCompression {
+			assert.Less(t, len(jsonData), len(plain)/4)
+		}
+
+		w2 := newWorld()
+		assert.Nil(t, archeserde.Deserialize(jsonData, &w2))
+		assert.Equal(t, w.DumpEntities(), w2.DumpEntities())
+		assert.Equal(t, Velocity{X: 1000}, *ecs.GetResource[Velocity](&w2))
+
+		w2 = newWorld()
+		assert.Nil(t, archeserde.DeserializeFrom(bytes.NewReader(jsonData), &w2))
+		assert.Equal(t, w.DumpEntities(), w2.DumpEntities())
+
+		binData, err := archeserde.SerializeBinary(&w, opt)
+		assert.Nil(t, err)
+		if compression != archeserde.NoCompression {
+			assert.Less(t, len(binData), len(plainBin))
+		}
+
+		w2 = newWorld()
+		assert.Nil(t, archeserde.DeserializeBinary(binData, &w2))
+		assert.Equal(t, w.DumpEntities(), w2.DumpEntities())
+
+		delta, err := archeserde.Diff(plain, jsonData)
+		assert.Nil(t, err)
+		assert.Contains(t, string(delta), `"Changes" : []`)
+
+		compressed, err := archeserde.Compress(plain, compression)
+		assert.Nil(t, err)
+		assert.Equal(t, jsonData, compressed)
+	}
+}
+
+func TestCompressionErrors(t *testing.T) {
+	w := ecs.NewWorld()
+
+	err := archeserde.Deserialize([]byte{0x1f, 0x8b, 0, 0}, &w)
+	assert.NotNil(t, err)
+
+	err = archeserde.DeserializeBinary([]byte{0x78, 0x9c, 0, 0}, &w)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,519 @@
+package archeserde
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// Diff computes the delta between two JSON snapshots, as written by [Serialize].
+//
+// The delta contains:
+//   - Changes to the entity pool
+//   - Entities created and removed
+//   - Components added, removed and changed, including relation targets
+//   - Resources added, removed and changed
+//
+// Component values are compared by their serialized JSON.
+// Thus, both snapshots should be written with the same options.
+// Snapshots in all layouts and format versions are supported.
+// Only JSON snapshots are supported, not the binary format.
+//
+// Use [ApplyDelta] to restore the target snapshot from the base snapshot and the delta.
+// See [SerializeDelta] for computing the delta between a snapshot and a world.
+func Diff(base, target []byte) ([]byte, error) {
+	baseSnap, err := readSnapshot(base)
+	if err != nil {
+		return nil, err
+	}
+	targetSnap, err := readSnapshot(target)
+	if err != nil {
+		return nil, err
+	}
+	return baseSnap.diff(targetSnap)
+}
+
+// SerializeDelta computes the delta between a JSON snapshot, as written by [Serialize], and the current state of a world.
+//
+// The world is serialized with the given options, which should be the same as for the base snapshot.
+// See [Diff] for details.
+func SerializeDelta(base []byte, world *ecs.World, options ...Option) ([]byte, error) {
+	target, err := Serialize(world, options...)
+	if err != nil {
+		return nil, err
+	}
+	return Diff(base, target)
+}
+
+// ApplyDelta applies a delta, as written by [Diff] or [SerializeDelta], to a base snapshot,
+// and deserializes the result into a world.
+//
+// The base snapshot must be the same as the one used to compute the delta.
+// The world must be prepared like for [Deserialize], and options are applied like for [Deserialize].
+func ApplyDelta(base []byte, delta []byte, world *ecs.World, options ...Option) error {
+	opts := newSerdeOptions(options...)
+
+	snap, err := readSnapshot(base)
+	if err != nil {
+		return err
+	}
+	d, err := readDelta(delta)
+	if err != nil {
+		return err
+	}
+	if err := snap.apply(d); err != nil {
+		return err
+	}
+	return snap.load(world, nil, &opts)
+}
+
+// snapshot is a JSON snapshot of a world, with the components of each entity keyed by type name.
+// Relation targets are stored like components, under [targetTag].
+type snapshot struct {
+	Version    int
+	World      ecs.EntityDump
+	Types      []string
+	Components map[ecs.Entity]map[string]json.RawMessage
+	Resources  map[string]json.RawMessage
+}
+
+// snapshotDoc is a JSON document in any layout.
+type snapshotDoc struct {
+	Version    int
+	World      ecs.EntityDump
+	Types      []string
+	Components []map[string]json.RawMessage
+	Archetypes []struct {
+		Types      []string
+		Target     json.RawMessage
+		Entities   []ecs.Entity
+		Components []json.RawMessage
+	}
+	Free      []ecs.Entity
+	Entities  []entityRecord
+	Resources map[string]json.RawMessage
+}
+
+// deltaDoc is a delta between two snapshots.
+type deltaDoc struct {
+	Version          int
+	Pool             *poolDelta
+	Created          []ecs.Entity
+	Removed          []ecs.Entity
+	Changes          []entityDelta
+	Resources        map[string]json.RawMessage
+	RemovedResources []string
+}
+
+// poolDelta is the change of the entity pool between two snapshots.
+type poolDelta struct {
+	Length    int
+	Changed   map[uint32]ecs.Entity
+	Next      uint32
+	Available uint32
+	// Order of the alive entities, only present if it differs from the order of the base snapshot,
+	// without removed entities and followed by created entities.
+	Alive []uint32 `json:",omitempty"`
+}
+
+// entityDelta is the change of the components of an entity between two snapshots.
+type entityDelta struct {
+	Entity ecs.Entity
+	Set    map[string]json.RawMessage `json:",omitempty"`
+	Remove []string                   `json:",omitempty"`
+}
+
+// readSnapshot reads a JSON snapshot.
+func readSnapshot(data []byte) (*snapshot, error) {
+	data, err := decompressBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte(binaryMagic)) {
+		return nil, fmt.Errorf("snapshots in the binary format are not supported, only JSON")
+	}
+	doc := snapshotDoc{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Version < 0 || doc.Version > jsonVersion {
+		return nil, fmt.Errorf("unsupported format version %d, supported versions are 0 to %d", doc.Version, jsonVersion)
+	}
+	if doc.Entities != nil {
+		if doc.World.Entities != nil {
+			return nil, errWorldAndRecords
+		}
+		dump, errs := recordDump(recordEntities(doc.Entities), doc.Free)
+		if len(errs) > 0 {
+			return nil, errs[0]
+		}
+		doc.World = dump
+	}
+
+	snap := snapshot{
+		Version:    doc.Version,
+		World:      doc.World,
+		Types:      doc.Types,
+		Components: make(map[ecs.Entity]map[string]json.RawMessage, len(doc.World.Alive)),
+		Resources:  doc.Resources,
+	}
+	for _, idx := range doc.World.Alive {
+		if int(idx) >= len(doc.World.Entities) {
+			return nil, fmt.Errorf("alive entity index %d out of range of %d entities", idx, len(doc.World.Entities))
+		}
+		snap.Components[doc.World.Entities[idx]] = map[string]json.RawMessage{}
+	}
+
+	if doc.Components != nil {
+		if len(doc.Components) != len(doc.World.Alive) {
+			return nil, fmt.Errorf("found components for %d entities, but world has %d alive entities", len(doc.Components), len(doc.World.Alive))
+		}
+		for i, comps := range doc.Components {
+			snap.Components[doc.World.Entities[doc.World.Alive[i]]] = comps
+		}
+	}
+
+	for _, record := range doc.Entities {
+		comps := snap.Components[record.Entity]
+		if record.Target != nil {
+			comps[targetTag] = record.Target
+		}
+		for tpName, value := range record.Components {
+			comps[tpName] = value
+		}
+	}
+
+	for _, arch := range doc.Archetypes {
+		if len(arch.Components) != len(arch.Types) {
+			return nil, fmt.Errorf("found %d component columns for %d types in archetype", len(arch.Components), len(arch.Types))
+		}
+		columns := make([][]json.RawMessage, len(arch.Types))
+		for i, tpName := range arch.Types {
+			if err := json.Unmarshal(arch.Components[i], &columns[i]); err != nil {
+				return nil, err
+			}
+			if len(columns[i]) != len(arch.Entities) {
+				return nil, fmt.Errorf("found %d values of %s for %d entities in archetype", len(columns[i]), tpName, len(arch.Entities))
+			}
+		}
+		for j, entity := range arch.Entities {
+			comps, ok := snap.Components[entity]
+			if !ok {
+				return nil, fmt.Errorf("entity %v in archetype is not alive", entity)
+			}
+			if arch.Target != nil {
+				comps[targetTag] = arch.Target
+			}
+			for i, tpName := range arch.Types {
+				comps[tpName] = columns[i][j]
+			}
+		}
+	}
+
+	if snap.Resources == nil {
+		snap.Resources = map[string]json.RawMessage{}
+	}
+	return &snap, nil
+}
+
+// readDelta reads a delta, as written by [Diff].
+func readDelta(data []byte) (*deltaDoc, error) {
+	d := deltaDoc{}
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	if d.Pool == nil {
+		return nil, fmt.Errorf("invalid delta: missing section 'Pool'")
+	}
+	if d.Pool.Length < 1 {
+		return nil, fmt.Errorf("invalid delta: entity pool length %d, must be at least 1", d.Pool.Length)
+	}
+	if d.Version < 1 || d.Version > jsonVersion {
+		return nil, fmt.Errorf("unsupported format version %d, supported versions for deltas are 1 to %d", d.Version, jsonVersion)
+	}
+	return &d, nil
+}
+
+// diff computes the delta from this snapshot to the target snapshot.
+func (s *snapshot) diff(target *snapshot) ([]byte, error) {
+	d := deltaDoc{
+		Version: jsonVersion,
+		Pool: &poolDelta{
+			Length:    len(target.World.Entities),
+			Changed:   map[uint32]ecs.Entity{},
+			Next:      target.World.Next,
+			Available: target.World.Available,
+		},
+		Created:          []ecs.Entity{},
+		Removed:          []ecs.Entity{},
+		Changes:          []entityDelta{},
+		Resources:        map[string]json.RawMessage{},
+		RemovedResources: []string{},
+	}
+
+	for i, entity := range target.World.Entities {
+		if i >= len(s.World.Entities) || s.World.Entities[i] != entity {
+			d.Pool.Changed[uint32(i)] = entity
+		}
+	}
+
+	order := make([]uint32, 0, len(target.World.Alive))
+	for _, idx := range s.World.Alive {
+		entity := s.World.Entities[idx]
+		if _, ok := target.Components[entity]; !ok {
+			d.Removed = append(d.Removed, entity)
+		} else {
+			order = append(order, idx)
+		}
+	}
+
+	for _, idx := range target.World.Alive {
+		entity := target.World.Entities[idx]
+		comps := target.Components[entity]
+		baseComps, ok := s.Components[entity]
+		if !ok {
+			d.Created = append(d.Created, entity)
+			order = append(order, idx)
+		}
+
+		change := entityDelta{Entity: entity, Set: map[string]json.RawMessage{}}
+		for name, value := range comps {
+			if baseValue, ok := baseComps[name]; !ok || !bytes.Equal(value, baseValue) {
+				change.Set[name] = value
+			}
+		}
+		for name := range baseComps {
+			if _, ok := comps[name]; !ok {
+				change.Remove = append(change.Remove, name)
+			}
+		}
+		if len(change.Set) > 0 || len(change.Remove) > 0 {
+			slices.Sort(change.Remove)
+			d.Changes = append(d.Changes, change)
+		}
+	}
+
+	if !slices.Equal(order, target.World.Alive) {
+		d.Pool.Alive = target.World.Alive
+	}
+
+	for name, value := range target.Resources {
+		if baseValue, ok := s.Resources[name]; !ok || !bytes.Equal(value, baseValue) {
+			d.Resources[name] = value
+		}
+	}
+	for name := range s.Resources {
+		if _, ok := target.Resources[name]; !ok {
+			d.RemovedResources = append(d.RemovedResources, name)
+		}
+	}
+	slices.Sort(d.RemovedResources)
+
+	return d.marshal()
+}
+
+// marshal writes the delta as JSON, with one line per entity change.
+func (d *deltaDoc) marshal() ([]byte, error) {
+	buffer := bytes.Buffer{}
+	writer := bufio.NewWriter(&buffer)
+
+	writer.WriteString("{\n")
+	fmt.Fprintf(writer, "\"Version\" : %d,\n", d.Version)
+
+	sections := []struct {
+		name  string
+		value any
+	}{
+		{"Pool", d.Pool},
+		{"Created", d.Created},
+		{"Removed", d.Removed},
+	}
+	for _, section := range sections {
+		jsonData, err := json.Marshal(section.value)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(writer, "%s : %s,\n", quote(section.name), jsonData)
+	}
+
+	writer.WriteString("\"Changes\" : [")
+	for i, change := range d.Changes {
+		jsonData, err := json.Marshal(change)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			writer.WriteString(",")
+		}
+		writer.WriteString("\n  ")
+		writer.Write(jsonData)
+	}
+	if len(d.Changes) > 0 {
+		writer.WriteString("\n")
+	}
+	writer.WriteString("],\n")
+
+	jsonData, err := json.Marshal(d.Resources)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(writer, "\"Resources\" : %s,\n", jsonData)
+	if jsonData, err = json.Marshal(d.RemovedResources); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(writer, "\"RemovedResources\" : %s\n", jsonData)
+	writer.WriteString("}\n")
+
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// apply applies a delta to the snapshot.
+func (s *snapshot) apply(d *deltaDoc) error {
+	// Entities beyond the base pool are always listed as changed.
+	if maxLength := len(s.World.Entities) + len(d.Pool.Changed); d.Pool.Length > maxLength {
+		return fmt.Errorf("delta has an entity pool length of %d, but can have at most %d entities", d.Pool.Length, maxLength)
+	}
+	entities := make([]ecs.Entity, d.Pool.Length)
+	copy(entities, s.World.Entities)
+	for idx, entity := range d.Pool.Changed {
+		if int(idx) >= len(entities) {
+			return fmt.Errorf("entity index %d out of range of %d entities", idx, len(entities))
+		}
+		entities[idx] = entity
+	}
+
+	removed := make(map[ecs.Entity]bool, len(d.Removed))
+	for _, entity := range d.Removed {
+		if _, ok := s.Components[entity]; !ok {
+			return fmt.Errorf("delta removes entity %v, which is not alive in the base snapshot", entity)
+		}
+		removed[entity] = true
+		delete(s.Components, entity)
+	}
+
+	alive := make([]uint32, 0, len(s.World.Alive)+len(d.Created)-len(d.Removed))
+	for _, idx := range s.World.Alive {
+		if !removed[s.World.Entities[idx]] {
+			alive = append(alive, idx)
+		}
+	}
+	for _, entity := range d.Created {
+		if _, ok := s.Components[entity]; ok {
+			return fmt.Errorf("delta creates entity %v, which is already alive in the base snapshot", entity)
+		}
+		s.Components[entity] = map[string]json.RawMessage{}
+		alive = append(alive, entity.ID())
+	}
+
+	if d.Pool.Alive != nil {
+		if len(d.Pool.Alive) != len(alive) {
+			return fmt.Errorf("delta has an order of %d alive entities, but %d entities are alive", len(d.Pool.Alive), len(alive))
+		}
+		isAlive := make(map[uint32]bool, len(alive))
+		for _, idx := range alive {
+			isAlive[idx] = true
+		}
+		for _, idx := range d.Pool.Alive {
+			if !isAlive[idx] {
+				return fmt.Errorf("delta has alive entity index %d in its order, which is not alive or listed multiple times", idx)
+			}
+			isAlive[idx] = false
+		}
+		alive = d.Pool.Alive
+	}
+
+	for _, idx := range alive {
+		if int(idx) >= len(entities) {
+			return fmt.Errorf("alive entity index %d out of range of %d entities", idx, len(entities))
+		}
+		if _, ok := s.Components[entities[idx]]; !ok {
+			return fmt.Errorf("alive entity index %d does not match the entity pool of the delta", idx)
+		}
+	}
+
+	s.World = ecs.EntityDump{
+		Entities:  entities,
+		Alive:     alive,
+		Next:      d.Pool.Next,
+		Available: d.Pool.Available,
+	}
+
+	for _, change := range d.Changes {
+		comps, ok := s.Components[change.Entity]
+		if !ok {
+			return fmt.Errorf("delta changes entity %v, which is not alive", change.Entity)
+		}
+		for _, name := range change.Remove {
+			delete(comps, name)
+		}
+		for name, value := range change.Set {
+			comps[name] = value
+		}
+	}
+
+	for _, name := range d.RemovedResources {
+		delete(s.Resources, name)
+	}
+	for name, value := range d.Resources {
+		s.Resources[name] = value
+	}
+	return nil
+}
+
+// load deserializes the snapshot into a world.
+//
+// If remap is not nil, new entities are created like for [DeserializeMerge], and recorded in remap.
+func (s *snapshot) load(world *ecs.World, remap entityRemap, opts *serdeOptions) error {
+	deserial := deserializer{World: s.World, Resources: map[string]entry{}, remap: remap}
+	for name, value := range s.Resources {
+		deserial.Resources[name] = entry{Bytes: value}
+	}
+
+	if !opts.skipEntities {
+		// Types of the document first, to keep their order for registration.
+		// Types added by deltas follow, in the order of their names.
+		names := map[string]bool{}
+		for _, name := range s.Types {
+			names[name] = true
+		}
+		added := []string{}
+		for _, comps := range s.Components {
+			for name := range comps {
+				if name != targetTag && !names[name] {
+					names[name] = true
+					added = append(added, name)
+				}
+			}
+		}
+		slices.Sort(added)
+		deserial.Types = append(slices.Clone(s.Types), added...)
+
+		deserial.loadEntities(world)
+		loader, err := newComponentLoader(world, &deserial, opts)
+		if err != nil {
+			return err
+		}
+		mp := map[string]entry{}
+		for i, idx := range s.World.Alive {
+			entity := s.World.Entities[idx]
+			clear(mp)
+			if !opts.skipAllComponents {
+				for name, value := range s.Components[entity] {
+					mp[name] = entry{Bytes: value}
+				}
+			}
+			if err := loader.load(loader.entity(idx), mp); err != nil {
+				return newError(SectionComponents, i, entity, "", err)
+			}
+		}
+	}
+
+	return deserializeResources(world, &deserial, opts)
+}
@@ -0,0 +1,145 @@
+package archeserde_test
+
+import (
+	"strings"
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelta(t *testing.T) {
+	for _, layout := range []archeserde.Layout{archeserde.EntityLayout, archeserde.ArchetypeLayout} {
+		w := ecs.NewWorld()
+		posId := ecs.ComponentID[Position](&w)
+		velId := ecs.ComponentID[Velocity](&w)
+		relId := ecs.ComponentID[ChildRelation](&w)
+		_ = ecs.AddResource(&w, &Velocity{X: 1000})
+
+		parent1 := w.NewEntity(posId)
+		parent2 := w.NewEntity(posId)
+		child := w.NewEntity(posId, relId)
+		w.Relations().Set(child, relId, parent1)
+		moving := w.NewEntity(posId, velId)
+		removed := w.NewEntity(posId)
+		unchanged := w.NewEntity(posId)
+		*(*Position)(w.Get(unchanged, posId)) = Position{X: 99}
+
+		opt := archeserde.Opts.Layout(layout)
+		base, err := archeserde.Serialize(&w, opt)
+		assert.Nil(t, err)
+
+		w.RemoveEntity(removed)
+		created := w.NewEntity(posId, velId)
+		*(*Velocity)(w.Get(created, velId)) = Velocity{X: 5}
+		*(*Position)(w.Get(moving, posId)) = Position{X: 1, Y: 2}
+		w.Remove(moving, velId)
+		w.Add(parent2, velId)
+		w.Relations().Set(child, relId, parent2)
+		ecs.GetResource[Velocity](&w).X = 2000
+
+		delta, err := archeserde.SerializeDelta(base, &w, opt)
+		assert.Nil(t, err)
+		assert.NotContains(t, string(delta), `{"X":99,"Y":0}`)
+
+		target, err := archeserde.Serialize(&w, opt)
+		assert.Nil(t, err)
+		delta2, err := archeserde.Diff(base, target)
+		assert.Nil(t, err)
+		assert.Equal(t, delta, delta2)
+
+		w2 := ecs.NewWorld()
+		posId2 := ecs.ComponentID[Position](&w2)
+		velId2 := ecs.ComponentID[Velocity](&w2)
+		relId2 := ecs.ComponentID[ChildRelation](&w2)
+		_ = ecs.ResourceID[Velocity](&w2)
+
+		err = archeserde.ApplyDelta(base, delta, &w2)
+		assert.Nil(t, err)
+
+		dump, dump2 := w.DumpEntities(), w2.DumpEntities()
+		assert.Equal(t, dump.Entities, dump2.Entities)
+		assert.Equal(t, dump.Next, dump2.Next)
+		assert.Equal(t, dump.Available, dump2.Available)
+		assert.Equal(t, dump.Alive, dump2.Alive)
+
+		assert.False(t, w2.Alive(removed))
+		assert.Equal(t, Velocity{X: 5}, *(*Velocity)(w2.Get(created, velId2)))
+		assert.Equal(t, Position{X: 1, Y: 2}, *(*Position)(w2.Get(moving, posId2)))
+		assert.False(t, w2.Has(moving, velId2))
+		assert.True(t, w2.Has(parent2, velId2))
+		assert.Equal(t, parent2, w2.Relations().Get(child, relId2))
+		assert.Equal(t, Position{X: 99}, *(*Position)(w2.Get(unchanged, posId2)))
+		assert.Equal(t, Velocity{X: 2000}, *ecs.GetResource[Velocity](&w2))
+
+		target2, err := archeserde.Serialize(&w2, opt, archeserde.Opts.Canonical())
+		assert.Nil(t, err)
+		target, err = archeserde.Serialize(&w, opt, archeserde.Opts.Canonical())
+		assert.Nil(t, err)
+		assert.Equal(t, string(target), string(target2))
+	}
+}
+
+func TestDeltaEmpty(t *testing.T) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	w.NewEntity(posId)
+
+	base, err := archeserde.Serialize(&w)
+	assert.Nil(t, err)
+	delta, err := archeserde.SerializeDelta(base, &w)
+	assert.Nil(t, err)
+	assert.Contains(t, string(delta), `"Changes" : []`)
+	assert.Contains(t, string(delta), `"Created" : []`)
+	assert.Contains(t, string(delta), `"Removed" : []`)
+	assert.NotContains(t, string(delta), `"Alive"`)
+}
+
+func TestDeltaErrors(t *testing.T) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	e := w.NewEntity(posId)
+
+	base, err := archeserde.Serialize(&w)
+	assert.Nil(t, err)
+	w.RemoveEntity(e)
+	delta, err := archeserde.SerializeDelta(base, &w)
+	assert.Nil(t, err)
+
+	w2 := ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&w2)
+	err = archeserde.ApplyDelta(base, []byte(`{"Version" : 1}`), &w2)
+	assert.Contains(t, err.Error(), "missing section 'Pool'")
+
+	err = archeserde.ApplyDelta(base, []byte(strings.Replace(string(delta), `"Version" : 2`, `"Version" : 99`, 1)), &w2)
+	assert.Contains(t, err.Error(), "unsupported format version 99")
+
+	err = archeserde.ApplyDelta(base, []byte(strings.Replace(string(delta), `"Version" : 2`, `"Version" : 0`, 1)), &w2)
+	assert.Contains(t, err.Error(), "unsupported format version 0")
+
+	err = archeserde.ApplyDelta(base, []byte(`{"Version" : 2, "Pool" : {"Length" : -1}}`), &w2)
+	assert.Contains(t, err.Error(), "entity pool length -1")
+
+	err = archeserde.ApplyDelta(base, []byte(`{"Version" : 2, "Pool" : {"Length" : 1099511627776}}`), &w2)
+	assert.Contains(t, err.Error(), "delta has an entity pool length of 1099511627776, but can have at most 2 entities")
+
+	empty := ecs.NewWorld()
+	emptyBase, err := archeserde.Serialize(&empty)
+	assert.Nil(t, err)
+	err = archeserde.ApplyDelta(emptyBase, delta, &w2)
+	assert.Contains(t, err.Error(), "not alive in the base snapshot")
+
+	_, err = archeserde.Diff([]byte("{}"), []byte("{xxx}"))
+	assert.Contains(t, err.Error(), "invalid character 'x'")
+
+	binData, err := archeserde.SerializeBinary(&w)
+	assert.Nil(t, err)
+	_, err = archeserde.Diff(binData, base)
+	assert.Contains(t, err.Error(), "snapshots in the binary format are not supported")
+
+	reordered := strings.Replace(string(delta), `"Available":1}`, `"Available":1,"Alive":[1]}`, 1)
+	assert.NotEqual(t, string(delta), reordered)
+	err = archeserde.ApplyDelta(base, []byte(reordered), &w2)
+	assert.Contains(t, err.Error(), "delta has an order of 1 alive entities, but 0 entities are alive")
+}
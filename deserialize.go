@@ -1,8 +1,10 @@
 package archeserde
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"reflect"
 	"slices"
 
@@ -13,14 +15,23 @@ import (
 //
 // The world must be prepared the following way:
 //   - The world must not contain any alive or dead entities (i.e. a new or [ecs.World.Reset] world)
-//   - All required component types must be registered using [ecs.ComponentID]
-//   - All required resources must be added as dummies using [ecs.AddResource]
+//   - All required component types must be registered using [ecs.ComponentID],
+//     or be contained in the [Registry] given via [Options.Registry]
+//   - All required resource types must be registered using [ecs.ResourceID] or [ecs.AddResource],
+//     or be contained in the [Registry] given via [Options.Registry]
+//
+// Resources that are not present in the world are created and added.
+// Use [Options.ResourceFactory] for resources that need real construction.
+//
+// Compressed input, as written with option [Options.Compression], is detected and decompressed automatically.
 //
 // The options can be used to skip some or all components,
 // entities entirely, and/or some or all resources.
 // It only some components or resources are skipped,
 // they still need to be registered to the world.
 //
+// See [DeserializeFrom] for reading directly from an [io.Reader].
+//
 // # Query iteration order
 //
 // After deserialization, it is not guaranteed that entity iteration order in queries is the same as before.
@@ -28,125 +39,402 @@ import (
 // happen when continuing the original, serialized run. Multiple worlds deserialized from the same source should,
 // however, behave exactly the same.
 func Deserialize(jsonData []byte, world *ecs.World, options ...Option) error {
+	return DeserializeFrom(bytes.NewReader(jsonData), world, options...)
+}
+
+// DeserializeFrom deserializes an Arche [ecs.World] from JSON read from the given [io.Reader].
+//
+// In contrast to [Deserialize], the document is not read into memory as a whole.
+// Sections are processed as they are read, and components are decoded and added entity by entity.
+// This requires the "World" and "Types" sections to precede the "Components" or "Archetypes" section,
+// as written by [Serialize] and [SerializeTo].
+// Otherwise, components are buffered until the whole document has been read.
+// For [RecordLayout], the "Entities" section is always read as a whole.
+//
+// The format version is detected from the "Version" entry.
+// Documents without a version are read in the layout of arche-serde v0.2.
+// Documents with a version that is not supported by this version of arche-serde result in an error.
+//
+// See [Deserialize] for details on world preparation and options.
+func DeserializeFrom(r io.Reader, world *ecs.World, options ...Option) error {
 	opts := newSerdeOptions(options...)
+	return deserializeJSON(r, world, &deserializer{}, &opts)
+}
+
+// deserializeJSON reads the JSON document section by section.
+func deserializeJSON(r io.Reader, world *ecs.World, deserial *deserializer, opts *serdeOptions) error {
+	r, err := decompressReader(r)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(r)
 
-	deserial := deserializer{}
-	if err := json.Unmarshal(jsonData, &deserial); err != nil {
+	if err := expectDelim(dec, '{', reflect.TypeOf(*deserial)); err != nil {
 		return err
 	}
 
-	if !opts.skipEntities {
-		world.LoadEntities(&deserial.World)
+	hasWorld, hasTypes, hasRecords := false, false, false
+	// When merging, resources are remapped, so they are loaded only after the entities are created.
+	created := false
+	var pending, pendingArchetypes []json.RawMessage
+	var pendingResources json.RawMessage
+	var pendingRecords []entityRecord
+	var free []ecs.Entity
+
+	version := 0
+	for first := true; dec.More(); first = false {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := token.(string)
+
+		if key == "Version" {
+			if !first {
+				return fmt.Errorf("entry 'Version' must be the first entry of the document")
+			}
+			if version, err = readVersion(dec); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := checkSection(version, key); err != nil {
+			return err
+		}
+
+		switch key {
+		case "World":
+			if hasRecords {
+				return errWorldAndRecords
+			}
+			if err := dec.Decode(&deserial.World); err != nil {
+				return sectionError(SectionWorld, err)
+			}
+			hasWorld = true
+			if !opts.skipEntities {
+				deserial.loadEntities(world)
+				created = true
+			}
+		case "Types":
+			if err := dec.Decode(&deserial.Types); err != nil {
+				return sectionError(SectionTypes, err)
+			}
+			hasTypes = true
+		case "Components":
+			if opts.skipEntities || !hasWorld || !hasTypes {
+				if err := dec.Decode(&pending); err != nil {
+					return sectionError(SectionComponents, err)
+				}
+				continue
+			}
+			if err := streamComponents(world, dec, deserial, opts); err != nil {
+				return err
+			}
+		case "Archetypes":
+			if opts.skipEntities || !hasWorld || !hasTypes {
+				if err := dec.Decode(&pendingArchetypes); err != nil {
+					return sectionError(SectionArchetypes, err)
+				}
+				continue
+			}
+			if err := streamArchetypes(world, dec, deserial, opts); err != nil {
+				return err
+			}
+		case "Free":
+			if hasRecords {
+				return sectionError(SectionFree, fmt.Errorf("section '%s' must precede section '%s'", SectionFree, SectionEntities))
+			}
+			if err := dec.Decode(&free); err != nil {
+				return sectionError(SectionFree, err)
+			}
+		case "Entities":
+			if hasWorld {
+				return errWorldAndRecords
+			}
+			records := []entityRecord{}
+			if err := dec.Decode(&records); err != nil {
+				return sectionError(SectionEntities, err)
+			}
+			hasRecords = true
+			if opts.skipEntities {
+				continue
+			}
+			if !hasTypes {
+				pendingRecords = records
+				continue
+			}
+			if err := loadRecords(world, records, free, deserial, opts); err != nil {
+				return err
+			}
+			created = true
+		case "Resources":
+			if deserial.remap != nil && !created && !opts.skipEntities {
+				if err := dec.Decode(&pendingResources); err != nil {
+					return sectionError(SectionResources, err)
+				}
+				continue
+			}
+			if err := dec.Decode(&deserial.Resources); err != nil {
+				return sectionError(SectionResources, err)
+			}
+			if err := deserializeResources(world, deserial, opts); err != nil {
+				return err
+			}
+		default:
+			if err := dec.Decode(&json.RawMessage{}); err != nil {
+				return err
+			}
+		}
 	}
 
-	if err := deserializeComponents(world, &deserial, &opts); err != nil {
+	if _, err := dec.Token(); err != nil {
 		return err
 	}
-	if err := deserializeResources(world, &deserial, &opts); err != nil {
+
+	if pending != nil {
+		if err := bufferedComponents(world, pending, deserial, opts); err != nil {
+			return err
+		}
+	}
+	if pendingArchetypes != nil {
+		if err := bufferedArchetypes(world, pendingArchetypes, deserial, opts); err != nil {
+			return err
+		}
+	}
+	if pendingRecords != nil {
+		if err := loadRecords(world, pendingRecords, free, deserial, opts); err != nil {
+			return err
+		}
+	}
+	if pendingResources != nil {
+		if err := json.Unmarshal(pendingResources, &deserial.Resources); err != nil {
+			return sectionError(SectionResources, err)
+		}
+		if err := deserializeResources(world, deserial, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errWorldAndRecords is the error for documents with an entity dump as well as entity records.
+var errWorldAndRecords = fmt.Errorf("sections '%s' and '%s' can't be used together", SectionWorld, SectionEntities)
+
+// streamComponents decodes and adds components entity by entity, directly from the decoder.
+func streamComponents(world *ecs.World, dec *json.Decoder, deserial *deserializer, opts *serdeOptions) error {
+	loader, err := newComponentLoader(world, deserial, opts)
+	if err != nil {
 		return err
 	}
 
+	if err := expectDelim(dec, '[', reflect.TypeOf(deserial.Components)); err != nil {
+		return sectionError(SectionComponents, err)
+	}
+
+	alive := deserial.World.Alive
+	count := 0
+	mp := map[string]entry{}
+	for dec.More() {
+		if count >= len(alive) {
+			// Count the remaining entities for a meaningful error message.
+			if err := dec.Decode(&json.RawMessage{}); err != nil {
+				return newError(SectionComponents, count, ecs.Entity{}, "", err)
+			}
+			count++
+			continue
+		}
+
+		entity := deserial.World.Entities[alive[count]]
+		clear(mp)
+		if err := dec.Decode(&mp); err != nil {
+			return newError(SectionComponents, count, entity, "", err)
+		}
+		if err := loader.load(loader.entity(alive[count]), mp); err != nil {
+			return newError(SectionComponents, count, entity, "", err)
+		}
+		count++
+	}
+	if _, err := dec.Token(); err != nil {
+		return sectionError(SectionComponents, err)
+	}
+
+	if count != len(alive) {
+		return sectionError(SectionComponents, fmt.Errorf("found components for %d entities, but world has %d alive entities", count, len(alive)))
+	}
 	return nil
 }
 
-func deserializeComponents(world *ecs.World, deserial *deserializer, opts *serdeOptions) error {
+// bufferedComponents adds components from a fully read "Components" section.
+// Used if the section is found before the "World" and "Types" sections.
+func bufferedComponents(world *ecs.World, components []json.RawMessage, deserial *deserializer, opts *serdeOptions) error {
 	if opts.skipEntities {
 		return nil
 	}
 
+	loader, err := newComponentLoader(world, deserial, opts)
+	if err != nil {
+		return err
+	}
+
+	if len(components) != len(deserial.World.Alive) {
+		return sectionError(SectionComponents, fmt.Errorf("found components for %d entities, but world has %d alive entities", len(components), len(deserial.World.Alive)))
+	}
+
+	mp := map[string]entry{}
+	for i, comps := range components {
+		idx := deserial.World.Alive[i]
+		clear(mp)
+		if err := json.Unmarshal(comps, &mp); err != nil {
+			return newError(SectionComponents, i, deserial.World.Entities[idx], "", err)
+		}
+		if err := loader.load(loader.entity(idx), mp); err != nil {
+			return newError(SectionComponents, i, deserial.World.Entities[idx], "", err)
+		}
+	}
+	return nil
+}
+
+// componentLoader adds deserialized components to entities.
+type componentLoader struct {
+	world          *ecs.World
+	deserial       *deserializer
+	infos          map[ecs.ID]ecs.CompInfo
+	ids            map[string]ecs.ID
+	skipComponents ecs.Mask
+	codecs         codecs
+}
+
+func newComponentLoader(world *ecs.World, deserial *deserializer, opts *serdeOptions) (*componentLoader, error) {
+	registerTypes(world, deserial.Types, opts.registry)
+
 	infos := map[ecs.ID]ecs.CompInfo{}
 	ids := map[string]ecs.ID{}
+	ambiguous := map[string]bool{}
 	allComps := ecs.ComponentIDs(world)
 	for _, id := range allComps {
 		if info, ok := ecs.ComponentInfo(world, id); ok {
 			infos[id] = info
-			ids[info.Type.String()] = id
+			for _, name := range opts.registry.lookupNames(info.Type) {
+				if _, ok := ids[name]; ok {
+					ambiguous[name] = true
+				}
+				ids[name] = id
+			}
 		}
 	}
 
 	for _, tp := range deserial.Types {
 		if _, ok := ids[tp]; !ok {
-			return fmt.Errorf("component type is not registered: %s", tp)
+			return nil, newError(SectionTypes, -1, ecs.Entity{}, tp, fmt.Errorf("component type is not registered: %s", tp))
+		}
+		if ambiguous[tp] {
+			return nil, newError(SectionTypes, -1, ecs.Entity{}, tp, fmt.Errorf("component type name is ambiguous: %s; use a Registry to assign unique names", tp))
 		}
-	}
-
-	if len(deserial.Components) != len(deserial.World.Alive) {
-		return fmt.Errorf("found components for %d entities, but world has %d alive entities", len(deserial.Components), len(deserial.World.Alive))
 	}
 
 	skipComponents := ecs.Mask{}
-	for _, tp := range opts.skipComponents {
-		id := ecs.TypeID(world, tp)
-		skipComponents.Set(id, true)
+	for id, info := range infos {
+		if opts.skipComponent(info.Type) {
+			skipComponents.Set(id, true)
+		}
 	}
 
-	for i, comps := range deserial.Components {
-		entity := deserial.World.Entities[deserial.World.Alive[i]]
-
-		mp := map[string]entry{}
+	return &componentLoader{
+		world:          world,
+		deserial:       deserial,
+		infos:          infos,
+		ids:            ids,
+		skipComponents: skipComponents,
+		codecs:         opts.codecs,
+	}, nil
+}
 
-		if err := json.Unmarshal(comps.Bytes, &mp); err != nil {
-			return err
+// registerTypes registers the component types of the given names in the world,
+// in the order of the names.
+// Names that are not in the registry are ignored.
+func registerTypes(world *ecs.World, names []string, registry *Registry) {
+	for _, name := range names {
+		if tp, ok := registry.Type(name); ok {
+			_ = ecs.TypeID(world, tp)
 		}
+	}
+}
 
-		target := ecs.Entity{}
-		var targetComp ecs.ID
-		hasRelation := false
-		components := make([]ecs.Component, 0, len(mp))
-		compIDs := make([]ecs.ID, 0, len(mp))
-		for tpName, value := range mp {
-			if tpName == targetTag {
-				if err := json.Unmarshal(value.Bytes, &target); err != nil {
-					return err
-				}
-				continue
-			}
+// entity returns the entity to load components into, for an index in the entity dump.
+func (l *componentLoader) entity(index uint32) ecs.Entity {
+	entity := l.deserial.World.Entities[index]
+	if l.deserial.remap != nil {
+		return l.deserial.remap[entity]
+	}
+	return entity
+}
 
-			id := ids[tpName]
-			if skipComponents.Get(id) {
-				continue
+// load adds the given components to an entity.
+func (l *componentLoader) load(entity ecs.Entity, mp map[string]entry) error {
+	target := ecs.Entity{}
+	var targetComp ecs.ID
+	hasRelation := false
+	components := make([]reflect.Value, 0, len(mp))
+	compIDs := make([]ecs.ID, 0, len(mp))
+	for tpName, value := range mp {
+		if tpName == targetTag {
+			if err := json.Unmarshal(value.Bytes, &target); err != nil {
+				return typeError(tpName, err)
 			}
+			continue
+		}
 
-			info := infos[id]
+		id, ok := l.ids[tpName]
+		if !ok {
+			return typeError(tpName, fmt.Errorf("component type is not registered: %s", tpName))
+		}
+		if l.skipComponents.Get(id) {
+			continue
+		}
 
-			if info.IsRelation {
-				targetComp = id
-				hasRelation = true
-			}
+		info := l.infos[id]
 
-			component := reflect.New(info.Type).Interface()
-			if err := json.Unmarshal(value.Bytes, &component); err != nil {
-				return err
-			}
-			compIDs = append(compIDs, id)
-			components = append(components, ecs.Component{
-				ID:   id,
-				Comp: component,
-			})
+		if info.IsRelation {
+			targetComp = id
+			hasRelation = true
 		}
 
-		if len(components) == 0 {
-			continue
+		component := reflect.New(info.Type)
+		if err := l.codecs.unmarshalJSON(value.Bytes, component); err != nil {
+			return typeError(tpName, err)
 		}
+		compIDs = append(compIDs, id)
+		components = append(components, component.Elem())
+	}
 
-		if !hasRelation {
-			target = ecs.Entity{}
-		}
+	l.apply(entity, compIDs, components, targetComp, hasRelation, target)
+	return nil
+}
+
+// apply adds decoded components to an entity, and sets the relation target.
+func (l *componentLoader) apply(entity ecs.Entity, ids []ecs.ID, components []reflect.Value, targetComp ecs.ID, hasRelation bool, target ecs.Entity) {
+	if len(components) == 0 {
+		return
+	}
 
-		world.Add(entity, compIDs...)
+	if remap := l.deserial.remap; remap != nil {
 		for _, comp := range components {
-			assign(world, entity, comp.ID, comp.Comp)
-		}
-		if !target.IsZero() {
-			world.Relations().Set(entity, targetComp, target)
+			remap.remapValue(comp)
 		}
+		target = remap.get(target)
+	}
+
+	l.world.Add(entity, ids...)
+	for i, comp := range components {
+		assignValue(l.world, entity, ids[i], comp)
+	}
+	if hasRelation && !target.IsZero() {
+		l.world.Relations().Set(entity, targetComp, target)
 	}
-	return nil
 }
 
-func assign(world *ecs.World, entity ecs.Entity, id ecs.ID, comp interface{}) {
+func assignValue(world *ecs.World, entity ecs.Entity, id ecs.ID, rValue reflect.Value) {
 	dst := world.Get(entity, id)
-	rValue := reflect.ValueOf(comp).Elem()
 
 	valueType := rValue.Type()
 	valuePtr := reflect.NewAt(valueType, dst)
@@ -158,43 +446,168 @@ func deserializeResources(world *ecs.World, deserial *deserializer, opts *serdeO
 		return nil
 	}
 
+	// Resources are loaded in the order of their names,
+	// so that resource types are registered in a deterministic order.
+	names := make([]string, 0, len(deserial.Resources))
+	for tpName := range deserial.Resources {
+		names = append(names, tpName)
+	}
+	slices.Sort(names)
+
+	loader := newResourceLoader(world, deserial, opts)
+	for _, tpName := range names {
+		res := deserial.Resources[tpName]
+		value, err := loader.target(tpName)
+		if err != nil {
+			return newError(SectionResources, -1, ecs.Entity{}, tpName, err)
+		}
+		if !value.IsValid() {
+			continue
+		}
+
+		if err := opts.codecs.unmarshalJSON(res.Bytes, value); err != nil {
+			return newError(SectionResources, -1, ecs.Entity{}, tpName, err)
+		}
+		loader.remap(value)
+	}
+	return nil
+}
+
+// resourceLoader provides the world's resources for deserializing into.
+type resourceLoader struct {
+	world         *ecs.World
+	deserial      *deserializer
+	resTypes      map[ecs.ResID]reflect.Type
+	resIds        map[string]ecs.ResID
+	ambiguous     map[string]bool
+	skipResources ecs.Mask
+	registry      *Registry
+	factories     map[reflect.Type]func() any
+}
+
+func newResourceLoader(world *ecs.World, deserial *deserializer, opts *serdeOptions) *resourceLoader {
 	resTypes := map[ecs.ResID]reflect.Type{}
 	resIds := map[string]ecs.ResID{}
+	ambiguous := map[string]bool{}
 	allRes := ecs.ResourceIDs(world)
 	skipResources := ecs.Mask{}
 	for _, id := range allRes {
 		if tp, ok := ecs.ResourceType(world, id); ok {
 			resTypes[id] = tp
-			resIds[tp.String()] = id
+			for _, name := range opts.registry.lookupNames(tp) {
+				if _, ok := resIds[name]; ok {
+					ambiguous[name] = true
+				}
+				resIds[name] = id
+			}
 
-			if slices.Contains(opts.skipResources, tp) {
+			if opts.skipResource(tp) {
 				skipResources.Set(ecs.ID(id), true)
 			}
 		}
 	}
 
-	for tpName, res := range deserial.Resources {
-		resID, ok := resIds[tpName]
+	return &resourceLoader{
+		world:         world,
+		deserial:      deserial,
+		resTypes:      resTypes,
+		resIds:        resIds,
+		ambiguous:     ambiguous,
+		skipResources: skipResources,
+		registry:      opts.registry,
+		factories:     opts.resourceFactories,
+	}
+}
+
+// target returns a pointer to the resource with the given type name.
+// Returns an invalid value if the resource is skipped.
+//
+// Resource types from the registry that are not registered in the world are registered.
+// Resources that are not present in the world are created.
+func (l *resourceLoader) target(tpName string) (reflect.Value, error) {
+	resID, ok := l.resIds[tpName]
+	if !ok {
+		tp, ok := l.registry.Type(tpName)
 		if !ok {
-			return fmt.Errorf("resource type is not registered: %s", tpName)
+			return reflect.Value{}, fmt.Errorf("resource type is not registered: %s", tpName)
 		}
-		if skipResources.Get(ecs.ID(resID)) {
-			continue
+		resID = ecs.ResourceTypeID(l.world, tp)
+		l.resTypes[resID] = tp
+		l.resIds[tpName] = resID
+	}
+	if l.ambiguous[tpName] {
+		return reflect.Value{}, fmt.Errorf("resource type name is ambiguous: %s; use a Registry to assign unique names", tpName)
+	}
+	if l.skipResources.Get(ecs.ID(resID)) {
+		return reflect.Value{}, nil
+	}
+
+	tp := l.resTypes[resID]
+
+	resLoc := l.world.Resources().Get(resID)
+	if resLoc == nil {
+		var err error
+		if resLoc, err = l.create(resID, tp); err != nil {
+			return reflect.Value{}, err
 		}
+	}
 
-		tp := resTypes[resID]
+	ptr := reflect.ValueOf(resLoc).UnsafePointer()
+	return reflect.NewAt(tp, ptr), nil
+}
 
-		resLoc := world.Resources().Get(resID)
-		if resLoc == nil {
-			return fmt.Errorf("resource type registered but nil: %s", tpName)
+// create creates a resource and adds it to the world.
+// Uses the factory for the type if there is one, or a zero value otherwise.
+func (l *resourceLoader) create(id ecs.ResID, tp reflect.Type) (any, error) {
+	var res any
+	if factory, ok := l.factories[tp]; ok {
+		res = factory()
+		if reflect.TypeOf(res) != reflect.PointerTo(tp) || reflect.ValueOf(res).IsNil() {
+			return nil, fmt.Errorf("resource factory for %s returned %T, expected non-nil *%s", tp, res, tp)
 		}
+	} else {
+		res = reflect.New(tp).Interface()
+	}
+	l.world.Resources().Add(id, res)
+	return res, nil
+}
 
-		ptr := reflect.ValueOf(resLoc).UnsafePointer()
-		value := reflect.NewAt(tp, ptr).Interface()
+// remap rewrites the entities in a deserialized resource, given as a pointer, when merging.
+func (l *resourceLoader) remap(value reflect.Value) {
+	if l.deserial.remap != nil {
+		l.deserial.remap.remapValue(value.Elem())
+	}
+}
 
-		if err := json.Unmarshal(res.Bytes, &value); err != nil {
-			return err
+// expectDelim reads the next token and checks that it is the given delimiter.
+// Otherwise, it returns an error like [json.Unmarshal] would when decoding into the given type.
+func expectDelim(dec *json.Decoder, delim json.Delim, tp reflect.Type) error {
+	offset := dec.InputOffset()
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); ok && d == delim {
+		return nil
+	}
+	return &json.UnmarshalTypeError{Value: tokenKind(tok), Type: tp, Offset: offset}
+}
+
+// tokenKind returns the JSON kind of a token, as used in [json.UnmarshalTypeError].
+func tokenKind(tok json.Token) string {
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '{' {
+			return "object"
 		}
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case nil:
+		return "null"
+	default:
+		return "number"
 	}
-	return nil
 }
@@ -2,6 +2,7 @@ package archeserde_test
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	archeserde "github.com/mlange-42/arche-serde"
@@ -186,7 +187,8 @@ func TestDeserializeErrors(t *testing.T) {
 	_ = ecs.ResourceID[Velocity](&world)
 
 	err = archeserde.Deserialize([]byte(textOk), &world)
-	assert.Contains(t, err.Error(), "resource type registered but nil")
+	assert.Nil(t, err)
+	assert.True(t, world.Resources().Has(ecs.ResourceID[Velocity](&world)))
 
 	world.Reset()
 	_ = ecs.AddResource(&world, &Velocity{})
@@ -347,3 +349,205 @@ const textErrResource = `{
 	"Resources" : {
 		"archeserde_test.Velocity" : []
 	}}`
+
+func TestDeserializeSectionOrder(t *testing.T) {
+	world := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&world)
+	_ = ecs.ComponentID[Velocity](&world)
+	_ = ecs.ComponentID[ChildOf](&world)
+	_ = ecs.AddResource(&world, &Velocity{})
+
+	err := archeserde.DeserializeFrom(strings.NewReader(textComponentsFirst), &world)
+	assert.Nil(t, err)
+
+	query := world.Query(ecs.All(posId))
+	assert.Equal(t, 2, query.Count())
+	query.Close()
+
+	res := ecs.GetResource[Velocity](&world)
+	assert.Equal(t, Velocity{X: 1000}, *res)
+
+	world.Reset()
+	_ = ecs.AddResource(&world, &Velocity{})
+	err = archeserde.DeserializeFrom(strings.NewReader(textErrEntitiesExtra), &world)
+	assert.Contains(t, err.Error(), "found components for 3 entities, but world has 2 alive entities")
+}
+
+const textComponentsFirst = `{
+	"Components" : [
+	  {
+		"archeserde_test.Position" : {"X":1,"Y":2}
+	  },
+	  {
+		"archeserde_test.Position" : {"X":3,"Y":4},
+		"archeserde_test.Velocity" : {"X":5,"Y":6},
+		"archeserde_test.ChildOf" : {"Entity":[1,0]}
+	  }
+	],
+	"Resources" : {
+		"archeserde_test.Velocity" : {"X":1000,"Y":0}
+	},
+	"Types" : [
+	  "archeserde_test.Velocity",
+	  "archeserde_test.ChildOf",
+	  "archeserde_test.Position"
+	],
+	"World" : {"Entities":[[0,4294967295],[1,0],[2,0]],"Alive":[1,2],"Next":0,"Available":0}
+	}`
+
+const textErrEntitiesExtra = `{
+	"World" : {"Entities":[[0,4294967295],[1,0],[2,0]],"Alive":[1,2],"Next":0,"Available":0},
+	"Types" : [
+		"archeserde_test.Position"
+	],
+	"Components" : [
+		{},
+		{},
+		{}
+	],
+	"Resources" : {
+		"archeserde_test.Velocity" : {"X":1000,"Y":0}
+	}}`
+
+func TestDeserializeArchetypeErrors(t *testing.T) {
+	world := ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&world)
+
+	err := archeserde.Deserialize([]byte(fmt.Sprintf(textArchetypes, `[[1,0]]`, `[{"X":1}]`)), &world)
+	assert.Nil(t, err)
+
+	world.Reset()
+	_ = ecs.ComponentID[Position](&world)
+	err = archeserde.Deserialize([]byte(fmt.Sprintf(textArchetypes, `[[1,0]]`, `[{"X":1}],[]`)), &world)
+	assert.Contains(t, err.Error(), "found 2 component columns for 1 types")
+
+	world.Reset()
+	_ = ecs.ComponentID[Position](&world)
+	err = archeserde.Deserialize([]byte(fmt.Sprintf(textArchetypes, `[[1,0]]`, `[{"X":1},{"X":2}]`)), &world)
+	assert.Contains(t, err.Error(), "found 2 values of archeserde_test.Position for 1 entities")
+
+	world.Reset()
+	_ = ecs.ComponentID[Position](&world)
+	err = archeserde.Deserialize([]byte(fmt.Sprintf(textArchetypes, `[[5,0]]`, `[{"X":1}]`)), &world)
+	assert.Contains(t, err.Error(), "is not alive")
+
+	world.Reset()
+	_ = ecs.ComponentID[Position](&world)
+	err = archeserde.Deserialize([]byte(fmt.Sprintf(textArchetypes, `[[1,0],[1,0]]`, `[{"X":1},{"X":2}]`)), &world)
+	assert.Contains(t, err.Error(), "is contained in multiple archetypes")
+
+	world.Reset()
+	_ = ecs.ComponentID[Position](&world)
+	err = archeserde.Deserialize([]byte(fmt.Sprintf(textArchetypes, `[[1,0]]`, `[[]]`)), &world)
+	assert.Contains(t, err.Error(), "cannot unmarshal array")
+}
+
+const textArchetypes = `{
+	"Version" : 1,
+	"World" : {"Entities":[[0,4294967295],[1,0]],"Alive":[1],"Next":0,"Available":0},
+	"Types" : [
+	  "archeserde_test.Position"
+	],
+	"Archetypes" : [
+	  {
+		"Types" : ["archeserde_test.Position"],
+		"Entities" : %s,
+		"Components" : [%s]
+	  }
+	],
+	"Resources" : {}
+	}`
+
+func TestDeserializeVersion(t *testing.T) {
+	world := ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&world)
+
+	err := archeserde.Deserialize([]byte(`{"Version" : 1, "World" : {"Entities":[[0,4294967295]],"Alive":[],"Next":0,"Available":0}}`), &world)
+	assert.Nil(t, err)
+
+	world.Reset()
+	err = archeserde.Deserialize([]byte(`{"Version" : 999, "World" : {"Entities":[[0,4294967295]],"Alive":[],"Next":0,"Available":0}}`), &world)
+	assert.Contains(t, err.Error(), "unsupported format version 999")
+
+	world.Reset()
+	err = archeserde.Deserialize([]byte(`{"Version" : "1"}`), &world)
+	assert.Contains(t, err.Error(), "cannot unmarshal string")
+
+	world.Reset()
+	err = archeserde.Deserialize([]byte(`{"World" : {"Entities":[[0,4294967295]],"Alive":[],"Next":0,"Available":0}, "Version" : 1}`), &world)
+	assert.Contains(t, err.Error(), "entry 'Version' must be the first entry")
+
+	world.Reset()
+	err = archeserde.Deserialize([]byte(`{"Archetypes" : []}`), &world)
+	assert.Contains(t, err.Error(), "section 'Archetypes' is not supported by files without version")
+
+	world.Reset()
+	err = archeserde.Deserialize([]byte(`{"Version" : 0, "World" : {"Entities":[[0,4294967295]],"Alive":[],"Next":0,"Available":0}}`), &world)
+	assert.Nil(t, err)
+
+	world.Reset()
+	err = archeserde.Deserialize([]byte(`{"Version" : 0, "Archetypes" : []}`), &world)
+	assert.Contains(t, err.Error(), "section 'Archetypes' is not supported by files without version")
+
+	world.Reset()
+	err = archeserde.Deserialize([]byte(`{"Version" : -1}`), &world)
+	assert.Contains(t, err.Error(), "unsupported format version -1")
+
+	world.Reset()
+	err = archeserde.Deserialize([]byte(`{"Version" : 1, "Unknown" : [], "Archetypes" : []}`), &world)
+	assert.Nil(t, err)
+}
+
+type Counter struct {
+	Count int
+	step  int
+}
+
+func TestDeserializeCreateResources(t *testing.T) {
+	w := ecs.NewWorld()
+	_ = ecs.AddResource(&w, &Velocity{X: 1000})
+	_ = ecs.AddResource(&w, &Position{X: 2000})
+	_ = ecs.AddResource(&w, &Counter{Count: 5, step: 2})
+
+	jsonData, err := archeserde.Serialize(&w)
+	assert.Nil(t, err)
+	binData, err := archeserde.SerializeBinary(&w)
+	assert.Nil(t, err)
+
+	factory := archeserde.Opts.ResourceFactory(generic.T[Counter](), func() any { return &Counter{step: 2} })
+	reg := archeserde.NewRegistry().Register(generic.T[Position](), "archeserde_test.Position")
+
+	check := func(w2 *ecs.World) {
+		assert.Equal(t, Velocity{X: 1000}, *ecs.GetResource[Velocity](w2))
+		assert.Equal(t, Position{X: 2000}, *ecs.GetResource[Position](w2))
+		assert.Equal(t, Counter{Count: 5, step: 2}, *ecs.GetResource[Counter](w2))
+	}
+
+	w2 := ecs.NewWorld()
+	_ = ecs.ResourceID[Velocity](&w2)
+	_ = ecs.ResourceID[Counter](&w2)
+	err = archeserde.Deserialize(jsonData, &w2, factory, archeserde.Opts.Registry(reg))
+	assert.Nil(t, err)
+	check(&w2)
+
+	w2 = ecs.NewWorld()
+	_ = ecs.ResourceID[Velocity](&w2)
+	_ = ecs.ResourceID[Counter](&w2)
+	err = archeserde.DeserializeBinary(binData, &w2, factory, archeserde.Opts.Registry(reg))
+	assert.Nil(t, err)
+	check(&w2)
+
+	w2 = ecs.NewWorld()
+	_ = ecs.ResourceID[Velocity](&w2)
+	_ = ecs.ResourceID[Counter](&w2)
+	err = archeserde.Deserialize(jsonData, &w2, archeserde.Opts.SkipResources(generic.T[Position]()))
+	assert.Contains(t, err.Error(), "resource type is not registered: archeserde_test.Position")
+
+	w2 = ecs.NewWorld()
+	_ = ecs.ResourceID[Velocity](&w2)
+	_ = ecs.ResourceID[Counter](&w2)
+	_ = ecs.ResourceID[Position](&w2)
+	err = archeserde.Deserialize(jsonData, &w2,
+		archeserde.Opts.ResourceFactory(generic.T[Counter](), func() any { return Counter{} }))
+	assert.Contains(t, err.Error(), "resource factory for archeserde_test.Counter returned archeserde_test.Counter")
+}
@@ -0,0 +1,276 @@
+package archeserde
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// typeProperty is a property of types that holds if it holds for any type reachable from a type,
+// i.e. for any pointer, slice, array or map element, map key, or struct field considered by [encoding/json].
+// Results are cached per type.
+type typeProperty struct {
+	// match decides the property for a type directly, if done is true.
+	// Otherwise, the property is derived from the reachable types.
+	match func(tp reflect.Type) (result bool, done bool)
+	cache map[reflect.Type]bool
+	lock  sync.RWMutex
+}
+
+// of returns whether the property holds for a type.
+func (p *typeProperty) of(tp reflect.Type) bool {
+	p.lock.RLock()
+	result, ok := p.cache[tp]
+	p.lock.RUnlock()
+	if ok {
+		return result
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	result, _ = p.build(tp, map[reflect.Type]bool{})
+	return result
+}
+
+// build derives the property for a type. Must be called with the lock held.
+//
+// For recursive types, types currently visited are assumed not to have the property.
+// Results that depend on this assumption are incomplete, and are not cached.
+func (p *typeProperty) build(tp reflect.Type, visiting map[reflect.Type]bool) (result bool, complete bool) {
+	if result, ok := p.cache[tp]; ok {
+		return result, true
+	}
+	if visiting[tp] {
+		return false, false
+	}
+	if result, done := p.match(tp); done {
+		p.cache[tp] = result
+		return result, true
+	}
+
+	visiting[tp] = true
+	defer delete(visiting, tp)
+
+	complete = true
+	check := func(t reflect.Type) bool {
+		r, c := p.build(t, visiting)
+		complete = complete && c
+		return r
+	}
+
+	switch tp.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		result = check(tp.Elem())
+	case reflect.Map:
+		result = check(tp.Key()) || check(tp.Elem())
+	case reflect.Struct:
+		for i := 0; i < tp.NumField() && !result; i++ {
+			if field := tp.Field(i); isJSONField(field) {
+				result = check(field.Type)
+			}
+		}
+	}
+
+	if result || complete {
+		p.cache[tp] = result
+	}
+	return result, result || complete
+}
+
+// isJSONField checks whether a struct field is considered by [encoding/json].
+// These are exported fields and embedded structs, except for fields tagged with `json:"-"`.
+func isJSONField(field reflect.StructField) bool {
+	if field.Tag.Get("json") == "-" {
+		return false
+	}
+	if field.IsExported() {
+		return true
+	}
+	if !field.Anonymous {
+		return false
+	}
+	tp := field.Type
+	if tp.Kind() == reflect.Pointer {
+		tp = tp.Elem()
+	}
+	return tp.Kind() == reflect.Struct
+}
+
+// hasEntities is the property of types that their values can contain entities.
+// Interfaces are assumed to contain entities, as their dynamic values can.
+var hasEntities = typeProperty{
+	match: func(tp reflect.Type) (bool, bool) {
+		if tp == entityType || tp.Kind() == reflect.Interface {
+			return true, true
+		}
+		return false, false
+	},
+	cache: map[reflect.Type]bool{},
+}
+
+// entityWalker visits all entities contained in a value, and optionally replaces them.
+//
+// Entities are found in pointers, slices, arrays, maps (as keys and values), interfaces and struct fields,
+// following the same rules as [encoding/json] for struct fields.
+type entityWalker struct {
+	fn      func(entity ecs.Entity) ecs.Entity // Called for each entity. Returns the replacement.
+	rewrite bool                               // Whether entities are replaced by the result of fn.
+	clone   bool                               // Whether pointers, slices and maps are cloned before rewriting.
+
+	seen map[pointerKey]reflect.Value // Pointers already visited, and their clones. Prevents infinite loops.
+}
+
+// pointerKey identifies a pointer, together with its type.
+type pointerKey struct {
+	ptr uintptr
+	tp  reflect.Type
+}
+
+// visitEntities calls fn for each entity contained in a value, without modifying it.
+func visitEntities(value reflect.Value, fn func(entity ecs.Entity)) {
+	w := entityWalker{fn: func(entity ecs.Entity) ecs.Entity {
+		fn(entity)
+		return entity
+	}}
+	w.walk(value)
+}
+
+// rewriteEntities replaces each entity contained in an addressable value by the result of fn.
+//
+// With clone, all pointers, slices and maps that contain entities are cloned before rewriting.
+// This is required for shallow copies, that share these with the original value.
+// Otherwise, the value is rewritten in place, including the values it points to.
+//
+// For maps with entity keys, keys mapped to the same entity overwrite each other.
+func rewriteEntities(value reflect.Value, clone bool, fn func(entity ecs.Entity) ecs.Entity) {
+	w := entityWalker{fn: fn, rewrite: true, clone: clone}
+	w.walk(value)
+}
+
+// walk visits the entities in a value. The value must be addressable if rewriting.
+func (w *entityWalker) walk(value reflect.Value) {
+	tp := value.Type()
+	if !hasEntities.of(tp) {
+		return
+	}
+
+	if tp == entityType {
+		entity := w.fn(value.Interface().(ecs.Entity))
+		if w.rewrite {
+			value.Set(reflect.ValueOf(entity))
+		}
+		return
+	}
+
+	switch tp.Kind() {
+	case reflect.Pointer:
+		w.walkPointer(value)
+	case reflect.Slice:
+		if value.IsNil() {
+			return
+		}
+		if w.clone {
+			cp := reflect.MakeSlice(tp, value.Len(), value.Len())
+			reflect.Copy(cp, value)
+			value.Set(cp)
+		}
+		for i := 0; i < value.Len(); i++ {
+			w.walk(value.Index(i))
+		}
+	case reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			w.walk(value.Index(i))
+		}
+	case reflect.Map:
+		w.walkMap(value)
+	case reflect.Interface:
+		if value.IsNil() {
+			return
+		}
+		elem := value.Elem()
+		if !hasEntities.of(elem.Type()) {
+			return
+		}
+		cp := reflect.New(elem.Type()).Elem()
+		cp.Set(elem)
+		w.walk(cp)
+		if w.rewrite {
+			value.Set(cp)
+		}
+	case reflect.Struct:
+		for i := 0; i < tp.NumField(); i++ {
+			if field := tp.Field(i); isJSONField(field) && hasEntities.of(field.Type) {
+				w.walk(structField(value, i))
+			}
+		}
+	}
+}
+
+// walkPointer visits the entities in the value a pointer points to.
+func (w *entityWalker) walkPointer(value reflect.Value) {
+	if value.IsNil() {
+		return
+	}
+	if w.seen == nil {
+		w.seen = map[pointerKey]reflect.Value{}
+	}
+	key := pointerKey{value.Pointer(), value.Type()}
+	if cp, ok := w.seen[key]; ok {
+		if w.clone {
+			value.Set(cp)
+		}
+		return
+	}
+
+	if !w.clone {
+		w.seen[key] = value
+		w.walk(value.Elem())
+		return
+	}
+	cp := reflect.New(value.Type().Elem())
+	cp.Elem().Set(value.Elem())
+	w.seen[key] = cp
+	value.Set(cp)
+	w.walk(cp.Elem())
+}
+
+// walkMap visits the entities in the keys and values of a map.
+// When rewriting, the map is replaced by a new map.
+func (w *entityWalker) walkMap(value reflect.Value) {
+	if value.IsNil() {
+		return
+	}
+	tp := value.Type()
+	keyType, elemType := tp.Key(), tp.Elem()
+
+	var result reflect.Value
+	if w.rewrite {
+		result = reflect.MakeMapWithSize(tp, value.Len())
+	}
+	iter := value.MapRange()
+	for iter.Next() {
+		key := reflect.New(keyType).Elem()
+		key.Set(iter.Key())
+		elem := reflect.New(elemType).Elem()
+		elem.Set(iter.Value())
+		w.walk(key)
+		w.walk(elem)
+		if w.rewrite {
+			result.SetMapIndex(key, elem)
+		}
+	}
+	if w.rewrite {
+		value.Set(result)
+	}
+}
+
+// structField returns a field of an addressable struct value.
+// Fields of unexported embedded structs are made settable, as [encoding/json] considers them.
+func structField(value reflect.Value, i int) reflect.Value {
+	field := value.Field(i)
+	if field.CanSet() || !value.CanAddr() {
+		return field
+	}
+	return reflect.NewAt(field.Type(), field.Addr().UnsafePointer()).Elem()
+}
@@ -0,0 +1,176 @@
+package archeserde_test
+
+import (
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+type Target struct {
+	Entity ecs.Entity
+}
+
+type targets struct {
+	Hidden ecs.Entity
+}
+
+type Nested struct {
+	Target
+	targets
+	List    []ecs.Entity
+	Pair    [2]ecs.Entity
+	Pointer *ecs.Entity
+	ByName  map[string]ecs.Entity
+	ByKey   map[ecs.Entity]int
+	Deep    []map[ecs.Entity][]Target
+	Skipped ecs.Entity `json:"-"`
+}
+
+type Threats map[ecs.Entity]float64
+
+type Tree struct {
+	Children []Tree
+	ByKey    map[ecs.Entity]int
+}
+
+func nestedWorld() (*ecs.World, ecs.Entity, ecs.Entity) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	nestedId := ecs.ComponentID[Nested](&w)
+
+	w.RemoveEntity(w.NewEntity())
+	a := w.NewEntity(posId)
+	b := w.NewEntity(posId, nestedId)
+	ptr := a
+	*(*Nested)(w.Get(b, nestedId)) = Nested{
+		Target:  Target{Entity: a},
+		targets: targets{Hidden: b},
+		List:    []ecs.Entity{a, b},
+		Pair:    [2]ecs.Entity{b, a},
+		Pointer: &ptr,
+		ByName:  map[string]ecs.Entity{"a": a},
+		ByKey:   map[ecs.Entity]int{a: 1, b: 2},
+		Deep:    []map[ecs.Entity][]Target{{a: {{Entity: b}}}},
+	}
+	ecs.AddResource(&w, &Threats{a: 0.5, b: 1.5})
+
+	return &w, a, b
+}
+
+func nestedTarget() *ecs.World {
+	w := ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&w)
+	_ = ecs.ComponentID[Nested](&w)
+	_ = ecs.ResourceID[Threats](&w)
+	return &w
+}
+
+func expectNested(a, b ecs.Entity) Nested {
+	ptr := a
+	return Nested{
+		Target:  Target{Entity: a},
+		targets: targets{Hidden: b},
+		List:    []ecs.Entity{a, b},
+		Pair:    [2]ecs.Entity{b, a},
+		Pointer: &ptr,
+		ByName:  map[string]ecs.Entity{"a": a},
+		ByKey:   map[ecs.Entity]int{a: 1, b: 2},
+		Deep:    []map[ecs.Entity][]Target{{a: {{Entity: b}}}},
+	}
+}
+
+func TestEntityMaps(t *testing.T) {
+	w, a, b := nestedWorld()
+	nestedId := ecs.ComponentID[Nested](w)
+
+	for _, options := range [][]archeserde.Option{
+		{},
+		{archeserde.Opts.Layout(archeserde.ArchetypeLayout)},
+	} {
+		jsonData, err := archeserde.Serialize(w, options...)
+		assert.Nil(t, err)
+		assert.Contains(t, string(jsonData), `"ByKey":{"1,1":1,"2,0":2}`)
+		assert.Contains(t, string(jsonData), `{"1,1":0.5,"2,0":1.5}`)
+
+		w2 := nestedTarget()
+		assert.Nil(t, archeserde.Validate(jsonData, w2))
+		assert.Nil(t, archeserde.Deserialize(jsonData, w2))
+		assert.Equal(t, expectNested(a, b), *(*Nested)(w2.Get(b, ecs.ComponentID[Nested](w2))))
+		assert.Equal(t, Threats{a: 0.5, b: 1.5}, *ecs.GetResource[Threats](w2))
+
+		_, values, err := archeserde.ReadComponents[Nested](jsonData)
+		assert.Nil(t, err)
+		assert.Equal(t, []Nested{expectNested(a, b)}, values)
+	}
+
+	binData, err := archeserde.SerializeBinary(w)
+	assert.Nil(t, err)
+	w2 := nestedTarget()
+	assert.Nil(t, archeserde.DeserializeBinary(binData, w2))
+	assert.Equal(t, *(*Nested)(w.Get(b, nestedId)), *(*Nested)(w2.Get(b, ecs.ComponentID[Nested](w2))))
+
+	schema, err := archeserde.Schema(w)
+	assert.Nil(t, err)
+	assert.Contains(t, string(schema), `"pattern": "^[0-9]+,[0-9]+$"`)
+
+	err = archeserde.Deserialize([]byte(`{
+		"World" : {"Entities":[[0,4294967295],[1,0]],"Alive":[1],"Next":0,"Available":0},
+		"Types" : ["archeserde_test.Nested"],
+		"Components" : [{"archeserde_test.Nested" : {"ByKey":{"1":5}}}],
+		"Resources" : {}
+	}`), nestedTarget())
+	assert.Contains(t, err.Error(), `invalid entity key "1", expected "id,gen"`)
+
+	w3 := ecs.NewWorld()
+	ecs.AddResource(&w3, &Tree{})
+	_, err = archeserde.Serialize(&w3)
+	assert.Contains(t, err.Error(), "recursive type archeserde_test.Tree with maps keyed by ecs.Entity is not supported")
+}
+
+func TestEntityRemapNested(t *testing.T) {
+	w, a, b := nestedWorld()
+
+	for _, layout := range []archeserde.Layout{archeserde.EntityLayout, archeserde.ArchetypeLayout} {
+		jsonData, err := archeserde.Serialize(w, archeserde.Opts.Layout(layout))
+		assert.Nil(t, err)
+
+		w2 := nestedTarget()
+		w2.NewEntity()
+		mapping, err := archeserde.DeserializeMerge(jsonData, w2)
+		assert.Nil(t, err)
+
+		newA, newB := mapping[a], mapping[b]
+		assert.NotEqual(t, a, newA)
+		assert.Equal(t, expectNested(newA, newB), *(*Nested)(w2.Get(newB, ecs.ComponentID[Nested](w2))))
+		assert.Equal(t, Threats{newA: 0.5, newB: 1.5}, *ecs.GetResource[Threats](w2))
+	}
+}
+
+func TestEntityRemapPrefab(t *testing.T) {
+	w, a, b := nestedWorld()
+	nestedId := ecs.ComponentID[Nested](w)
+	before := *(*Nested)(w.Get(b, nestedId))
+
+	jsonData, err := archeserde.SerializePrefab(w, b, archeserde.Opts.Reachable())
+	assert.Nil(t, err)
+
+	// The world's data is not modified by remapping to local entities.
+	assert.Equal(t, expectNested(a, b), *(*Nested)(w.Get(b, nestedId)))
+	assert.Equal(t, a, *before.Pointer)
+
+	w2 := nestedTarget()
+	roots, err := archeserde.SpawnPrefab(jsonData, w2, 2)
+	assert.Nil(t, err)
+	for _, root := range roots {
+		nested := (*Nested)(w2.Get(root, ecs.ComponentID[Nested](w2)))
+		assert.Equal(t, root, nested.Hidden)
+		assert.Equal(t, root, nested.List[1])
+		assert.Equal(t, nested.Entity, nested.List[0])
+		assert.Equal(t, map[ecs.Entity]int{nested.Entity: 1, root: 2}, nested.ByKey)
+		assert.Equal(t, nested.Entity, *nested.Pointer)
+		assert.True(t, w2.Alive(nested.Entity))
+	}
+	assert.NotEqual(t, roots[0], roots[1])
+}
@@ -0,0 +1,279 @@
+package archeserde
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// entityKey is an entity as the key of a JSON object, encoded as "id,gen".
+//
+// [encoding/json] can't encode maps with [ecs.Entity] keys, as they are neither strings, nor integers,
+// nor text marshalers. Such maps are encoded as maps with entityKey keys instead.
+type entityKey ecs.Entity
+
+// entityKeyType is the reflection type of an [entityKey].
+var entityKeyType = reflect.TypeOf(entityKey{})
+
+// MarshalText encodes the key as "id,gen".
+func (k entityKey) MarshalText() ([]byte, error) {
+	e := ecs.Entity(k)
+	return fmt.Appendf(nil, "%d,%d", e.ID(), e.Generation()), nil
+}
+
+// UnmarshalText decodes the key from "id,gen".
+func (k *entityKey) UnmarshalText(text []byte) error {
+	idText, genText, ok := strings.Cut(string(text), ",")
+	id, errID := strconv.ParseUint(idText, 10, 32)
+	gen, errGen := strconv.ParseUint(genText, 10, 32)
+	if !ok || errID != nil || errGen != nil {
+		return fmt.Errorf("invalid entity key %q, expected \"id,gen\"", text)
+	}
+	*k = entityKey(newEntity(uint32(id), uint32(gen)))
+	return nil
+}
+
+// hasEntityMaps is the property of types that their values can contain maps with [ecs.Entity] keys,
+// which are encoded by [encoding/json] itself, i.e. not by custom marshalers.
+var hasEntityMaps = typeProperty{
+	match: func(tp reflect.Type) (bool, bool) {
+		if tp.Kind() == reflect.Map && tp.Key() == entityType {
+			return true, true
+		}
+		if tp == entityType || tp.Kind() == reflect.Interface {
+			return false, true
+		}
+		ptr := reflect.PointerTo(tp)
+		if ptr.Implements(jsonMarshalerType) || ptr.Implements(jsonUnmarshalerType) ||
+			ptr.Implements(textMarshalerType) {
+			return false, true
+		}
+		return false, false
+	},
+	cache: map[reflect.Type]bool{},
+}
+
+// shadowType is a type for encoding values of a type that contains maps with [ecs.Entity] keys.
+//
+// It is the same as the original type, except that [ecs.Entity] map keys are replaced by [entityKey].
+// Types that contain no such maps are used as they are.
+// Shadow types of structs are unnamed, and contain only the fields considered by [encoding/json].
+type shadowType struct {
+	tp     reflect.Type // The shadow type.
+	fields []int        // For structs, the index of the original field for each shadow field.
+	err    error        // Error if the type can't be encoded.
+}
+
+var (
+	shadowTypes     = map[reflect.Type]*shadowType{}
+	shadowTypesLock sync.RWMutex
+)
+
+// shadowFor returns the cached shadow type for a type that contains maps with [ecs.Entity] keys,
+// and creates it if necessary.
+func shadowFor(tp reflect.Type) (*shadowType, error) {
+	shadowTypesLock.RLock()
+	s, ok := shadowTypes[tp]
+	shadowTypesLock.RUnlock()
+	if !ok {
+		shadowTypesLock.Lock()
+		s = buildShadow(tp, map[reflect.Type]bool{})
+		shadowTypesLock.Unlock()
+	}
+	return s, s.err
+}
+
+// buildShadow creates a shadow type. Must be called with the lock held.
+func buildShadow(tp reflect.Type, visiting map[reflect.Type]bool) (s *shadowType) {
+	if s, ok := shadowTypes[tp]; ok {
+		return s
+	}
+	if !hasEntityMaps.of(tp) {
+		return &shadowType{tp: tp}
+	}
+	if visiting[tp] {
+		return &shadowType{err: fmt.Errorf("recursive type %s with maps keyed by ecs.Entity is not supported", tp)}
+	}
+	visiting[tp] = true
+	defer delete(visiting, tp)
+
+	s = &shadowType{}
+	defer func() {
+		if r := recover(); r != nil {
+			s = &shadowType{err: fmt.Errorf("can't encode type %s with maps keyed by ecs.Entity: %v", tp, r)}
+		}
+		if len(visiting) == 1 || s.err == nil {
+			shadowTypes[tp] = s
+		}
+	}()
+
+	elem := func(t reflect.Type) reflect.Type {
+		e := buildShadow(t, visiting)
+		if e.err != nil && s.err == nil {
+			s.err = e.err
+		}
+		return e.tp
+	}
+
+	switch tp.Kind() {
+	case reflect.Map:
+		key := entityKeyType
+		if tp.Key() != entityType {
+			key = elem(tp.Key())
+		}
+		value := elem(tp.Elem())
+		if s.err == nil {
+			s.tp = reflect.MapOf(key, value)
+		}
+	case reflect.Pointer:
+		if e := elem(tp.Elem()); s.err == nil {
+			s.tp = reflect.PointerTo(e)
+		}
+	case reflect.Slice:
+		if e := elem(tp.Elem()); s.err == nil {
+			s.tp = reflect.SliceOf(e)
+		}
+	case reflect.Array:
+		if e := elem(tp.Elem()); s.err == nil {
+			s.tp = reflect.ArrayOf(tp.Len(), e)
+		}
+	case reflect.Struct:
+		fields := []reflect.StructField{}
+		for i := 0; i < tp.NumField(); i++ {
+			field := tp.Field(i)
+			if !isJSONField(field) {
+				continue
+			}
+			name := field.Name
+			if !field.IsExported() {
+				// Only embedded structs are considered. Their name is irrelevant, but must be exported.
+				name = fmt.Sprintf("Embedded%d", i)
+			}
+			fields = append(fields, reflect.StructField{
+				Name:      name,
+				Type:      elem(field.Type),
+				Tag:       field.Tag,
+				Anonymous: field.Anonymous,
+			})
+			s.fields = append(s.fields, i)
+		}
+		if s.err == nil {
+			s.tp = reflect.StructOf(fields)
+		}
+	}
+	return s
+}
+
+// toShadow converts a value to its shadow type.
+// The value must be addressable, and dst must be an addressable value of the shadow type.
+func toShadow(dst, src reflect.Value) {
+	convertShadow(src.Type(), dst, src, true)
+}
+
+// fromShadow converts a value from its shadow type.
+// The value must be addressable, and src must be an addressable value of the shadow type.
+func fromShadow(dst, src reflect.Value) {
+	convertShadow(dst.Type(), dst, src, false)
+}
+
+// convertShadow converts between a value of type tp and its shadow type, in the given direction.
+// Both values must be addressable. The shadow type must have been built without errors.
+func convertShadow(tp reflect.Type, dst, src reflect.Value, shadow bool) {
+	if !hasEntityMaps.of(tp) {
+		dst.Set(src)
+		return
+	}
+
+	switch tp.Kind() {
+	case reflect.Map:
+		if src.IsNil() {
+			dst.SetZero()
+			return
+		}
+		result := reflect.MakeMapWithSize(dst.Type(), src.Len())
+		keyType, elemType := dst.Type().Key(), dst.Type().Elem()
+		iter := src.MapRange()
+		for iter.Next() {
+			key := reflect.New(keyType).Elem()
+			if tp.Key() == entityType {
+				key.Set(iter.Key().Convert(keyType))
+			} else {
+				srcKey := reflect.New(iter.Key().Type()).Elem()
+				srcKey.Set(iter.Key())
+				convertShadow(tp.Key(), key, srcKey, shadow)
+			}
+			elem := reflect.New(elemType).Elem()
+			srcElem := reflect.New(src.Type().Elem()).Elem()
+			srcElem.Set(iter.Value())
+			convertShadow(tp.Elem(), elem, srcElem, shadow)
+			result.SetMapIndex(key, elem)
+		}
+		dst.Set(result)
+	case reflect.Pointer:
+		if src.IsNil() {
+			dst.SetZero()
+			return
+		}
+		ptr := reflect.New(dst.Type().Elem())
+		convertShadow(tp.Elem(), ptr.Elem(), src.Elem(), shadow)
+		dst.Set(ptr)
+	case reflect.Slice:
+		if src.IsNil() {
+			dst.SetZero()
+			return
+		}
+		slice := reflect.MakeSlice(dst.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			convertShadow(tp.Elem(), slice.Index(i), src.Index(i), shadow)
+		}
+		dst.Set(slice)
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			convertShadow(tp.Elem(), dst.Index(i), src.Index(i), shadow)
+		}
+	case reflect.Struct:
+		shadowTypesLock.RLock()
+		s := shadowTypes[tp]
+		shadowTypesLock.RUnlock()
+		for j, i := range s.fields {
+			if shadow {
+				convertShadow(tp.Field(i).Type, dst.Field(j), structField(src, i), shadow)
+			} else {
+				convertShadow(tp.Field(i).Type, structField(dst, i), src.Field(j), shadow)
+			}
+		}
+	}
+}
+
+// marshalShadow encodes a value that contains maps keyed by [ecs.Entity], given as a pointer, to JSON.
+func marshalShadow(value reflect.Value) ([]byte, error) {
+	s, err := shadowFor(value.Type().Elem())
+	if err != nil {
+		return nil, err
+	}
+	shadow := reflect.New(s.tp)
+	toShadow(shadow.Elem(), value.Elem())
+	return json.Marshal(shadow.Interface())
+}
+
+// unmarshalShadow decodes JSON into a value that contains maps keyed by [ecs.Entity], given as a pointer.
+//
+// Like [json.Unmarshal], it decodes into the existing value, so that fields not present in the JSON are kept.
+func unmarshalShadow(data []byte, value reflect.Value) error {
+	s, err := shadowFor(value.Type().Elem())
+	if err != nil {
+		return err
+	}
+	shadow := reflect.New(s.tp)
+	toShadow(shadow.Elem(), value.Elem())
+	if err := json.Unmarshal(data, shadow.Interface()); err != nil {
+		return err
+	}
+	fromShadow(value.Elem(), shadow.Elem())
+	return nil
+}
@@ -0,0 +1,104 @@
+package archeserde
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// Names of the sections of serialized data, as used in [Error].
+const (
+	SectionWorld      = "World"
+	SectionTypes      = "Types"
+	SectionComponents = "Components"
+	SectionArchetypes = "Archetypes"
+	SectionEntities   = "Entities"
+	SectionFree       = "Free"
+	SectionResources  = "Resources"
+)
+
+// Error is an error in serialization or deserialization, with the location where it occurred.
+//
+// Errors returned by this package carry their location whenever it is known.
+// Inspect them with [errors.As], and the underlying cause with [errors.Is] or [errors.Unwrap].
+// Fields that do not apply are left empty, and Index is -1.
+type Error struct {
+	// Section of the data, like [SectionComponents] or [SectionResources].
+	Section string
+	// Index of the record in the section.
+	// For the entity layout and the binary format, this is the index of the entity in the list of alive entities.
+	// For [ArchetypeLayout], it is the index of the archetype.
+	// For [RecordLayout], it is the index of the entity record, or of the free entity.
+	Index int
+	// The entity concerned.
+	Entity ecs.Entity
+	// Name of the component or resource type concerned.
+	Type string
+	// The underlying cause.
+	Err error
+}
+
+// Error returns the location and the cause of the error as a string.
+func (e *Error) Error() string {
+	location := []string{}
+	if e.Section != "" {
+		location = append(location, fmt.Sprintf("section '%s'", e.Section))
+	}
+	if e.Index >= 0 {
+		location = append(location, fmt.Sprintf("index %d", e.Index))
+	}
+	if !e.Entity.IsZero() {
+		location = append(location, fmt.Sprintf("entity %v", e.Entity))
+	}
+	if e.Type != "" {
+		location = append(location, fmt.Sprintf("type %s", e.Type))
+	}
+	if len(location) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(location, ", "), e.Err)
+}
+
+// Unwrap returns the underlying cause.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError adds a location to an error.
+// Use an empty section or type name, an index of -1 and the zero entity for unknown parts of the location.
+//
+// If err already is an [*Error], only the unknown parts of its location are filled in.
+// Returns nil if err is nil.
+func newError(section string, index int, entity ecs.Entity, tpName string, err error) error {
+	if err == nil {
+		return nil
+	}
+	e, ok := err.(*Error)
+	if !ok {
+		return &Error{Section: section, Index: index, Entity: entity, Type: tpName, Err: err}
+	}
+	if e.Section == "" {
+		e.Section = section
+	}
+	if e.Index < 0 {
+		e.Index = index
+	}
+	if e.Entity.IsZero() {
+		e.Entity = entity
+	}
+	if e.Type == "" {
+		e.Type = tpName
+	}
+	return e
+}
+
+// sectionError adds a section to an error.
+func sectionError(section string, err error) error {
+	return newError(section, -1, ecs.Entity{}, "", err)
+}
+
+// typeError adds a component or resource type name to an error.
+func typeError(tpName string, err error) error {
+	return newError("", -1, ecs.Entity{}, tpName, err)
+}
@@ -0,0 +1,100 @@
+package archeserde_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/mlange-42/arche/generic"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestError(t *testing.T) {
+	world := ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&world)
+	_ = ecs.ComponentID[Velocity](&world)
+	_ = ecs.ComponentID[ChildOf](&world)
+	_ = ecs.AddResource(&world, &Velocity{})
+
+	err := archeserde.Deserialize([]byte(textErrComponent2), &world)
+	var serdeErr *archeserde.Error
+	assert.True(t, errors.As(err, &serdeErr))
+	assert.Equal(t, archeserde.SectionComponents, serdeErr.Section)
+	assert.Equal(t, 0, serdeErr.Index)
+	assert.Equal(t, uint32(1), serdeErr.Entity.ID())
+	assert.Equal(t, "archeserde_test.Position", serdeErr.Type)
+	assert.Contains(t, serdeErr.Err.Error(), "cannot unmarshal array")
+	assert.Contains(t, err.Error(), "section 'Components', index 0, entity {1 0}, type archeserde_test.Position: json: cannot unmarshal array")
+
+	world = ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&world)
+	err = archeserde.Deserialize([]byte(fmt.Sprintf(textArchetypes, `[[5,0]]`, `[{"X":1}]`)), &world)
+	assert.True(t, errors.As(err, &serdeErr))
+	assert.Equal(t, archeserde.SectionArchetypes, serdeErr.Section)
+	assert.Equal(t, 0, serdeErr.Index)
+	assert.Equal(t, uint32(5), serdeErr.Entity.ID())
+	assert.Equal(t, "", serdeErr.Type)
+
+	world = ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&world)
+	_ = ecs.ComponentID[Velocity](&world)
+	_ = ecs.ComponentID[ChildOf](&world)
+	err = archeserde.Deserialize([]byte(textOk), &world)
+	assert.True(t, errors.As(err, &serdeErr))
+	assert.Equal(t, archeserde.SectionResources, serdeErr.Section)
+	assert.Equal(t, -1, serdeErr.Index)
+	assert.True(t, serdeErr.Entity.IsZero())
+	assert.Equal(t, "archeserde_test.Velocity", serdeErr.Type)
+}
+
+func TestErrorSerialize(t *testing.T) {
+	testErr := fmt.Errorf("test error")
+
+	world := ecs.NewWorld()
+	bitsID := ecs.ComponentID[Bits](&world)
+	world.NewEntity()
+	e := world.NewEntity(bitsID)
+
+	opt := archeserde.Opts.Codec(generic.T[Bits](), archeserde.Codec{
+		Encode: func(value any) ([]byte, error) { return nil, testErr },
+	})
+
+	_, err := archeserde.Serialize(&world, opt)
+	var serdeErr *archeserde.Error
+	assert.True(t, errors.As(err, &serdeErr))
+	assert.Equal(t, archeserde.SectionComponents, serdeErr.Section)
+	assert.Equal(t, 1, serdeErr.Index)
+	assert.Equal(t, e, serdeErr.Entity)
+	assert.Equal(t, "archeserde_test.Bits", serdeErr.Type)
+	assert.True(t, errors.Is(err, testErr))
+
+	_, err = archeserde.Serialize(&world, opt, archeserde.Opts.Layout(archeserde.ArchetypeLayout))
+	assert.True(t, errors.As(err, &serdeErr))
+	assert.Equal(t, archeserde.SectionArchetypes, serdeErr.Section)
+	assert.Equal(t, e, serdeErr.Entity)
+	assert.True(t, errors.Is(err, testErr))
+
+	_, err = archeserde.SerializeBinary(&world, opt)
+	assert.True(t, errors.As(err, &serdeErr))
+	assert.Equal(t, archeserde.SectionComponents, serdeErr.Section)
+	assert.Equal(t, 1, serdeErr.Index)
+	assert.Equal(t, e, serdeErr.Entity)
+	assert.True(t, errors.Is(err, testErr))
+
+	binData, err := archeserde.SerializeBinary(&world)
+	assert.Nil(t, err)
+
+	world2 := ecs.NewWorld()
+	_ = ecs.ComponentID[Bits](&world2)
+	err = archeserde.DeserializeBinary(binData, &world2, archeserde.Opts.Codec(generic.T[Bits](), archeserde.Codec{
+		Decode: func(data []byte, value any) error { return testErr },
+	}))
+	assert.True(t, errors.As(err, &serdeErr))
+	assert.Equal(t, archeserde.SectionComponents, serdeErr.Section)
+	assert.Equal(t, 1, serdeErr.Index)
+	assert.Equal(t, e, serdeErr.Entity)
+	assert.Equal(t, "archeserde_test.Bits", serdeErr.Type)
+	assert.True(t, errors.Is(err, testErr))
+}
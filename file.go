@@ -0,0 +1,402 @@
+package archeserde
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// File is a type-agnostic model of a JSON document, as written by [Serialize].
+//
+// Component and resource values are held as raw JSON.
+// Thus, files can be inspected, compared, edited and converted
+// without the Go types of their components and resources.
+// This is what the command line tool in cmd/arche-serde is built on.
+//
+// Use [ReadFile] to read a file, and [File.Marshal] to write it.
+type File struct {
+	Version    int                                       // Format version the file was read from.
+	World      ecs.EntityDump                            // Entities and the entity pool.
+	Types      []string                                  // Component type names, in the order of section "Types".
+	Components map[ecs.Entity]map[string]json.RawMessage // Components of each alive entity, by type name.
+	Targets    map[ecs.Entity]ecs.Entity                 // Relation targets, by entity.
+	Resources  map[string]json.RawMessage                // Resources, by type name.
+}
+
+// ReadFile reads a JSON document in any layout and format version, as written by [Serialize].
+//
+// Compressed input is detected automatically.
+// Files in the binary format can't be read, as their values can't be decoded without the Go types.
+func ReadFile(jsonData []byte) (*File, error) {
+	jsonData, err := decompressBytes(jsonData)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(jsonData, []byte(binaryMagic)) {
+		return nil, fmt.Errorf("files in the binary format can't be read without the Go types of their components and resources")
+	}
+	snap, err := readSnapshot(jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	file := File{
+		Version:    snap.Version,
+		World:      snap.World,
+		Types:      snap.Types,
+		Components: snap.Components,
+		Targets:    map[ecs.Entity]ecs.Entity{},
+		Resources:  snap.Resources,
+	}
+	for entity, comps := range file.Components {
+		value, ok := comps[targetTag]
+		if !ok {
+			continue
+		}
+		target := ecs.Entity{}
+		if err := json.Unmarshal(value, &target); err != nil {
+			return nil, newError(SectionComponents, -1, entity, targetTag, err)
+		}
+		file.Targets[entity] = target
+		delete(comps, targetTag)
+	}
+	file.Types = file.typeNames()
+
+	return &file, nil
+}
+
+// Entities returns the alive entities, in the order of the entity dump.
+func (f *File) Entities() []ecs.Entity {
+	entities := make([]ecs.Entity, 0, len(f.World.Alive))
+	for _, idx := range f.World.Alive {
+		entities = append(entities, f.World.Entities[idx])
+	}
+	return entities
+}
+
+// Marshal writes the file as JSON, in the lowest format version that supports the layout.
+//
+// Options [Options.Layout] and [Options.Compression] are considered, all other options are ignored.
+// Component types are written in the order of [File.Types], followed by unlisted types in the order of their names.
+// Resources are written in the order of their names.
+func (f *File) Marshal(options ...Option) ([]byte, error) {
+	opts := newSerdeOptions(options...)
+
+	buffer := bytes.Buffer{}
+	compressor := compressWriter(&buffer, opts.compression)
+	writer := bufio.NewWriter(compressor)
+
+	writer.WriteString("{\n")
+	fmt.Fprintf(writer, "\"Version\" : %d,\n", documentVersion(opts.layout, false))
+
+	if opts.layout != RecordLayout {
+		jsonData, err := json.Marshal(&f.World)
+		if err != nil {
+			return nil, sectionError(SectionWorld, err)
+		}
+		fmt.Fprintf(writer, "\"World\" : %s,\n", jsonData)
+	}
+
+	names := f.typeNames()
+	infos := make([]compType, len(names))
+	for i, name := range names {
+		infos[i] = compType{Name: name, quoted: quote(name)}
+	}
+	serializeTypes(infos, writer)
+	writer.WriteString(",\n")
+
+	var err error
+	if opts.layout == ArchetypeLayout {
+		err = f.writeArchetypes(infos, writer)
+	} else if opts.layout == RecordLayout {
+		err = f.writeRecords(infos, writer)
+	} else {
+		err = f.writeComponents(infos, writer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	writer.WriteString(",\n")
+
+	if err := f.writeResources(writer); err != nil {
+		return nil, err
+	}
+	writer.WriteString("}\n")
+
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// typeNames returns the names of all component types.
+// These are the listed types, followed by types used by entities, but not listed, sorted by name.
+func (f *File) typeNames() []string {
+	names := slices.Clone(f.Types)
+	unlisted := []string{}
+	for _, comps := range f.Components {
+		for name := range comps {
+			if !slices.Contains(names, name) && !slices.Contains(unlisted, name) {
+				unlisted = append(unlisted, name)
+			}
+		}
+	}
+	slices.Sort(unlisted)
+	return append(names, unlisted...)
+}
+
+// writeComponents writes the components of all alive entities in [EntityLayout].
+func (f *File) writeComponents(infos []compType, writer *bufio.Writer) error {
+	writer.WriteString("\"Components\" : [\n")
+
+	entities := f.Entities()
+	for counter, entity := range entities {
+		comps := f.Components[entity]
+		writer.WriteString("  {\n")
+
+		lines := []string{}
+		if target, ok := f.Targets[entity]; ok {
+			eJSON, err := target.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			lines = append(lines, fmt.Sprintf("    \"%s\" : %s", targetTag, eJSON))
+		}
+		for _, info := range infos {
+			value, ok := comps[info.Name]
+			if !ok {
+				continue
+			}
+			jsonData, err := compactJSON(value)
+			if err != nil {
+				return newError(SectionComponents, counter, entity, info.Name, err)
+			}
+			lines = append(lines, fmt.Sprintf("    %s : %s", info.quoted, jsonData))
+		}
+		for i, line := range lines {
+			writer.WriteString(line)
+			if i < len(lines)-1 {
+				writer.WriteString(",")
+			}
+			writer.WriteString("\n")
+		}
+
+		writer.WriteString("  }")
+		if counter < len(entities)-1 {
+			writer.WriteString(",")
+		}
+		writer.WriteString("\n")
+	}
+	writer.WriteString("]")
+
+	return nil
+}
+
+// fileArchetype is a contiguous run of entities with the same components and relation target.
+type fileArchetype struct {
+	infos     []compType
+	target    ecs.Entity
+	hasTarget bool
+	entities  []ecs.Entity
+}
+
+// writeArchetypes writes the components of all alive entities in [ArchetypeLayout].
+// Entities are grouped into runs of the same components and relation target, in the order of the entity dump.
+// Entities without components are omitted.
+func (f *File) writeArchetypes(infos []compType, writer *bufio.Writer) error {
+	runs := []fileArchetype{}
+	for _, entity := range f.Entities() {
+		comps := f.Components[entity]
+		if len(comps) == 0 {
+			continue
+		}
+		run := fileArchetype{}
+		for _, info := range infos {
+			if _, ok := comps[info.Name]; ok {
+				run.infos = append(run.infos, info)
+			}
+		}
+		run.target, run.hasTarget = f.Targets[entity]
+
+		if len(runs) > 0 {
+			last := &runs[len(runs)-1]
+			if last.target == run.target && last.hasTarget == run.hasTarget &&
+				slices.EqualFunc(last.infos, run.infos, func(a, b compType) bool { return a.Name == b.Name }) {
+				last.entities = append(last.entities, entity)
+				continue
+			}
+		}
+		run.entities = []ecs.Entity{entity}
+		runs = append(runs, run)
+	}
+
+	if len(runs) == 0 {
+		writer.WriteString("\"Archetypes\" : []")
+		return nil
+	}
+	writer.WriteString("\"Archetypes\" : [\n")
+
+	for i, run := range runs {
+		writer.WriteString("  {\n")
+
+		writer.WriteString("    \"Types\" : [")
+		for j, info := range run.infos {
+			writer.WriteString(info.quoted)
+			if j < len(run.infos)-1 {
+				writer.WriteString(", ")
+			}
+		}
+		writer.WriteString("],\n")
+
+		if run.hasTarget {
+			eJSON, err := run.target.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(writer, "    \"Target\" : %s,\n", eJSON)
+		}
+
+		eJSON, err := json.Marshal(run.entities)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(writer, "    \"Entities\" : %s,\n", eJSON)
+
+		writer.WriteString("    \"Components\" : [\n")
+		for j, info := range run.infos {
+			writer.WriteString("      [")
+			for k, entity := range run.entities {
+				jsonData, err := compactJSON(f.Components[entity][info.Name])
+				if err != nil {
+					return newError(SectionArchetypes, i, entity, info.Name, err)
+				}
+				writer.Write(jsonData)
+				if k < len(run.entities)-1 {
+					writer.WriteString(",")
+				}
+			}
+			writer.WriteString("]")
+			if j < len(run.infos)-1 {
+				writer.WriteString(",")
+			}
+			writer.WriteString("\n")
+		}
+		writer.WriteString("    ]\n")
+
+		writer.WriteString("  }")
+		if i < len(runs)-1 {
+			writer.WriteString(",")
+		}
+		writer.WriteString("\n")
+	}
+	writer.WriteString("]")
+
+	return nil
+}
+
+// writeRecords writes the free entities, and the records of all alive entities in [RecordLayout].
+func (f *File) writeRecords(infos []compType, writer *bufio.Writer) error {
+	jsonData, err := json.Marshal(freeEntities(&f.World))
+	if err != nil {
+		return sectionError(SectionFree, err)
+	}
+	fmt.Fprintf(writer, "\"Free\" : %s,\n", jsonData)
+
+	writer.WriteString("\"Entities\" : [\n")
+
+	entities := f.Entities()
+	for counter, entity := range entities {
+		comps := f.Components[entity]
+		eJSON, err := entity.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		writer.WriteString("  {\n")
+		fmt.Fprintf(writer, "    \"Entity\" : %s,\n", eJSON)
+
+		if target, ok := f.Targets[entity]; ok {
+			eJSON, err := target.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(writer, "    \"Target\" : %s,\n", eJSON)
+		}
+
+		lines := []string{}
+		for _, info := range infos {
+			value, ok := comps[info.Name]
+			if !ok {
+				continue
+			}
+			jsonData, err := compactJSON(value)
+			if err != nil {
+				return newError(SectionEntities, counter, entity, info.Name, err)
+			}
+			lines = append(lines, fmt.Sprintf("      %s : %s", info.quoted, jsonData))
+		}
+		if len(lines) == 0 {
+			writer.WriteString("    \"Components\" : {}\n")
+		} else {
+			writer.WriteString("    \"Components\" : {\n")
+			for i, line := range lines {
+				writer.WriteString(line)
+				if i < len(lines)-1 {
+					writer.WriteString(",")
+				}
+				writer.WriteString("\n")
+			}
+			writer.WriteString("    }\n")
+		}
+
+		writer.WriteString("  }")
+		if counter < len(entities)-1 {
+			writer.WriteString(",")
+		}
+		writer.WriteString("\n")
+	}
+	writer.WriteString("]")
+
+	return nil
+}
+
+// writeResources writes all resources, sorted by name.
+func (f *File) writeResources(writer *bufio.Writer) error {
+	names := make([]string, 0, len(f.Resources))
+	for name := range f.Resources {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	writer.WriteString("\"Resources\" : {\n")
+	for i, name := range names {
+		jsonData, err := compactJSON(f.Resources[name])
+		if err != nil {
+			return newError(SectionResources, -1, ecs.Entity{}, name, err)
+		}
+		fmt.Fprintf(writer, "    %s : ", quote(name))
+		writer.Write(jsonData)
+		if i < len(names)-1 {
+			writer.WriteString(",")
+		}
+		writer.WriteString("\n")
+	}
+	writer.WriteString("}")
+
+	return nil
+}
+
+// compactJSON removes insignificant whitespace from a JSON value.
+func compactJSON(value json.RawMessage) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	if err := json.Compact(&buffer, value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,132 @@
+package archeserde_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFile(t *testing.T) {
+	w := parallelWorld(100)
+
+	jsonData, err := archeserde.Serialize(w)
+	assert.Nil(t, err)
+	file, err := archeserde.ReadFile(jsonData)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 1, file.Version)
+	assert.Equal(t, w.DumpEntities(), file.World)
+	assert.Equal(t, []string{
+		"archeserde_test.Position",
+		"archeserde_test.Velocity",
+		"archeserde_test.ChildOf",
+		"archeserde_test.ChildRelation",
+	}, file.Types)
+	assert.Equal(t, 101, len(file.Entities()))
+	assert.Equal(t, 101, len(file.Components))
+	assert.Equal(t, 33, len(file.Targets))
+	assert.Equal(t, json.RawMessage(`{"X":1,"Y":2}`), file.Resources["archeserde_test.Velocity"])
+
+	for _, options := range [][]archeserde.Option{
+		{archeserde.Opts.Layout(archeserde.ArchetypeLayout)},
+		{archeserde.Opts.Compression(archeserde.Gzip)},
+	} {
+		other, err := archeserde.Serialize(w, options...)
+		assert.Nil(t, err)
+		otherFile, err := archeserde.ReadFile(other)
+		assert.Nil(t, err)
+		assert.Equal(t, file.Types, otherFile.Types)
+		assert.Equal(t, file.Components, otherFile.Components)
+		assert.Equal(t, file.Targets, otherFile.Targets)
+		assert.Equal(t, file.Resources, otherFile.Resources)
+	}
+
+	for _, options := range [][]archeserde.Option{
+		{},
+		{archeserde.Opts.Layout(archeserde.ArchetypeLayout)},
+		{archeserde.Opts.Layout(archeserde.ArchetypeLayout), archeserde.Opts.Compression(archeserde.Zlib)},
+	} {
+		marshaled, err := file.Marshal(options...)
+		assert.Nil(t, err)
+		assert.Nil(t, archeserde.ValidateStructure(marshaled))
+
+		w2 := ecs.NewWorld()
+		_ = ecs.ComponentID[Position](&w2)
+		_ = ecs.ComponentID[Velocity](&w2)
+		_ = ecs.ComponentID[ChildOf](&w2)
+		_ = ecs.ComponentID[ChildRelation](&w2)
+		_ = ecs.ResourceID[Velocity](&w2)
+		assert.Nil(t, archeserde.Deserialize(marshaled, &w2))
+
+		expected, err := archeserde.Serialize(w, archeserde.Opts.Canonical())
+		assert.Nil(t, err)
+		actual, err := archeserde.Serialize(&w2, archeserde.Opts.Canonical())
+		assert.Nil(t, err)
+		assert.Equal(t, string(expected), string(actual))
+	}
+}
+
+func TestFileEdit(t *testing.T) {
+	file, err := archeserde.ReadFile([]byte(textArchetypesFile))
+	assert.Nil(t, err)
+
+	e1, e2 := file.World.Entities[1], file.World.Entities[2]
+	file.Components[e2]["archeserde_test.Velocity"] = json.RawMessage(`{ "X" : 3,
+		"Y" : 4 }`)
+	file.Resources["archeserde_test.Position"] = json.RawMessage(`{"X":5,"Y":6}`)
+
+	jsonData, err := file.Marshal()
+	assert.Nil(t, err)
+	assert.Equal(t, `{
+"Version" : 1,
+"World" : {"Entities":[[0,4294967295],[1,0],[2,0]],"Alive":[1,2],"Next":0,"Available":0},
+"Types" : [
+  "archeserde_test.Position",
+  "archeserde_test.Velocity"
+],
+"Components" : [
+  {
+    "archeserde_test.Position" : {"X":1,"Y":2}
+  },
+  {
+    "archeserde_test.Position" : {"X":0,"Y":0},
+    "archeserde_test.Velocity" : {"X":3,"Y":4}
+  }
+],
+"Resources" : {
+    "archeserde_test.Position" : {"X":5,"Y":6}
+}}
+`, string(jsonData))
+
+	jsonData, err = file.Marshal(archeserde.Opts.Layout(archeserde.ArchetypeLayout))
+	assert.Nil(t, err)
+	edited, err := archeserde.ReadFile(jsonData)
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage(`{"X":3,"Y":4}`), edited.Components[e2]["archeserde_test.Velocity"])
+	assert.Equal(t, json.RawMessage(`{"X":1,"Y":2}`), edited.Components[e1]["archeserde_test.Position"])
+
+	_, err = archeserde.ReadFile([]byte("ARCHEBIN"))
+	assert.Contains(t, err.Error(), "files in the binary format can't be read")
+}
+
+const textArchetypesFile = `{
+	"Version" : 1,
+	"World" : {"Entities":[[0,4294967295],[1,0],[2,0]],"Alive":[1,2],"Next":0,"Available":0},
+	"Types" : ["archeserde_test.Position", "archeserde_test.Velocity"],
+	"Archetypes" : [
+	  {
+		"Types" : ["archeserde_test.Position", "archeserde_test.Velocity"],
+		"Entities" : [[2,0]],
+		"Components" : [[{"X":0,"Y":0}], [{"X":0,"Y":0}]]
+	  },
+	  {
+		"Types" : ["archeserde_test.Position"],
+		"Entities" : [[1,0]],
+		"Components" : [[{"X":1,"Y":2}]]
+	  }
+	],
+	"Resources" : {}
+}`
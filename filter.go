@@ -0,0 +1,98 @@
+package archeserde
+
+import (
+	"math"
+	"slices"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// selectEntities removes all entities that are not selected by the options from an entity dump.
+//
+// Removed entities are recycled in the entity pool, the same way as [ecs.World.RemoveEntity] would do.
+// Thus, the dump stays consistent, and references to removed entities become references to dead entities.
+func selectEntities(world *ecs.World, dump *ecs.EntityDump, opts *serdeOptions) {
+	if opts.filter == nil && len(opts.skipEntitiesWith) == 0 {
+		return
+	}
+
+	selected := map[ecs.Entity]bool{}
+	filter := opts.filter
+	if filter == nil {
+		filter = ecs.All()
+	}
+	skip := skipMask(world, opts)
+
+	query := world.Query(filter)
+	for query.Next() {
+		if mask := query.Mask(); mask.ContainsAny(&skip) {
+			continue
+		}
+		selected[query.Entity()] = true
+	}
+
+	alive := dump.Alive[:0]
+	for _, idx := range dump.Alive {
+		entity := dump.Entities[idx]
+		if selected[entity] {
+			alive = append(alive, idx)
+			continue
+		}
+		dump.Entities[idx] = newEntity(dump.Next, entity.Generation()+1)
+		dump.Next = idx
+		dump.Available++
+	}
+	dump.Alive = alive
+}
+
+// compactEntities returns a dump with the alive entities of a dump, renumbered densely in the order of alive entities.
+//
+// The result is the same as the dump of a fresh world, after creating one entity per alive entity.
+// Dead entities are dropped. Also returns the mapping from the entities of the dump to the new entities.
+func compactEntities(dump *ecs.EntityDump) (ecs.EntityDump, entityRemap) {
+	remap := make(entityRemap, len(dump.Alive))
+	compact := ecs.EntityDump{
+		Entities: make([]ecs.Entity, 1, len(dump.Alive)+1),
+		Alive:    make([]uint32, 0, len(dump.Alive)),
+	}
+	compact.Entities[0] = newEntity(0, math.MaxUint32)
+	for i, idx := range dump.Alive {
+		entity := newEntity(uint32(i+1), 0)
+		remap[dump.Entities[idx]] = entity
+		compact.Entities = append(compact.Entities, entity)
+		compact.Alive = append(compact.Alive, uint32(i+1))
+	}
+	return compact, remap
+}
+
+// skipMask returns the mask of the component types for skipping entities.
+// Types that are not registered in the world are ignored.
+func skipMask(world *ecs.World, opts *serdeOptions) ecs.Mask {
+	mask := ecs.Mask{}
+	for _, id := range ecs.ComponentIDs(world) {
+		if info, ok := ecs.ComponentInfo(world, id); ok && slices.Contains(opts.skipEntitiesWith, info.Type) {
+			mask.Set(id, true)
+		}
+	}
+	return mask
+}
+
+// isSelected checks whether an entity is contained in an entity dump as an alive entity.
+func isSelected(dump *ecs.EntityDump, entity ecs.Entity) bool {
+	return int(entity.ID()) < len(dump.Entities) && dump.Entities[entity.ID()] == entity
+}
+
+// relationTarget returns the relation target of an entity for serialization.
+// Targets that are not selected for serialization are replaced by the zero entity.
+//
+// If local is not nil, the target is mapped to a local entity, and unknown targets are replaced by the zero entity.
+func relationTarget(world *ecs.World, dump *ecs.EntityDump, entity ecs.Entity, comp ecs.ID, local entityRemap) ecs.Entity {
+	target := world.Relations().Get(entity, comp)
+	if local != nil {
+		return local.get(target)
+	}
+	if target.IsZero() || isSelected(dump, target) {
+		return target
+	}
+	return ecs.Entity{}
+}
@@ -0,0 +1,172 @@
+package archeserde_test
+
+import (
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/mlange-42/arche/generic"
+	"github.com/stretchr/testify/assert"
+)
+
+type Transient struct{}
+
+func TestSerializeSkipEntitiesWith(t *testing.T) {
+	for _, layout := range []archeserde.Layout{archeserde.EntityLayout, archeserde.ArchetypeLayout} {
+		w := ecs.NewWorld()
+		posId := ecs.ComponentID[Position](&w)
+		relId := ecs.ComponentID[ChildRelation](&w)
+		transId := ecs.ComponentID[Transient](&w)
+
+		parent := w.NewEntity(posId)
+		child := w.NewEntity(posId, relId)
+		w.Relations().Set(child, relId, parent)
+		particle := w.NewEntity(posId, transId)
+		particleChild := w.NewEntity(posId, relId)
+		w.Relations().Set(particleChild, relId, particle)
+		w.RemoveEntity(w.NewEntity())
+
+		jsonData, err := archeserde.Serialize(&w,
+			archeserde.Opts.SkipEntitiesWith(generic.T[Transient]()),
+			archeserde.Opts.Layout(layout),
+		)
+		assert.Nil(t, err)
+
+		w2 := ecs.NewWorld()
+		_ = ecs.ComponentID[Position](&w2)
+		_ = ecs.ComponentID[ChildRelation](&w2)
+		_ = ecs.ComponentID[Transient](&w2)
+		err = archeserde.Deserialize(jsonData, &w2)
+		assert.Nil(t, err)
+
+		assert.True(t, w2.Alive(parent))
+		assert.True(t, w2.Alive(child))
+		assert.True(t, w2.Alive(particleChild))
+		assert.False(t, w2.Alive(particle))
+		assert.Equal(t, parent, w2.Relations().Get(child, relId))
+		assert.True(t, w2.Relations().Get(particleChild, relId).IsZero())
+
+		w.RemoveEntity(particle)
+		dump, dump2 := w.DumpEntities(), w2.DumpEntities()
+		assert.Equal(t, dump.Entities, dump2.Entities)
+		assert.Equal(t, dump.Next, dump2.Next)
+		assert.Equal(t, dump.Available, dump2.Available)
+		assert.ElementsMatch(t, dump.Alive, dump2.Alive)
+
+		e1, e2 := w.NewEntity(), w2.NewEntity()
+		assert.Equal(t, e1, e2)
+	}
+}
+
+func TestSerializeFilter(t *testing.T) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	velId := ecs.ComponentID[Velocity](&w)
+	transId := ecs.ComponentID[Transient](&w)
+
+	e1 := w.NewEntity(posId, velId)
+	e2 := w.NewEntity(posId)
+	e3 := w.NewEntity(velId, transId)
+	e4 := w.NewEntity()
+
+	opts := []archeserde.Option{
+		archeserde.Opts.Filter(ecs.All(velId)),
+		archeserde.Opts.SkipEntitiesWith(generic.T[Transient]()),
+	}
+
+	newWorld := func() ecs.World {
+		w2 := ecs.NewWorld()
+		_ = ecs.ComponentID[Position](&w2)
+		_ = ecs.ComponentID[Velocity](&w2)
+		_ = ecs.ComponentID[Transient](&w2)
+		return w2
+	}
+	check := func(w2 *ecs.World) {
+		assert.True(t, w2.Alive(e1))
+		assert.False(t, w2.Alive(e2))
+		assert.False(t, w2.Alive(e3))
+		assert.False(t, w2.Alive(e4))
+		assert.True(t, w2.Has(e1, posId))
+		assert.True(t, w2.Has(e1, velId))
+	}
+
+	jsonData, err := archeserde.Serialize(&w, opts...)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(jsonData), "{}")
+	w2 := newWorld()
+	assert.Nil(t, archeserde.Deserialize(jsonData, &w2))
+	check(&w2)
+
+	jsonData, err = archeserde.Serialize(&w, append(opts, archeserde.Opts.Canonical())...)
+	assert.Nil(t, err)
+	w2 = newWorld()
+	assert.Nil(t, archeserde.Deserialize(jsonData, &w2))
+	check(&w2)
+
+	binData, err := archeserde.SerializeBinary(&w, opts...)
+	assert.Nil(t, err)
+	w2 = newWorld()
+	assert.Nil(t, archeserde.DeserializeBinary(binData, &w2))
+	check(&w2)
+}
+
+func TestSerializeCompactEntities(t *testing.T) {
+	w, a, b := nestedWorld()
+	nestedId := ecs.ComponentID[Nested](w)
+	relId := ecs.ComponentID[ChildRelation](w)
+
+	dead := w.NewEntity()
+	w.RemoveEntity(dead)
+	w.Add(b, relId)
+	w.Relations().Set(b, relId, a)
+	nested := (*Nested)(w.Get(b, nestedId))
+	nested.Skipped = a
+	nested.List = append(nested.List, dead)
+	before := *nested
+
+	fresh := ecs.NewWorld()
+	newA, newB := fresh.NewEntity(), fresh.NewEntity()
+	expected := expectNested(newA, newB)
+	expected.List = append(expected.List, ecs.Entity{})
+
+	deserialize := map[string]func(data []byte, world *ecs.World, options ...archeserde.Option) error{
+		"json":   archeserde.Deserialize,
+		"binary": archeserde.DeserializeBinary,
+	}
+	for _, format := range []struct {
+		name    string
+		options []archeserde.Option
+	}{
+		{"json", []archeserde.Option{archeserde.Opts.CompactEntities()}},
+		{"json", []archeserde.Option{archeserde.Opts.CompactEntities(), archeserde.Opts.Layout(archeserde.ArchetypeLayout)}},
+		{"json", []archeserde.Option{archeserde.Opts.CompactEntities(), archeserde.Opts.Canonical(), archeserde.Opts.Workers(4)}},
+		{"binary", []archeserde.Option{archeserde.Opts.CompactEntities()}},
+	} {
+		var data []byte
+		var err error
+		if format.name == "binary" {
+			data, err = archeserde.SerializeBinary(w, format.options...)
+		} else {
+			data, err = archeserde.Serialize(w, format.options...)
+		}
+		assert.Nil(t, err)
+
+		w2 := nestedTarget()
+		relId2 := ecs.ComponentID[ChildRelation](w2)
+		assert.Nil(t, deserialize[format.name](data, w2))
+
+		assert.Equal(t, fresh.DumpEntities(), w2.DumpEntities())
+		assert.Equal(t, expected, *(*Nested)(w2.Get(newB, ecs.ComponentID[Nested](w2))))
+		assert.Equal(t, newA, w2.Relations().Get(newB, relId2))
+		assert.Equal(t, Threats{newA: 0.5, newB: 1.5}, *ecs.GetResource[Threats](w2))
+	}
+
+	// The world's data is not modified by compaction.
+	assert.Equal(t, before, *(*Nested)(w.Get(b, nestedId)))
+	assert.Equal(t, a, w.Relations().Get(b, relId))
+	assert.Equal(t, Threats{a: 0.5, b: 1.5}, *ecs.GetResource[Threats](w))
+
+	jsonData, err := archeserde.Serialize(w, archeserde.Opts.CompactEntities(), archeserde.Opts.SkipEntities())
+	assert.Nil(t, err)
+	assert.NotContains(t, string(jsonData), `"World"`)
+}
@@ -0,0 +1,165 @@
+package archeserde
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// History is an in-memory ring buffer of world snapshots, for rewind and replay.
+//
+// Only the latest snapshot is stored in full.
+// All older snapshots are stored as deltas to the next newer one, see [Diff].
+// All entries are compressed with [compress/flate].
+//
+// When the number of snapshots or the total memory of the stored entries exceeds the limits,
+// the oldest snapshots are evicted. The latest snapshot is never evicted.
+//
+// A History is not safe for concurrent use.
+type History struct {
+	capacity int
+	maxBytes int
+	options  []Option
+
+	latest []byte   // Latest snapshot, compressed.
+	deltas [][]byte // Compressed deltas to the next newer snapshot, oldest first.
+	size   int
+}
+
+// NewHistory creates a new, empty [History].
+//
+// Argument capacity is the maximum number of snapshots, and maxBytes the maximum memory for the stored entries.
+// Zero means no limit.
+// The options are used for serializing as well as for restoring snapshots.
+func NewHistory(capacity int, maxBytes int, options ...Option) *History {
+	return &History{
+		capacity: capacity,
+		maxBytes: maxBytes,
+		options:  options,
+	}
+}
+
+// Push serializes the world and adds it as the latest snapshot.
+// Evicts the oldest snapshots if the limits are exceeded.
+func (h *History) Push(world *ecs.World) error {
+	jsonData, err := Serialize(world, h.options...)
+	if err != nil {
+		return err
+	}
+	latest, err := compress(jsonData)
+	if err != nil {
+		return err
+	}
+
+	if h.latest != nil {
+		previous, err := decompress(h.latest)
+		if err != nil {
+			return err
+		}
+		delta, err := Diff(jsonData, previous)
+		if err != nil {
+			return err
+		}
+		if delta, err = compress(delta); err != nil {
+			return err
+		}
+		h.deltas = append(h.deltas, delta)
+		h.size += len(delta) - len(h.latest)
+	}
+	h.latest = latest
+	h.size += len(latest)
+
+	h.evict()
+	return nil
+}
+
+// Restore deserializes the snapshot with the given index into a world.
+// Index 0 is the oldest snapshot, and index [History.Len]-1 the latest.
+//
+// The world must be prepared like for [Deserialize].
+func (h *History) Restore(index int, world *ecs.World) error {
+	if index < 0 || index >= h.Len() {
+		return fmt.Errorf("snapshot index %d out of range of %d snapshots", index, h.Len())
+	}
+
+	latest, err := decompress(h.latest)
+	if err != nil {
+		return err
+	}
+	snap, err := readSnapshot(latest)
+	if err != nil {
+		return err
+	}
+	for i := len(h.deltas) - 1; i >= index; i-- {
+		delta, err := decompress(h.deltas[i])
+		if err != nil {
+			return err
+		}
+		d, err := readDelta(delta)
+		if err != nil {
+			return err
+		}
+		if err := snap.apply(d); err != nil {
+			return err
+		}
+	}
+
+	opts := newSerdeOptions(h.options...)
+	return snap.load(world, nil, &opts)
+}
+
+// Len returns the number of stored snapshots.
+func (h *History) Len() int {
+	if h.latest == nil {
+		return 0
+	}
+	return len(h.deltas) + 1
+}
+
+// Size returns the memory of the stored, compressed entries in bytes.
+func (h *History) Size() int {
+	return h.size
+}
+
+// Clear removes all snapshots.
+func (h *History) Clear() {
+	h.latest = nil
+	h.deltas = nil
+	h.size = 0
+}
+
+// evict removes the oldest snapshots while the limits are exceeded.
+func (h *History) evict() {
+	for len(h.deltas) > 0 &&
+		((h.capacity > 0 && h.Len() > h.capacity) || (h.maxBytes > 0 && h.size > h.maxBytes)) {
+		h.size -= len(h.deltas[0])
+		h.deltas[0] = nil
+		h.deltas = h.deltas[1:]
+	}
+}
+
+// compress compresses data with [compress/flate].
+func compress(data []byte) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	writer, err := flate.NewWriter(&buffer, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// decompress decompresses data compressed by [compress].
+func decompress(data []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(data))
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
@@ -0,0 +1,92 @@
+package archeserde_test
+
+import (
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	velId := ecs.ComponentID[Velocity](&w)
+	_ = ecs.AddResource(&w, &Velocity{})
+
+	history := archeserde.NewHistory(5, 0)
+	assert.Equal(t, 0, history.Len())
+
+	entities := []ecs.Entity{}
+	snapshots := [][]byte{}
+	for step := 0; step < 8; step++ {
+		if step%3 == 2 {
+			w.RemoveEntity(entities[0])
+			entities = entities[1:]
+		}
+		e := w.NewEntity(posId, velId)
+		*(*Velocity)(w.Get(e, velId)) = Velocity{X: float64(step)}
+		entities = append(entities, e)
+		for _, e := range entities {
+			(*Position)(w.Get(e, posId)).X += 1
+		}
+		ecs.GetResource[Velocity](&w).X = float64(step)
+
+		assert.Nil(t, history.Push(&w))
+		jsonData, err := archeserde.Serialize(&w, archeserde.Opts.Canonical())
+		assert.Nil(t, err)
+		snapshots = append(snapshots, jsonData)
+	}
+	assert.Equal(t, 5, history.Len())
+	assert.Greater(t, history.Size(), 0)
+
+	snapshots = snapshots[3:]
+	for i := history.Len() - 1; i >= 0; i-- {
+		w2 := ecs.NewWorld()
+		_ = ecs.ComponentID[Position](&w2)
+		_ = ecs.ComponentID[Velocity](&w2)
+		_ = ecs.ResourceID[Velocity](&w2)
+		assert.Nil(t, history.Restore(i, &w2))
+
+		jsonData, err := archeserde.Serialize(&w2, archeserde.Opts.Canonical())
+		assert.Nil(t, err)
+		assert.Equal(t, string(snapshots[i]), string(jsonData))
+	}
+
+	w2 := ecs.NewWorld()
+	err := history.Restore(5, &w2)
+	assert.Contains(t, err.Error(), "snapshot index 5 out of range of 5 snapshots")
+
+	history.Clear()
+	assert.Equal(t, 0, history.Len())
+	assert.Equal(t, 0, history.Size())
+}
+
+func TestHistoryMaxBytes(t *testing.T) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+
+	history := archeserde.NewHistory(0, 1)
+	for step := 0; step < 5; step++ {
+		w.NewEntity(posId)
+		assert.Nil(t, history.Push(&w))
+		assert.Equal(t, 1, history.Len())
+	}
+
+	history = archeserde.NewHistory(0, 0)
+	for step := 0; step < 50; step++ {
+		w.NewEntity(posId)
+		assert.Nil(t, history.Push(&w))
+	}
+	assert.Equal(t, 50, history.Len())
+	size := history.Size()
+
+	history = archeserde.NewHistory(0, size/2)
+	for step := 0; step < 50; step++ {
+		w.NewEntity(posId)
+		assert.Nil(t, history.Push(&w))
+		assert.LessOrEqual(t, history.Size(), size/2)
+	}
+	assert.Less(t, history.Len(), 50)
+	assert.Greater(t, history.Len(), 1)
+}
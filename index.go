@@ -0,0 +1,469 @@
+package archeserde
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// SectionIndex is the name of the table of contents section, written with option [Options.Index].
+const SectionIndex = "Index"
+
+// indexKey precedes the table of contents, which is always the last entry of a document.
+const indexKey = "\n\"" + SectionIndex + "\" : "
+
+// byteRange is a range of bytes in a document, from start (inclusive) to end (exclusive).
+type byteRange [2]int
+
+// tableOfContents holds the byte ranges of the sections of a JSON document.
+//
+// Ranges refer to the uncompressed document.
+// Ranges of sections include the section's key.
+type tableOfContents struct {
+	Sections   map[string]byteRange
+	Archetypes []archetypeContents `json:",omitempty"`
+
+	position func() int // Current write position, when serializing.
+}
+
+// archetypeContents holds the byte ranges of an archetype in [ArchetypeLayout].
+type archetypeContents struct {
+	Types    []int       // Indices of the archetype's types in section "Types".
+	Entities byteRange   // Range of the archetype's entities array.
+	Columns  []byteRange // Range of each component column array.
+}
+
+// newTableOfContents creates a table of contents for writing.
+func newTableOfContents(position func() int) *tableOfContents {
+	return &tableOfContents{Sections: map[string]byteRange{}, position: position}
+}
+
+// offset returns the current write position.
+// Returns 0 if the table of contents is nil.
+func (t *tableOfContents) offset() int {
+	if t == nil {
+		return 0
+	}
+	return t.position()
+}
+
+// section sets the range of a section, from start to the current write position.
+// Has no effect for empty sections, or if the table of contents is nil.
+func (t *tableOfContents) section(name string, start int) {
+	if t == nil {
+		return
+	}
+	if end := t.position(); end > start {
+		t.Sections[name] = byteRange{start, end}
+	}
+}
+
+// write writes the table of contents as the last entry of a document.
+func (t *tableOfContents) write(writer *bufio.Writer) error {
+	jsonData, err := json.Marshal(t)
+	if err != nil {
+		return sectionError(SectionIndex, err)
+	}
+	writer.WriteString(",")
+	writer.WriteString(indexKey)
+	writer.Write(jsonData)
+	return nil
+}
+
+// get returns the data in a range.
+func (r byteRange) get(jsonData []byte) []byte {
+	return jsonData[r[0]:r[1]]
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	writer io.Writer
+	count  int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count += n
+	return n, err
+}
+
+// readIndex reads the table of contents of a JSON document.
+// Returns nil if the document has none, or if it does not match the document.
+// The latter is the case for documents that were edited after they were written.
+func readIndex(jsonData []byte) (*tableOfContents, error) {
+	pos := bytes.LastIndex(jsonData, []byte(indexKey))
+	if pos < 0 {
+		return nil, nil
+	}
+	toc := tableOfContents{}
+	dec := json.NewDecoder(bytes.NewReader(jsonData[pos+len(indexKey):]))
+	if err := dec.Decode(&toc); err != nil {
+		return nil, sectionError(SectionIndex, err)
+	}
+	if !toc.matches(jsonData[:pos]) {
+		return nil, nil
+	}
+
+	// Sections are not scanned when using the index, so the version is checked here.
+	dec = json.NewDecoder(bytes.NewReader(jsonData))
+	version := 0
+	if err := expectDelim(dec, '{', reflect.TypeOf(deserializer{})); err != nil {
+		return nil, err
+	}
+	if token, err := dec.Token(); err == nil && token == "Version" {
+		if version, err = readVersion(dec); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkSection(version, SectionIndex); err != nil {
+		return nil, err
+	}
+	return &toc, nil
+}
+
+// matches checks whether the byte ranges of the table of contents match the document, up to the index.
+//
+// Ranges of sections must start with the section's key, and be followed by the next entry or the end of the document.
+// Ranges of archetypes must be arrays within section "Archetypes".
+func (t *tableOfContents) matches(jsonData []byte) bool {
+	inRange := func(r byteRange) bool {
+		return r[0] >= 0 && r[0] < r[1] && r[1] <= len(jsonData)
+	}
+
+	for name, r := range t.Sections {
+		if !inRange(r) || !bytes.HasPrefix(r.get(jsonData), []byte(quote(name))) {
+			return false
+		}
+		value := bytes.TrimLeft(jsonData[r[0]+len(quote(name)):r[1]], " \t\r\n")
+		if len(value) == 0 || value[0] != ':' {
+			return false
+		}
+		if rest := bytes.TrimLeft(jsonData[r[1]:], " \t\r\n"); len(rest) > 0 && rest[0] != ',' && rest[0] != '}' {
+			return false
+		}
+	}
+
+	if len(t.Archetypes) == 0 {
+		return true
+	}
+	section, ok := t.Sections[SectionArchetypes]
+	if !ok {
+		return false
+	}
+	isArray := func(r byteRange) bool {
+		return inRange(r) && r[0] >= section[0] && r[1] <= section[1] &&
+			jsonData[r[0]] == '[' && jsonData[r[1]-1] == ']'
+	}
+	entitiesKey := []byte(quote("Entities"))
+	for _, arch := range t.Archetypes {
+		if len(arch.Columns) != len(arch.Types) || !isArray(arch.Entities) {
+			return false
+		}
+		if key := bytes.TrimRight(jsonData[:arch.Entities[0]], " \t\r\n:"); !bytes.HasSuffix(key, entitiesKey) {
+			return false
+		}
+		for _, column := range arch.Columns {
+			if !isArray(column) {
+				return false
+			}
+			if before := bytes.TrimRight(jsonData[:column[0]], " \t\r\n"); before[len(before)-1] != '[' && before[len(before)-1] != ',' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// readSections reads the values of the given sections of a JSON document.
+//
+// Uses the table of contents if the document has one,
+// so that only the requested sections are read.
+// Otherwise, the document is scanned, but other sections are not decoded.
+func readSections(jsonData []byte, names ...string) (map[string][]byte, *tableOfContents, error) {
+	toc, err := readIndex(jsonData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sections := map[string][]byte{}
+	if toc != nil {
+		for _, name := range names {
+			if r, ok := toc.Sections[name]; ok {
+				entry := r.get(jsonData)
+				sections[name] = bytes.TrimSpace(entry[bytes.IndexByte(entry, ':')+1:])
+			}
+		}
+		return sections, toc, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(jsonData))
+	if err := expectDelim(dec, '{', reflect.TypeOf(deserializer{})); err != nil {
+		return nil, nil, err
+	}
+	version := 0
+	for first := true; dec.More(); first = false {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, _ := token.(string)
+
+		if key == "Version" {
+			if !first {
+				return nil, nil, fmt.Errorf("entry 'Version' must be the first entry of the document")
+			}
+			if version, err = readVersion(dec); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if err := checkSection(version, key); err != nil {
+			return nil, nil, err
+		}
+
+		value := json.RawMessage{}
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, sectionError(key, err)
+		}
+		if slices.Contains(names, key) {
+			sections[key] = value
+		}
+	}
+	return sections, nil, nil
+}
+
+// DeserializeResources deserializes only the resources from JSON into a world.
+//
+// Entities and components are not read.
+// If the data contains a table of contents, written with option [Options.Index],
+// only the resources section is read. Otherwise, the other sections are skipped without decoding.
+//
+// Resources are prepared and created like for [Deserialize], and the same options apply.
+func DeserializeResources(jsonData []byte, world *ecs.World, options ...Option) error {
+	opts := newSerdeOptions(options...)
+
+	jsonData, err := decompressBytes(jsonData)
+	if err != nil {
+		return err
+	}
+	sections, _, err := readSections(jsonData, SectionResources)
+	if err != nil {
+		return err
+	}
+
+	deserial := deserializer{}
+	if data, ok := sections[SectionResources]; ok {
+		if err := json.Unmarshal(data, &deserial.Resources); err != nil {
+			return sectionError(SectionResources, err)
+		}
+	}
+	return deserializeResources(world, &deserial, &opts)
+}
+
+// ReadEntityDump reads only the entity dump from JSON.
+//
+// Components and resources are not read.
+// For [RecordLayout], the entity dump is reconstructed from the entity records and the free entities.
+// See [DeserializeResources] for the use of the table of contents.
+func ReadEntityDump(jsonData []byte) (ecs.EntityDump, error) {
+	jsonData, err := decompressBytes(jsonData)
+	if err != nil {
+		return ecs.EntityDump{}, err
+	}
+	sections, _, err := readSections(jsonData, SectionWorld, SectionFree, SectionEntities)
+	if err != nil {
+		return ecs.EntityDump{}, err
+	}
+
+	dump := ecs.EntityDump{}
+	if data, ok := sections[SectionEntities]; ok {
+		records := []struct{ Entity ecs.Entity }{}
+		if err := json.Unmarshal(data, &records); err != nil {
+			return dump, sectionError(SectionEntities, err)
+		}
+		entities := make([]ecs.Entity, len(records))
+		for i, record := range records {
+			entities[i] = record.Entity
+		}
+		return readRecordDump(sections, entities)
+	}
+	data, ok := sections[SectionWorld]
+	if !ok {
+		return dump, sectionError(SectionWorld, fmt.Errorf("missing section '%s'", SectionWorld))
+	}
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return dump, sectionError(SectionWorld, err)
+	}
+	return dump, nil
+}
+
+// readRecordDump reconstructs the entity dump of [RecordLayout] from the entities of the records,
+// and the free entities in the sections of a document.
+func readRecordDump(sections map[string][]byte, entities []ecs.Entity) (ecs.EntityDump, error) {
+	free := []ecs.Entity{}
+	if data, ok := sections[SectionFree]; ok {
+		if err := json.Unmarshal(data, &free); err != nil {
+			return ecs.EntityDump{}, sectionError(SectionFree, err)
+		}
+	}
+	dump, errs := recordDump(entities, free)
+	if len(errs) > 0 {
+		return ecs.EntityDump{}, errs[0]
+	}
+	return dump, nil
+}
+
+// ReadComponents reads only the components of type T from JSON,
+// together with the entities they belong to.
+//
+// Other component types are not decoded.
+// For [ArchetypeLayout] with a table of contents, written with option [Options.Index],
+// only the entities and component columns of archetypes containing T are read.
+//
+// Entities are returned in the order of the alive entities in the entity dump,
+// or in archetype order for [ArchetypeLayout], or in the order of the records for [RecordLayout].
+// The entities are not remapped, and relation targets are not read.
+//
+// Options [Options.Registry] and [Options.Codec] are considered.
+func ReadComponents[T any](jsonData []byte, options ...Option) ([]ecs.Entity, []T, error) {
+	opts := newSerdeOptions(options...)
+	tp := reflect.TypeOf((*T)(nil)).Elem()
+
+	jsonData, err := decompressBytes(jsonData)
+	if err != nil {
+		return nil, nil, err
+	}
+	sections, toc, err := readSections(jsonData, SectionWorld, SectionTypes, SectionComponents, SectionArchetypes, SectionFree, SectionEntities)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := opts.registry.lookupNames(tp)
+
+	entities := []ecs.Entity{}
+	values := reflect.MakeSlice(reflect.SliceOf(tp), 0, 0)
+
+	if toc != nil && len(toc.Archetypes) > 0 {
+		types := []string{}
+		if err := json.Unmarshal(sections[SectionTypes], &types); err != nil {
+			return nil, nil, sectionError(SectionTypes, err)
+		}
+		for i, arch := range toc.Archetypes {
+			for j, idx := range arch.Types {
+				if idx < 0 || idx >= len(types) {
+					return nil, nil, newError(SectionIndex, i, ecs.Entity{}, "", fmt.Errorf("component type index %d out of range of %d types", idx, len(types)))
+				}
+				if !slices.Contains(names, types[idx]) {
+					continue
+				}
+				archEntities := []ecs.Entity{}
+				if err := json.Unmarshal(arch.Entities.get(jsonData), &archEntities); err != nil {
+					return nil, nil, newError(SectionArchetypes, i, ecs.Entity{}, "", err)
+				}
+				if values, err = appendColumn(values, archEntities, arch.Columns[j].get(jsonData), types[idx], &opts); err != nil {
+					return nil, nil, newError(SectionArchetypes, i, ecs.Entity{}, "", err)
+				}
+				entities = append(entities, archEntities...)
+			}
+		}
+		return entities, values.Interface().([]T), nil
+	}
+
+	if data, ok := sections[SectionArchetypes]; ok {
+		archetypes := []archetypeEntry{}
+		if err := json.Unmarshal(data, &archetypes); err != nil {
+			return nil, nil, sectionError(SectionArchetypes, err)
+		}
+		for i, arch := range archetypes {
+			if len(arch.Components) != len(arch.Types) {
+				return nil, nil, newError(SectionArchetypes, i, ecs.Entity{}, "", fmt.Errorf("found %d component columns for %d types in archetype", len(arch.Components), len(arch.Types)))
+			}
+			for j, name := range arch.Types {
+				if !slices.Contains(names, name) {
+					continue
+				}
+				if values, err = appendColumn(values, arch.Entities, arch.Components[j].Bytes, name, &opts); err != nil {
+					return nil, nil, newError(SectionArchetypes, i, ecs.Entity{}, "", err)
+				}
+				entities = append(entities, arch.Entities...)
+			}
+		}
+	}
+
+	if data, ok := sections[SectionEntities]; ok {
+		records := []entityRecord{}
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, nil, sectionError(SectionEntities, err)
+		}
+		if _, err := readRecordDump(sections, recordEntities(records)); err != nil {
+			return nil, nil, err
+		}
+		for i, record := range records {
+			for _, name := range names {
+				value, ok := record.Components[name]
+				if !ok {
+					continue
+				}
+				ptr := reflect.New(tp)
+				if err := opts.codecs.unmarshalJSON(value, ptr); err != nil {
+					return nil, nil, newError(SectionEntities, i, record.Entity, name, err)
+				}
+				values = reflect.Append(values, ptr.Elem())
+				entities = append(entities, record.Entity)
+				break
+			}
+		}
+	}
+
+	if data, ok := sections[SectionComponents]; ok {
+		dump := ecs.EntityDump{}
+		if err := json.Unmarshal(sections[SectionWorld], &dump); err != nil {
+			return nil, nil, sectionError(SectionWorld, err)
+		}
+		components := []map[string]entry{}
+		if err := json.Unmarshal(data, &components); err != nil {
+			return nil, nil, sectionError(SectionComponents, err)
+		}
+		if len(components) != len(dump.Alive) {
+			return nil, nil, sectionError(SectionComponents, fmt.Errorf("found components for %d entities, but world has %d alive entities", len(components), len(dump.Alive)))
+		}
+		for i, mp := range components {
+			if int(dump.Alive[i]) >= len(dump.Entities) {
+				return nil, nil, sectionError(SectionWorld, fmt.Errorf("alive entity index %d out of range of %d entities", dump.Alive[i], len(dump.Entities)))
+			}
+			entity := dump.Entities[dump.Alive[i]]
+			for _, name := range names {
+				value, ok := mp[name]
+				if !ok {
+					continue
+				}
+				ptr := reflect.New(tp)
+				if err := opts.codecs.unmarshalJSON(value.Bytes, ptr); err != nil {
+					return nil, nil, newError(SectionComponents, i, entity, name, err)
+				}
+				values = reflect.Append(values, ptr.Elem())
+				entities = append(entities, entity)
+				break
+			}
+		}
+	}
+
+	return entities, values.Interface().([]T), nil
+}
+
+// appendColumn decodes a component column of an archetype, and appends its values.
+func appendColumn(values reflect.Value, entities []ecs.Entity, jsonData []byte, tpName string, opts *serdeOptions) (reflect.Value, error) {
+	column, err := opts.codecs.unmarshalColumn(jsonData, values.Type().Elem())
+	if err != nil {
+		return values, typeError(tpName, err)
+	}
+	if column.Len() != len(entities) {
+		return values, typeError(tpName, fmt.Errorf("found %d values of %s for %d entities in archetype", column.Len(), tpName, len(entities)))
+	}
+	return reflect.AppendSlice(values, column), nil
+}
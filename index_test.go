@@ -0,0 +1,144 @@
+package archeserde_test
+
+import (
+	"bytes"
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndex(t *testing.T) {
+	w := parallelWorld(1000)
+	posId := ecs.ComponentID[Position](w)
+	velId := ecs.ComponentID[Velocity](w)
+
+	for _, options := range [][]archeserde.Option{
+		{},
+		{archeserde.Opts.Index()},
+		{archeserde.Opts.Index(), archeserde.Opts.Workers(4), archeserde.Opts.Compression(archeserde.Gzip)},
+		{archeserde.Opts.Layout(archeserde.ArchetypeLayout)},
+		{archeserde.Opts.Layout(archeserde.ArchetypeLayout), archeserde.Opts.Index()},
+		{archeserde.Opts.Layout(archeserde.ArchetypeLayout), archeserde.Opts.Index(), archeserde.Opts.Canonical(), archeserde.Opts.Workers(4)},
+	} {
+		jsonData, err := archeserde.Serialize(w, options...)
+		assert.Nil(t, err)
+
+		dump, err := archeserde.ReadEntityDump(jsonData)
+		assert.Nil(t, err)
+		assert.Equal(t, len(w.DumpEntities().Entities), len(dump.Entities))
+		assert.Equal(t, len(w.DumpEntities().Alive), len(dump.Alive))
+
+		entities, positions, err := archeserde.ReadComponents[Position](jsonData)
+		assert.Nil(t, err)
+		assert.Equal(t, 1001, len(entities))
+		assert.Equal(t, len(entities), len(positions))
+		for i, e := range entities {
+			assert.Equal(t, *(*Position)(w.Get(e, posId)), positions[i])
+		}
+
+		entities, velocities, err := archeserde.ReadComponents[Velocity](jsonData)
+		assert.Nil(t, err)
+		assert.Equal(t, 333, len(entities))
+		for i, e := range entities {
+			assert.Equal(t, *(*Velocity)(w.Get(e, velId)), velocities[i])
+		}
+
+		entities, values, err := archeserde.ReadComponents[Generic[int]](jsonData)
+		assert.Nil(t, err)
+		assert.Empty(t, entities)
+		assert.Empty(t, values)
+
+		w2 := ecs.NewWorld()
+		_ = ecs.ResourceID[Velocity](&w2)
+		err = archeserde.DeserializeResources(jsonData, &w2)
+		assert.Nil(t, err)
+		assert.Equal(t, Velocity{X: 1, Y: 2}, *ecs.GetResource[Velocity](&w2))
+		assert.Equal(t, 0, len(w2.DumpEntities().Alive))
+
+		w3 := ecs.NewWorld()
+		_ = ecs.ComponentID[Position](&w3)
+		_ = ecs.ComponentID[Velocity](&w3)
+		_ = ecs.ComponentID[ChildOf](&w3)
+		_ = ecs.ComponentID[ChildRelation](&w3)
+		_ = ecs.ResourceID[Velocity](&w3)
+		assert.Nil(t, archeserde.Validate(jsonData, &w3))
+		assert.Nil(t, archeserde.Deserialize(jsonData, &w3))
+		query := w3.Query(ecs.All(posId))
+		assert.Equal(t, 1001, query.Count())
+		query.Close()
+	}
+}
+
+func TestIndexSelective(t *testing.T) {
+	w := parallelWorld(10)
+
+	jsonData, err := archeserde.Serialize(w, archeserde.Opts.Layout(archeserde.ArchetypeLayout), archeserde.Opts.Index())
+	assert.Nil(t, err)
+
+	// Break the first velocity value, without changing offsets.
+	// It is not read when reading other parts via the index.
+	broken := bytes.ReplaceAll(jsonData, []byte(`{"X":1,"Y":-1}`), []byte(`{"X":x,"Y":-1}`))
+	assert.NotEqual(t, jsonData, broken)
+
+	_, positions, err := archeserde.ReadComponents[Position](broken)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, len(positions))
+
+	w2 := ecs.NewWorld()
+	_ = ecs.ResourceID[Velocity](&w2)
+	assert.Nil(t, archeserde.DeserializeResources(broken, &w2))
+
+	_, _, err = archeserde.ReadComponents[Velocity](broken)
+	assert.Contains(t, err.Error(), "section 'Archetypes', index 1, type archeserde_test.Velocity: invalid character 'x'")
+
+	// Without index, the whole archetypes section is parsed.
+	jsonData, err = archeserde.Serialize(w, archeserde.Opts.Layout(archeserde.ArchetypeLayout))
+	assert.Nil(t, err)
+	broken = bytes.ReplaceAll(jsonData, []byte(`{"X":1,"Y":-1}`), []byte(`{"X":x,"Y":-1}`))
+	_, _, err = archeserde.ReadComponents[Velocity](broken)
+	assert.Contains(t, err.Error(), "invalid character 'x'")
+
+	_, err = archeserde.ReadEntityDump([]byte(`{"Version" : 1, "Resources" : {}}`))
+	assert.Contains(t, err.Error(), "missing section 'World'")
+
+	// An index that does not match the document is ignored, and the document is scanned.
+	_, err = archeserde.ReadEntityDump([]byte(`{"Version" : 2, "Resources" : {},` + "\n\"Index\" : " + `{"Sections":{"World":[0,1000]}}}`))
+	assert.Contains(t, err.Error(), "missing section 'World'")
+
+	_, err = archeserde.ReadEntityDump([]byte(`{"Version" : 1, "Resources" : {},` + "\n\"Index\" : " + `{"Sections":{}}}`))
+	assert.Contains(t, err.Error(), "section 'Index' is not supported by format version 1")
+}
+
+func TestIndexEdited(t *testing.T) {
+	w := parallelWorld(10)
+
+	for _, layout := range []archeserde.Layout{archeserde.EntityLayout, archeserde.ArchetypeLayout} {
+		jsonData, err := archeserde.Serialize(w, archeserde.Opts.Layout(layout), archeserde.Opts.Index())
+		assert.Nil(t, err)
+		assert.True(t, bytes.HasPrefix(jsonData, []byte("{\n\"Version\" : 2,")))
+
+		// Shift all offsets by editing by hand.
+		edited := bytes.Replace(jsonData, []byte(`"Version" : 2,`), []byte(`"Version" : 2,  `), 1)
+		edited = bytes.Replace(edited, []byte(`{"X":1,"Y":-1}`), []byte(`{"X": 1, "Y": -1}`), 1)
+		assert.NotEqual(t, jsonData, edited)
+
+		dump, err := archeserde.ReadEntityDump(edited)
+		assert.Nil(t, err)
+		assert.Equal(t, w.DumpEntities(), dump)
+
+		_, positions, err := archeserde.ReadComponents[Position](edited)
+		assert.Nil(t, err)
+		assert.Equal(t, 11, len(positions))
+
+		w2 := ecs.NewWorld()
+		_ = ecs.ResourceID[Velocity](&w2)
+		assert.Nil(t, archeserde.DeserializeResources(edited, &w2))
+		assert.Equal(t, Velocity{X: 1, Y: 2}, *ecs.GetResource[Velocity](&w2))
+	}
+
+	jsonData, err := archeserde.Serialize(w)
+	assert.Nil(t, err)
+	assert.True(t, bytes.HasPrefix(jsonData, []byte("{\n\"Version\" : 1,")))
+}
@@ -0,0 +1,396 @@
+package archeserde
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// Layout of the components in serialized JSON.
+//
+// Set it with [Options.Layout].
+type Layout uint8
+
+const (
+	// EntityLayout stores the components of each entity in a separate object, keyed by type name.
+	// Entities appear in the order of alive entities in the entity dump.
+	// This is the default layout, in section "Components".
+	EntityLayout Layout = iota
+	// ArchetypeLayout groups entities by archetype, in section "Archetypes".
+	// Each archetype stores its type list and relation target only once,
+	// followed by its entities and one array per component column.
+	//
+	// Results in considerably smaller files and faster loading for worlds with many entities.
+	ArchetypeLayout
+	// RecordLayout stores one record per entity in section "Entities", for editing files by hand.
+	// Each record holds the entity's ID and generation, its relation target, if any, and its components, keyed by type name.
+	//
+	// Instead of section "World", the entity pool is stored as the list of free entities in section "Free".
+	// Each free entity has the generation its ID gets when it is recycled.
+	// When deserializing, the entity dump is reconstructed from the records and the free entities.
+	// Thus, entity records can be added, removed and reordered by hand.
+	// IDs that are neither used by a record nor listed as free become free, with generation 1.
+	// There may be at most 65536 of them, so IDs are limited by the number of records and free entities.
+	//
+	//	"Free" : [[3,1]],
+	//	"Entities" : [
+	//	  {
+	//	    "Entity" : [1,0],
+	//	    "Components" : {
+	//	      "main.Position" : {"X":1,"Y":2}
+	//	    }
+	//	  },
+	//	  {
+	//	    "Entity" : [2,0],
+	//	    "Target" : [1,0],
+	//	    "Components" : {
+	//	      "main.ChildOf" : {}
+	//	    }
+	//	  }
+	//	]
+	//
+	// Section "Free" must precede section "Entities".
+	// Documents in this layout are written in format version 2, which older readers reject.
+	RecordLayout
+)
+
+// archetypeEntry is a single archetype in [ArchetypeLayout].
+type archetypeEntry struct {
+	Types      []string
+	Target     ecs.Entity
+	Entities   []ecs.Entity
+	Components []entry
+}
+
+// archetypeRun is a contiguous run of entities with the same components and relation target.
+type archetypeRun struct {
+	infos    []compType
+	relation int
+	target   ecs.Entity
+	entities []ecs.Entity
+}
+
+// serializeArchetypes writes the components of all alive entities in the dump, grouped by archetype.
+// If toc is not nil, the byte ranges of all archetypes are added to it.
+// If local is not nil, entities, relation targets and entities in components are mapped to local entities.
+func serializeArchetypes(world *ecs.World, dump *ecs.EntityDump, infos []compType, writer *bufio.Writer, opts *serdeOptions, toc *tableOfContents, local entityRemap) error {
+	if opts.skipEntities || opts.skipAllComponents {
+		writer.WriteString("\"Archetypes\" : []")
+		return nil
+	}
+
+	runs := collectArchetypes(world, dump, infos, opts, local)
+
+	typeIndex := map[ecs.ID]int{}
+	for i, info := range infos {
+		typeIndex[info.ID] = i
+	}
+
+	writer.WriteString("\"Archetypes\" : [\n")
+
+	for i := range runs {
+		run := &runs[i]
+		var contents *archetypeContents
+		if toc != nil {
+			toc.Archetypes = append(toc.Archetypes, archetypeContents{Types: []int{}, Columns: []byteRange{}})
+			contents = &toc.Archetypes[len(toc.Archetypes)-1]
+			for _, info := range run.infos {
+				contents.Types = append(contents.Types, typeIndex[info.ID])
+			}
+		}
+		writer.WriteString("  {\n")
+
+		writer.WriteString("    \"Types\" : [")
+		for j, info := range run.infos {
+			writer.WriteString(info.quoted)
+			if j < len(run.infos)-1 {
+				writer.WriteString(", ")
+			}
+		}
+		writer.WriteString("],\n")
+
+		if run.relation >= 0 {
+			eJSON, err := run.target.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(writer, "    \"Target\" : %s,\n", eJSON)
+		}
+
+		entities := run.entities
+		if local != nil {
+			entities = make([]ecs.Entity, len(run.entities))
+			for k, entity := range run.entities {
+				entities[k] = local.get(entity)
+			}
+		}
+		eJSON, err := json.Marshal(entities)
+		if err != nil {
+			return err
+		}
+		writer.WriteString("    \"Entities\" : ")
+		start := toc.offset()
+		writer.Write(eJSON)
+		if contents != nil {
+			contents.Entities = byteRange{start, toc.offset()}
+		}
+		writer.WriteString(",\n")
+
+		writer.WriteString("    \"Components\" : [\n")
+		for j, info := range run.infos {
+			writer.WriteString("      ")
+			start := toc.offset()
+			writer.WriteString("[")
+			err := encodeChunks(writer, len(run.entities), opts.workers, func(w stringWriter, start, end int) error {
+				for k := start; k < end; k++ {
+					entity := run.entities[k]
+					jsonData, err := opts.codecs.marshalJSON(local.localCopy(reflect.NewAt(info.Type, world.GetUnchecked(entity, info.ID))))
+					if err != nil {
+						return newError(SectionArchetypes, i, entity, info.Name, err)
+					}
+					w.Write(jsonData)
+					if k < len(run.entities)-1 {
+						w.WriteString(",")
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			writer.WriteString("]")
+			if contents != nil {
+				contents.Columns = append(contents.Columns, byteRange{start, toc.offset()})
+			}
+			if j < len(run.infos)-1 {
+				writer.WriteString(",")
+			}
+			writer.WriteString("\n")
+		}
+		writer.WriteString("    ]\n")
+
+		writer.WriteString("  }")
+		if i < len(runs)-1 {
+			writer.WriteString(",")
+		}
+		if _, err := writer.WriteString("\n"); err != nil {
+			return err
+		}
+	}
+	writer.WriteString("]")
+
+	return nil
+}
+
+// collectArchetypes groups all entities into runs of the same components and relation target.
+// Entities without any of the given components, and entities not contained in the dump, are omitted.
+// Relation targets are determined by [relationTarget], with the given local mapping.
+//
+// In canonical mode, runs are sorted by their component types and relation target,
+// and entities in each run are sorted by ID.
+func collectArchetypes(world *ecs.World, dump *ecs.EntityDump, infos []compType, opts *serdeOptions, local entityRemap) []archetypeRun {
+	runs := []archetypeRun{}
+	var current *archetypeRun
+	var currentMask ecs.Mask
+
+	query := world.Query(ecs.All())
+	for query.Next() {
+		entity := query.Entity()
+		if !isSelected(dump, entity) {
+			continue
+		}
+		mask := query.Mask()
+
+		if current == nil || mask != currentMask || (current.relation >= 0 && relationTarget(world, dump, entity, current.infos[current.relation].ID, local) != current.target) {
+			run := archetypeRun{relation: -1}
+			for _, info := range infos {
+				if !mask.Get(info.ID) {
+					continue
+				}
+				if info.IsRelation {
+					run.relation = len(run.infos)
+					run.target = relationTarget(world, dump, entity, info.ID, local)
+				}
+				run.infos = append(run.infos, info)
+			}
+			runs = append(runs, run)
+			current = &runs[len(runs)-1]
+			currentMask = mask
+		}
+		current.entities = append(current.entities, entity)
+	}
+
+	result := runs[:0]
+	for _, run := range runs {
+		if len(run.infos) > 0 {
+			result = append(result, run)
+		}
+	}
+
+	if opts.canonical {
+		result = sortArchetypes(result)
+	}
+
+	return result
+}
+
+// sortArchetypes sorts runs by their component types and relation target, and entities in each run by ID.
+// Runs with the same component types and target are merged.
+func sortArchetypes(runs []archetypeRun) []archetypeRun {
+	compare := func(a, b archetypeRun) int {
+		if c := slices.CompareFunc(a.infos, b.infos, func(x, y compType) int {
+			return strings.Compare(x.Name, y.Name)
+		}); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.target.ID(), b.target.ID())
+	}
+	slices.SortFunc(runs, compare)
+
+	result := runs[:0]
+	for _, run := range runs {
+		if len(result) > 0 && compare(result[len(result)-1], run) == 0 {
+			last := &result[len(result)-1]
+			last.entities = append(last.entities, run.entities...)
+			continue
+		}
+		result = append(result, run)
+	}
+
+	for _, run := range result {
+		slices.SortFunc(run.entities, func(a, b ecs.Entity) int {
+			return cmp.Compare(a.ID(), b.ID())
+		})
+	}
+	return result
+}
+
+// streamArchetypes decodes and adds components archetype by archetype, directly from the decoder.
+func streamArchetypes(world *ecs.World, dec *json.Decoder, deserial *deserializer, opts *serdeOptions) error {
+	loader, err := newComponentLoader(world, deserial, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := expectDelim(dec, '[', reflect.TypeOf([]archetypeEntry{})); err != nil {
+		return sectionError(SectionArchetypes, err)
+	}
+	for i := 0; dec.More(); i++ {
+		arch := archetypeEntry{}
+		if err := dec.Decode(&arch); err != nil {
+			return newError(SectionArchetypes, i, ecs.Entity{}, "", err)
+		}
+		if err := loader.loadArchetype(&arch); err != nil {
+			return newError(SectionArchetypes, i, ecs.Entity{}, "", err)
+		}
+	}
+	_, err = dec.Token()
+	return sectionError(SectionArchetypes, err)
+}
+
+// bufferedArchetypes adds components from a fully read "Archetypes" section.
+// Used if the section is found before the "World" and "Types" sections.
+func bufferedArchetypes(world *ecs.World, archetypes []json.RawMessage, deserial *deserializer, opts *serdeOptions) error {
+	if opts.skipEntities {
+		return nil
+	}
+
+	loader, err := newComponentLoader(world, deserial, opts)
+	if err != nil {
+		return err
+	}
+
+	for i, archData := range archetypes {
+		arch := archetypeEntry{}
+		if err := json.Unmarshal(archData, &arch); err != nil {
+			return newError(SectionArchetypes, i, ecs.Entity{}, "", err)
+		}
+		if err := loader.loadArchetype(&arch); err != nil {
+			return newError(SectionArchetypes, i, ecs.Entity{}, "", err)
+		}
+	}
+	return nil
+}
+
+// loadArchetype adds the components of an archetype to its entities.
+func (l *componentLoader) loadArchetype(arch *archetypeEntry) error {
+	deserial := l.deserial
+	if len(arch.Components) != len(arch.Types) {
+		return fmt.Errorf("found %d component columns for %d types in archetype", len(arch.Components), len(arch.Types))
+	}
+
+	ids := make([]ecs.ID, 0, len(arch.Types))
+	columns := make([]reflect.Value, 0, len(arch.Types))
+	var targetComp ecs.ID
+	hasRelation := false
+
+	for i, tpName := range arch.Types {
+		id, ok := l.ids[tpName]
+		if !ok {
+			return typeError(tpName, fmt.Errorf("component type is not registered: %s", tpName))
+		}
+		if l.skipComponents.Get(id) {
+			continue
+		}
+		info := l.infos[id]
+
+		if info.IsRelation {
+			targetComp = id
+			hasRelation = true
+		}
+
+		column, err := l.codecs.unmarshalColumn(arch.Components[i].Bytes, info.Type)
+		if err != nil {
+			return typeError(tpName, err)
+		}
+		if column.Len() != len(arch.Entities) {
+			return typeError(tpName, fmt.Errorf("found %d values of %s for %d entities in archetype", column.Len(), tpName, len(arch.Entities)))
+		}
+
+		ids = append(ids, id)
+		columns = append(columns, column)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	target := arch.Target
+	if deserial.remap != nil {
+		target = deserial.remap.get(target)
+	}
+
+	for i, entity := range arch.Entities {
+		if remap := deserial.remap; remap != nil {
+			mapped, ok := remap[entity]
+			if !ok {
+				return newError("", -1, entity, "", fmt.Errorf("entity %v in archetype is not alive", entity))
+			}
+			entity = mapped
+		} else if int(entity.ID()) >= len(deserial.World.Entities) || !l.world.Alive(entity) {
+			return newError("", -1, entity, "", fmt.Errorf("entity %v in archetype is not alive", entity))
+		}
+		if mask := l.world.Mask(entity); !mask.IsZero() {
+			return newError("", -1, entity, "", fmt.Errorf("entity %v is contained in multiple archetypes", entity))
+		}
+
+		l.world.Add(entity, ids...)
+		for j, id := range ids {
+			value := columns[j].Index(i)
+			if deserial.remap != nil {
+				deserial.remap.remapValue(value)
+			}
+			assignValue(l.world, entity, id, value)
+		}
+		if hasRelation && !target.IsZero() {
+			l.world.Relations().Set(entity, targetComp, target)
+		}
+	}
+	return nil
+}
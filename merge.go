@@ -0,0 +1,100 @@
+package archeserde
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// DeserializeMerge deserializes JSON into a world that may already contain entities.
+//
+// In contrast to [Deserialize], the entities of the serialized world are not restored with their original IDs.
+// Instead, a new entity is created in the world for each alive entity.
+// All entities in the deserialized data are rewritten to the new entities:
+//   - Relation targets
+//   - Entities in components, including those nested in pointers, slices, arrays, maps and structs
+//   - Entities in resources, the same way
+//
+// References to entities that are not alive in the serialized world are set to the zero entity.
+//
+// Returns the mapping from serialized to newly created entities,
+// so that callers can rewrite their own references.
+//
+// Resources contained in the data replace the world's existing resources of the same type.
+// Component types and resources must be prepared like for [Deserialize].
+//
+// On error, the entities already created in the world are removed again.
+// Resources that were already replaced are not restored.
+//
+// See [DeserializeMergeFrom] for reading directly from an [io.Reader].
+func DeserializeMerge(jsonData []byte, world *ecs.World, options ...Option) (map[ecs.Entity]ecs.Entity, error) {
+	return DeserializeMergeFrom(bytes.NewReader(jsonData), world, options...)
+}
+
+// DeserializeMergeFrom deserializes JSON read from the given [io.Reader] into a world that may already contain entities.
+//
+// See [DeserializeMerge] for details.
+func DeserializeMergeFrom(r io.Reader, world *ecs.World, options ...Option) (map[ecs.Entity]ecs.Entity, error) {
+	opts := newSerdeOptions(options...)
+
+	deserial := deserializer{remap: entityRemap{}}
+	if err := deserializeJSON(r, world, &deserial, &opts); err != nil {
+		deserial.remap.remove(world)
+		return nil, err
+	}
+	return deserial.remap, nil
+}
+
+// entityRemap maps entities of a serialized world to entities of the world deserialized into.
+type entityRemap map[ecs.Entity]ecs.Entity
+
+// create creates a new entity for each alive entity in the dump.
+func (r entityRemap) create(world *ecs.World, dump *ecs.EntityDump) {
+	for _, idx := range dump.Alive {
+		r[dump.Entities[idx]] = world.NewEntity()
+	}
+}
+
+// remove removes all created entities from the world.
+func (r entityRemap) remove(world *ecs.World) {
+	for _, entity := range r {
+		if world.Alive(entity) {
+			world.RemoveEntity(entity)
+		}
+	}
+}
+
+// get returns the new entity for a serialized entity.
+// Returns the zero entity for the zero entity, and for unknown entities.
+func (r entityRemap) get(entity ecs.Entity) ecs.Entity {
+	if entity.IsZero() {
+		return entity
+	}
+	return r[entity]
+}
+
+// remapValue rewrites all entities contained in an addressable value, in place.
+func (r entityRemap) remapValue(value reflect.Value) {
+	rewriteEntities(value, false, r.get)
+}
+
+// remapCopy returns a pointer to a remapped copy of a value, given as a pointer.
+//
+// The copy is a deep copy as far as it contains entities, so that the original value is not modified.
+func (r entityRemap) remapCopy(value reflect.Value) reflect.Value {
+	cp := reflect.New(value.Type().Elem())
+	cp.Elem().Set(value.Elem())
+	rewriteEntities(cp.Elem(), true, r.get)
+	return cp
+}
+
+// localCopy returns a remapped copy of a value, given as a pointer, like [entityRemap.remapCopy].
+// For a nil remap, the value itself is returned.
+func (r entityRemap) localCopy(value reflect.Value) reflect.Value {
+	if r == nil {
+		return value
+	}
+	return r.remapCopy(value)
+}
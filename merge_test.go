@@ -0,0 +1,139 @@
+package archeserde_test
+
+import (
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+type Selection struct {
+	Selected ecs.Entity
+}
+
+func TestDeserializeMerge(t *testing.T) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	childId := ecs.ComponentID[ChildOf](&w)
+	relId := ecs.ComponentID[ChildRelation](&w)
+
+	parent := w.NewEntity(posId)
+	*(*Position)(w.Get(parent, posId)) = Position{X: 1, Y: 2}
+	child := w.NewEntity(posId, childId, relId)
+	*(*Position)(w.Get(child, posId)) = Position{X: 3, Y: 4}
+	*(*ChildOf)(w.Get(child, childId)) = ChildOf{Entity: parent}
+	w.Relations().Set(child, relId, parent)
+
+	_ = ecs.AddResource(&w, &Selection{Selected: child})
+
+	for _, layout := range []archeserde.Layout{archeserde.EntityLayout, archeserde.ArchetypeLayout} {
+		jsonData, err := archeserde.Serialize(&w, archeserde.Opts.Layout(layout))
+		assert.Nil(t, err)
+
+		w2 := ecs.NewWorld()
+		posId2 := ecs.ComponentID[Position](&w2)
+		childId2 := ecs.ComponentID[ChildOf](&w2)
+		relId2 := ecs.ComponentID[ChildRelation](&w2)
+		_ = ecs.AddResource(&w2, &Selection{})
+
+		existing := []ecs.Entity{w2.NewEntity(posId2), w2.NewEntity(), w2.NewEntity(posId2)}
+		w2.RemoveEntity(existing[1])
+
+		mapping, err := archeserde.DeserializeMerge(jsonData, &w2)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(mapping))
+
+		newParent, newChild := mapping[parent], mapping[child]
+		assert.True(t, w2.Alive(newParent))
+		assert.True(t, w2.Alive(newChild))
+		assert.NotEqual(t, parent, newParent)
+		assert.NotEqual(t, child, newChild)
+
+		assert.True(t, w2.Alive(existing[0]))
+		assert.True(t, w2.Alive(existing[2]))
+
+		assert.Equal(t, Position{X: 1, Y: 2}, *(*Position)(w2.Get(newParent, posId2)))
+		assert.Equal(t, Position{X: 3, Y: 4}, *(*Position)(w2.Get(newChild, posId2)))
+		assert.Equal(t, ChildOf{Entity: newParent}, *(*ChildOf)(w2.Get(newChild, childId2)))
+		assert.Equal(t, newParent, w2.Relations().Get(newChild, relId2))
+		assert.Equal(t, Selection{Selected: newChild}, *ecs.GetResource[Selection](&w2))
+
+		// Merge a second time.
+		mapping2, err := archeserde.DeserializeMerge(jsonData, &w2)
+		assert.Nil(t, err)
+		assert.NotEqual(t, mapping[parent], mapping2[parent])
+
+		query := w2.Query(ecs.All(posId2))
+		assert.Equal(t, 6, query.Count())
+		query.Close()
+	}
+}
+
+func TestDeserializeMergeDead(t *testing.T) {
+	w := ecs.NewWorld()
+	childId := ecs.ComponentID[ChildOf](&w)
+
+	dead := w.NewEntity()
+	child := w.NewEntity(childId)
+	*(*ChildOf)(w.Get(child, childId)) = ChildOf{Entity: dead}
+	w.RemoveEntity(dead)
+
+	jsonData, err := archeserde.Serialize(&w)
+	assert.Nil(t, err)
+
+	w2 := ecs.NewWorld()
+	childId = ecs.ComponentID[ChildOf](&w2)
+	w2.NewEntity()
+
+	mapping, err := archeserde.DeserializeMerge(jsonData, &w2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(mapping))
+	assert.Equal(t, ChildOf{}, *(*ChildOf)(w2.Get(mapping[child], childId)))
+}
+
+func TestDeserializeMergeResourcesFirst(t *testing.T) {
+	jsonData := []byte(`{
+		"Version" : 1,
+		"Resources" : {"archeserde_test.Selection" : {"Selected" : [2,0]}},
+		"World" : {"Entities":[[0,4294967295],[1,0],[2,0]],"Alive":[1,2],"Next":0,"Available":0},
+		"Types" : ["archeserde_test.Position"],
+		"Components" : [{"archeserde_test.Position" : {"X":1,"Y":2}}, {}]
+	}`)
+
+	w := ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&w)
+	_ = ecs.AddResource(&w, &Selection{})
+	w.NewEntity()
+
+	mapping, err := archeserde.DeserializeMerge(jsonData, &w)
+	assert.Nil(t, err)
+	var selected ecs.Entity
+	for e, newE := range mapping {
+		if e.ID() == 2 {
+			selected = newE
+		}
+	}
+	assert.True(t, w.Alive(selected))
+	assert.Equal(t, Selection{Selected: selected}, *ecs.GetResource[Selection](&w))
+}
+
+func TestDeserializeMergeRollback(t *testing.T) {
+	jsonData := []byte(`{
+		"Version" : 1,
+		"World" : {"Entities":[[0,4294967295],[1,0],[2,0]],"Alive":[1,2],"Next":0,"Available":0},
+		"Types" : ["archeserde_test.Position"],
+		"Components" : [{"archeserde_test.Position" : {"X":1,"Y":2}}, {"archeserde_test.Position" : {"X":"a"}}],
+		"Resources" : {}
+	}`)
+
+	w := ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&w)
+	existing := w.NewEntity()
+
+	mapping, err := archeserde.DeserializeMerge(jsonData, &w)
+	assert.NotNil(t, err)
+	assert.Nil(t, mapping)
+	assert.True(t, w.Alive(existing))
+	assert.Equal(t, []uint32{existing.ID()}, w.DumpEntities().Alive)
+}
@@ -3,6 +3,7 @@ package archeserde
 import (
 	"reflect"
 
+	"github.com/mlange-42/arche/ecs"
 	"github.com/mlange-42/arche/generic"
 )
 
@@ -38,37 +39,287 @@ func (o Options) SkipEntities() Option {
 	}
 }
 
-// SkipAllResources skips serialization or de-serialization of certain components.
+// SkipComponents skips serialization or de-serialization of certain components.
 //
 // When deserializing, the skipped components must still be registered.
+//
+// The option can be used multiple times, and can be combined with all other options for selecting component types.
+// See [Options.OnlyComponents] for the rules.
 func (o Options) SkipComponents(comps ...generic.Comp) Option {
 	return func(o *serdeOptions) {
-		o.skipComponents = make([]reflect.Type, len(comps))
-		for i, c := range comps {
-			o.skipComponents[i] = reflect.Type(c)
-		}
+		o.components.exclude = append(o.components.exclude, typeRules(comps)...)
+	}
+}
+
+// SkipComponentsMatching skips serialization or de-serialization of all components
+// with type names that match any of the given patterns.
+//
+// In patterns, '*' matches any sequence of characters, and '?' matches any single character.
+// E.g., "mypkg.*" matches all types from package mypkg.
+// Type names are the names used for serialization, so they consider the [Registry], if any.
+//
+// See [Options.SkipComponents] for details.
+func (o Options) SkipComponentsMatching(patterns ...string) Option {
+	return func(o *serdeOptions) {
+		o.components.exclude = append(o.components.exclude, patternRules(patterns)...)
+	}
+}
+
+// OnlyComponents restricts serialization or de-serialization of components to the given types.
+//
+// When deserializing, all other components must still be registered.
+// Entities are always serialized, also if they have none of the selected components.
+//
+// Options for selecting component types can be used multiple times, and are combined as follows:
+//   - A type is selected if it is matched by any include option,
+//     [Options.OnlyComponents] or [Options.OnlyComponentsMatching].
+//     Without any include option, all types are selected.
+//   - Selected types are skipped if they are matched by any exclude option,
+//     [Options.SkipComponents] or [Options.SkipComponentsMatching].
+//     Thus, exclusion takes precedence over inclusion.
+//   - [Options.SkipAllComponents] skips all types, regardless of all other options.
+func (o Options) OnlyComponents(comps ...generic.Comp) Option {
+	return func(o *serdeOptions) {
+		o.components.include = append(o.components.include, typeRules(comps)...)
 	}
 }
 
-// SkipAllResources skips serialization or de-serialization of certain resources.
+// OnlyComponentsMatching restricts serialization or de-serialization of components
+// to types with names that match any of the given patterns.
+//
+// See [Options.SkipComponentsMatching] for patterns, and [Options.OnlyComponents] for details.
+func (o Options) OnlyComponentsMatching(patterns ...string) Option {
+	return func(o *serdeOptions) {
+		o.components.include = append(o.components.include, patternRules(patterns)...)
+	}
+}
+
+// SkipResources skips serialization or de-serialization of certain resources.
 //
 // When deserializing, the skipped resources must still be registered.
+//
+// The option can be used multiple times, and can be combined with all other options for selecting resource types.
+// See [Options.OnlyResources] for the rules.
 func (o Options) SkipResources(comps ...generic.Comp) Option {
 	return func(o *serdeOptions) {
-		o.skipResources = make([]reflect.Type, len(comps))
-		for i, c := range comps {
-			o.skipResources[i] = reflect.Type(c)
+		o.resources.exclude = append(o.resources.exclude, typeRules(comps)...)
+	}
+}
+
+// SkipResourcesMatching skips serialization or de-serialization of all resources
+// with type names that match any of the given patterns.
+//
+// See [Options.SkipComponentsMatching] for patterns, and [Options.SkipResources] for details.
+func (o Options) SkipResourcesMatching(patterns ...string) Option {
+	return func(o *serdeOptions) {
+		o.resources.exclude = append(o.resources.exclude, patternRules(patterns)...)
+	}
+}
+
+// OnlyResources restricts serialization or de-serialization of resources to the given types.
+//
+// Options for selecting resource types are combined the same way as for components:
+// a type is selected if it is matched by any include option, or if there are none,
+// and if it is not matched by any exclude option.
+// [Options.SkipAllResources] skips all resources, regardless of all other options.
+// See [Options.OnlyComponents] for details.
+func (o Options) OnlyResources(comps ...generic.Comp) Option {
+	return func(o *serdeOptions) {
+		o.resources.include = append(o.resources.include, typeRules(comps)...)
+	}
+}
+
+// OnlyResourcesMatching restricts serialization or de-serialization of resources
+// to types with names that match any of the given patterns.
+//
+// See [Options.SkipComponentsMatching] for patterns, and [Options.OnlyResources] for details.
+func (o Options) OnlyResourcesMatching(patterns ...string) Option {
+	return func(o *serdeOptions) {
+		o.resources.include = append(o.resources.include, patternRules(patterns)...)
+	}
+}
+
+// Layout sets the layout of the components section when serializing.
+// See [Layout] for the available layouts.
+//
+// When deserializing, all layouts are detected automatically.
+func (o Options) Layout(layout Layout) Option {
+	return func(o *serdeOptions) {
+		o.layout = layout
+	}
+}
+
+// Canonical enables canonical serialization, which results in byte-stable output.
+//
+// Serializing the same world twice results in identical output,
+// and small changes to the world result in small changes of the output.
+// Therefore, canonical output is well suited for version control and checksums.
+//
+// In canonical mode, component types and resources are sorted by their names,
+// and entities are sorted by their IDs rather than in query iteration order.
+// Thus, query iteration order after deserialization may differ from the original world.
+func (o Options) Canonical() Option {
+	return func(o *serdeOptions) {
+		o.canonical = true
+	}
+}
+
+// Registry sets a [Registry] for stable type names.
+//
+// The registry is used for the names of component and resource types,
+// when serializing as well as when deserializing.
+// When deserializing, component types from the registry are registered in the world automatically.
+func (o Options) Registry(registry *Registry) Option {
+	return func(o *serdeOptions) {
+		o.registry = registry
+	}
+}
+
+// ResourceFactory sets a factory function for creating resources of the given type when deserializing.
+//
+// Resources that are not present in the world are created when deserializing.
+// By default, they are created as zero values before the serialized data is applied.
+// A factory can be used for resources that need real construction, like ones holding an RNG or a file handle.
+// It must return a pointer to a new instance of the resource type.
+// The serialized data is applied to the instance after it was created.
+//
+// The option can be used multiple times, for different resource types.
+func (o Options) ResourceFactory(res generic.Comp, factory func() any) Option {
+	return func(o *serdeOptions) {
+		if o.resourceFactories == nil {
+			o.resourceFactories = map[reflect.Type]func() any{}
+		}
+		o.resourceFactories[reflect.Type(res)] = factory
+	}
+}
+
+// Codec sets a custom [Codec] for a component or resource type.
+//
+// The codec is used instead of [encoding/json] or the default binary encoding,
+// when serializing as well as when deserializing.
+//
+// The option can be used multiple times, for different types.
+func (o Options) Codec(tp generic.Comp, codec Codec) Option {
+	return func(o *serdeOptions) {
+		if o.codecs == nil {
+			o.codecs = codecs{}
+		}
+		o.codecs[reflect.Type(tp)] = codec
+	}
+}
+
+// Filter sets a filter for selecting the entities to serialize.
+//
+// Only entities matched by the filter are serialized.
+// All other entities are written to the entity pool as if they were removed from the world.
+// Thus, references to them in components and resources become references to dead entities,
+// and relation targets that are not serialized are reset to the zero entity.
+//
+// Has no effect when deserializing.
+func (o Options) Filter(filter ecs.Filter) Option {
+	return func(o *serdeOptions) {
+		o.filter = filter
+	}
+}
+
+// SkipEntitiesWith skips serialization of all entities that have any of the given components,
+// like transient marker components.
+//
+// Skipped entities are treated the same way as entities not matched by [Options.Filter].
+// The option can be combined with [Options.Filter], and can be used multiple times.
+//
+// Has no effect when deserializing.
+func (o Options) SkipEntitiesWith(comps ...generic.Comp) Option {
+	return func(o *serdeOptions) {
+		for _, c := range comps {
+			o.skipEntitiesWith = append(o.skipEntitiesWith, reflect.Type(c))
 		}
 	}
 }
 
+// CompactEntities drops dead entities and renumbers alive entities densely when serializing.
+//
+// The saved entity pool is the same as the one of a fresh world after creating one entity per serialized entity.
+// Entities get IDs 1, 2, 3, ... in the order of alive entities, and generation 0.
+// Relation targets and entities stored in components and resources are rewritten accordingly.
+// References to entities that are not serialized, like dead entities, become the zero entity.
+//
+// Has no effect when deserializing.
+func (o Options) CompactEntities() Option {
+	return func(o *serdeOptions) {
+		o.compactEntities = true
+	}
+}
+
+// Reachable includes all entities reachable from the root entity in [SerializePrefab],
+// via relation targets and entities stored in components, also nested ones.
+//
+// Has no effect for other functions.
+func (o Options) Reachable() Option {
+	return func(o *serdeOptions) {
+		o.reachable = true
+	}
+}
+
+// Compression sets the [Compression] of the output when serializing.
+//
+// When deserializing, compressed input is detected and decompressed automatically,
+// regardless of this option.
+func (o Options) Compression(compression Compression) Option {
+	return func(o *serdeOptions) {
+		o.compression = compression
+	}
+}
+
+// Index writes a table of contents with the byte ranges of all sections when serializing to JSON,
+// and of the entities and component columns of all archetypes for [ArchetypeLayout].
+//
+// The table of contents is used by [DeserializeResources], [ReadEntityDump] and [ReadComponents]
+// to read only the required parts of a document.
+// It is ignored by all other functions.
+func (o Options) Index() Option {
+	return func(o *serdeOptions) {
+		o.index = true
+	}
+}
+
+// Workers sets the number of goroutines for encoding components when serializing to JSON.
+//
+// With more than one worker, entities or, for [ArchetypeLayout], the values of component columns
+// are split into chunks that are encoded concurrently.
+// The output is identical to sequential encoding.
+// Custom [Codec] functions and JSON marshalling methods of components must be safe for concurrent use.
+//
+// Values below 2 result in sequential encoding, which is the default.
+// Has no effect when deserializing.
+func (o Options) Workers(workers int) Option {
+	return func(o *serdeOptions) {
+		o.workers = workers
+	}
+}
+
 type serdeOptions struct {
 	skipAllResources  bool
 	skipAllComponents bool
 	skipEntities      bool
 
-	skipComponents []reflect.Type
-	skipResources  []reflect.Type
+	layout      Layout
+	canonical   bool
+	registry    *Registry
+	compression Compression
+	workers     int
+	index       bool
+
+	components typeSelection
+	resources  typeSelection
+
+	filter           ecs.Filter
+	skipEntitiesWith []reflect.Type
+	compactEntities  bool
+	reachable        bool
+
+	resourceFactories map[reflect.Type]func() any
+	codecs            codecs
 }
 
 func newSerdeOptions(opts ...Option) serdeOptions {
@@ -78,3 +329,13 @@ func newSerdeOptions(opts ...Option) serdeOptions {
 	}
 	return o
 }
+
+// skipComponent checks whether a component type is skipped by the options for selecting component types.
+func (o *serdeOptions) skipComponent(tp reflect.Type) bool {
+	return !o.components.selects(tp, o.registry)
+}
+
+// skipResource checks whether a resource type is skipped by the options for selecting resource types.
+func (o *serdeOptions) skipResource(tp reflect.Type) bool {
+	return !o.resources.selects(tp, o.registry)
+}
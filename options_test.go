@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/mlange-42/arche/ecs"
 	"github.com/mlange-42/arche/generic"
 	"github.com/stretchr/testify/assert"
 )
@@ -16,12 +17,44 @@ func TestOptions(t *testing.T) {
 		Opts.SkipAllComponents(),
 		Opts.SkipAllResources(),
 		Opts.SkipComponents(generic.T[testComp]()),
+		Opts.SkipComponentsMatching("pkg.*"),
+		Opts.OnlyComponents(generic.T[testComp]()),
+		Opts.OnlyComponentsMatching("pkg.*"),
 		Opts.SkipResources(generic.T[testComp]()),
+		Opts.SkipResourcesMatching("pkg.*"),
+		Opts.OnlyResources(generic.T[testComp]()),
+		Opts.OnlyResourcesMatching("pkg.*"),
+		Opts.Layout(ArchetypeLayout),
+		Opts.Canonical(),
+		Opts.Compression(Gzip),
+		Opts.Workers(4),
+		Opts.Index(),
+		Opts.Registry(NewRegistry()),
+		Opts.Filter(ecs.All()),
+		Opts.SkipEntitiesWith(generic.T[testComp]()),
+		Opts.SkipEntitiesWith(generic.T[int]()),
+		Opts.CompactEntities(),
+		Opts.Reachable(),
+		Opts.Codec(generic.T[testComp](), Codec{}),
+		Opts.ResourceFactory(generic.T[testComp](), func() any { return &testComp{} }),
 	)
 
 	assert.True(t, opt.skipEntities)
 	assert.True(t, opt.skipAllComponents)
 	assert.True(t, opt.skipAllResources)
-	assert.Equal(t, []reflect.Type{generic.T[testComp]()}, opt.skipComponents)
-	assert.Equal(t, []reflect.Type{generic.T[testComp]()}, opt.skipResources)
+	rules := []typeRule{{tp: generic.T[testComp]()}, {pattern: "pkg.*"}}
+	assert.Equal(t, typeSelection{include: rules, exclude: rules}, opt.components)
+	assert.Equal(t, typeSelection{include: rules, exclude: rules}, opt.resources)
+	assert.Equal(t, ArchetypeLayout, opt.layout)
+	assert.True(t, opt.canonical)
+	assert.Equal(t, Gzip, opt.compression)
+	assert.Equal(t, 4, opt.workers)
+	assert.True(t, opt.index)
+	assert.NotNil(t, opt.registry)
+	assert.Contains(t, opt.resourceFactories, generic.T[testComp]())
+	assert.Contains(t, opt.codecs, generic.T[testComp]())
+	assert.NotNil(t, opt.filter)
+	assert.True(t, opt.compactEntities)
+	assert.True(t, opt.reachable)
+	assert.Equal(t, []reflect.Type{generic.T[testComp](), generic.T[int]()}, opt.skipEntitiesWith)
 }
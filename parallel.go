@@ -0,0 +1,60 @@
+package archeserde
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+)
+
+// parallelChunkSize is the number of items encoded by a worker at once.
+const parallelChunkSize = 256
+
+// stringWriter is a writer for bytes and strings, like [bufio.Writer] and [bytes.Buffer].
+type stringWriter interface {
+	io.Writer
+	io.StringWriter
+}
+
+// encodeChunks encodes n items, and writes them to the writer in order.
+//
+// With more than one worker, items are split into chunks that are encoded concurrently into buffers.
+// The buffers of each batch of chunks are written in order after the batch is complete,
+// so that the output is the same as for sequential encoding, and memory use is bounded.
+// On errors, the error of the first failing chunk is returned.
+//
+// Otherwise, all items are encoded directly to the writer.
+func encodeChunks(writer *bufio.Writer, n int, workers int, encode func(w stringWriter, start, end int) error) error {
+	if workers < 2 || n <= parallelChunkSize {
+		return encode(writer, 0, n)
+	}
+
+	buffers := make([]bytes.Buffer, workers)
+	errs := make([]error, workers)
+	for batch := 0; batch < n; batch += workers * parallelChunkSize {
+		chunks := min((n-batch+parallelChunkSize-1)/parallelChunkSize, workers)
+
+		wg := sync.WaitGroup{}
+		wg.Add(chunks)
+		for i := 0; i < chunks; i++ {
+			start := batch + i*parallelChunkSize
+			end := min(start+parallelChunkSize, n)
+			buffers[i].Reset()
+			go func(i, start, end int) {
+				defer wg.Done()
+				errs[i] = encode(&buffers[i], start, end)
+			}(i, start, end)
+		}
+		wg.Wait()
+
+		for i := 0; i < chunks; i++ {
+			if errs[i] != nil {
+				return errs[i]
+			}
+			if _, err := writer.Write(buffers[i].Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package archeserde_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/mlange-42/arche/generic"
+	"github.com/stretchr/testify/assert"
+)
+
+func parallelWorld(count int) *ecs.World {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	velId := ecs.ComponentID[Velocity](&w)
+	childId := ecs.ComponentID[ChildOf](&w)
+	relId := ecs.ComponentID[ChildRelation](&w)
+
+	parent := w.NewEntity(posId)
+	for i := 0; i < count; i++ {
+		var e ecs.Entity
+		switch i % 3 {
+		case 0:
+			e = w.NewEntity(posId)
+		case 1:
+			e = w.NewEntity(posId, velId, childId)
+			*(*Velocity)(w.Get(e, velId)) = Velocity{X: float64(i), Y: -float64(i)}
+			*(*ChildOf)(w.Get(e, childId)) = ChildOf{Entity: parent}
+		default:
+			e = w.NewEntity(posId, relId)
+			w.Relations().Set(e, relId, parent)
+		}
+		*(*Position)(w.Get(e, posId)) = Position{X: float64(i), Y: float64(i) / 3}
+	}
+	ecs.AddResource(&w, &Velocity{X: 1, Y: 2})
+
+	return &w
+}
+
+func TestSerializeWorkers(t *testing.T) {
+	w := parallelWorld(2500)
+
+	for _, options := range [][]archeserde.Option{
+		{},
+		{archeserde.Opts.Canonical()},
+		{archeserde.Opts.Layout(archeserde.ArchetypeLayout)},
+		{archeserde.Opts.Layout(archeserde.ArchetypeLayout), archeserde.Opts.Canonical()},
+	} {
+		sequential, err := archeserde.Serialize(w, options...)
+		assert.Nil(t, err)
+
+		for _, workers := range []int{2, 3, 8} {
+			parallel, err := archeserde.Serialize(w, append(options, archeserde.Opts.Workers(workers))...)
+			assert.Nil(t, err)
+			assert.Equal(t, string(sequential), string(parallel))
+		}
+	}
+
+	small := parallelWorld(10)
+	sequential, err := archeserde.Serialize(small)
+	assert.Nil(t, err)
+	parallel, err := archeserde.Serialize(small, archeserde.Opts.Workers(4))
+	assert.Nil(t, err)
+	assert.Equal(t, string(sequential), string(parallel))
+}
+
+func TestSerializeWorkersError(t *testing.T) {
+	w := parallelWorld(2500)
+
+	codec := archeserde.Codec{
+		Encode: func(value any) ([]byte, error) {
+			if vel := value.(*Velocity); vel.X >= 1000 {
+				return nil, fmt.Errorf("velocity %.0f out of range", vel.X)
+			}
+			return []byte("{}"), nil
+		},
+	}
+
+	for _, layout := range []archeserde.Layout{archeserde.EntityLayout, archeserde.ArchetypeLayout} {
+		_, errSequential := archeserde.Serialize(w, archeserde.Opts.Codec(generic.T[Velocity](), codec), archeserde.Opts.Layout(layout))
+		_, errParallel := archeserde.Serialize(w, archeserde.Opts.Codec(generic.T[Velocity](), codec), archeserde.Opts.Layout(layout), archeserde.Opts.Workers(4))
+
+		var serdeErr *archeserde.Error
+		assert.True(t, errors.As(errParallel, &serdeErr))
+		assert.Equal(t, "archeserde_test.Velocity", serdeErr.Type)
+		assert.Contains(t, errParallel.Error(), "velocity 1000 out of range")
+		assert.Equal(t, errSequential.Error(), errParallel.Error())
+	}
+}
@@ -0,0 +1,158 @@
+package archeserde
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// SerializePrefab serializes an entity as a self-contained prefab document.
+//
+// With option [Options.Reachable], all entities reachable from the entity are included,
+// via relation targets as well as via entities stored in components, also in slices, maps and nested structs.
+//
+// Entities in the prefab get local IDs, starting with 1 for the given root entity.
+// Relation targets and stored entities that refer to entities not contained in the prefab
+// are set to the zero entity. Resources are not serialized.
+//
+// The prefab document has the same format as the output of [Serialize].
+// It can be instantiated with [SpawnPrefab], or deserialized with [Deserialize] and [DeserializeMerge].
+//
+// Options for skipping components, as well as options [Options.Registry], [Options.Codec] and [Options.Canonical],
+// are applied like for [Serialize].
+func SerializePrefab(world *ecs.World, entity ecs.Entity, options ...Option) ([]byte, error) {
+	opts := newSerdeOptions(options...)
+	opts.skipEntities = false
+	opts.skipAllResources = true
+
+	if !world.Alive(entity) {
+		return nil, fmt.Errorf("prefab root entity %v is not alive", entity)
+	}
+
+	infos, err := componentInfos(world, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entities := []ecs.Entity{entity}
+	if opts.reachable {
+		entities = reachableEntities(world, entity, infos)
+	}
+
+	local := entityRemap{}
+	localDump := ecs.EntityDump{Entities: []ecs.Entity{newEntity(0, math.MaxUint32)}}
+	worldDump := ecs.EntityDump{}
+	for i, e := range entities {
+		localEntity := newEntity(uint32(i+1), 0)
+		local[e] = localEntity
+		localDump.Entities = append(localDump.Entities, localEntity)
+		localDump.Alive = append(localDump.Alive, uint32(i+1))
+		worldDump.Entities = append(worldDump.Entities, e)
+		worldDump.Alive = append(worldDump.Alive, uint32(i))
+	}
+
+	buffer := bytes.Buffer{}
+	writer := bufio.NewWriter(&buffer)
+
+	writer.WriteString("{\n")
+	fmt.Fprintf(writer, "\"Version\" : %d,\n", documentVersion(EntityLayout, false))
+	if err := serializeWorld(&localDump, writer, &opts); err != nil {
+		return nil, err
+	}
+	writer.WriteString(",\n")
+	serializeTypes(infos, writer)
+	writer.WriteString(",\n")
+	if err := serializeComponents(world, &worldDump, infos, writer, &opts, local); err != nil {
+		return nil, err
+	}
+	writer.WriteString(",\n")
+	if err := serializeResources(world, writer, &opts, nil); err != nil {
+		return nil, err
+	}
+	writer.WriteString("}\n")
+
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// SpawnPrefab instantiates a prefab document, as written by [SerializePrefab], n times into a world.
+//
+// Each instance gets fresh entities, and relation targets and stored entities
+// inside the instance are remapped to the entities of the same instance.
+// Returns the root entity of each instance.
+//
+// The document is parsed only once, independent of n.
+//
+// On error, the entities already created in the world by any instance are removed again.
+//
+// Component types must be prepared like for [Deserialize].
+// Options are applied like for [Deserialize].
+func SpawnPrefab(jsonData []byte, world *ecs.World, n int, options ...Option) ([]ecs.Entity, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid number of prefab instances %d", n)
+	}
+	opts := newSerdeOptions(options...)
+
+	// The document is parsed once, and loaded for each instance.
+	snap, err := readSnapshot(jsonData)
+	if err != nil {
+		return nil, err
+	}
+	if len(snap.World.Alive) == 0 {
+		return nil, fmt.Errorf("prefab contains no entities")
+	}
+	root := snap.World.Entities[snap.World.Alive[0]]
+
+	roots := make([]ecs.Entity, 0, n)
+	remaps := make([]entityRemap, 0, n)
+	for i := 0; i < n; i++ {
+		remap := entityRemap{}
+		remaps = append(remaps, remap)
+		if err := snap.load(world, remap, &opts); err != nil {
+			for _, r := range remaps {
+				r.remove(world)
+			}
+			return nil, err
+		}
+		roots = append(roots, remap[root])
+	}
+	return roots, nil
+}
+
+// reachableEntities returns all entities reachable from the given entity,
+// via relation targets and entities stored in the given component types.
+// The given entity is the first in the result, followed by all others in breadth-first order.
+func reachableEntities(world *ecs.World, entity ecs.Entity, infos []compType) []ecs.Entity {
+	entities := []ecs.Entity{entity}
+	visited := map[ecs.Entity]bool{entity: true}
+
+	add := func(e ecs.Entity) {
+		if e.IsZero() || visited[e] || !world.Alive(e) {
+			return
+		}
+		visited[e] = true
+		entities = append(entities, e)
+	}
+
+	for i := 0; i < len(entities); i++ {
+		e := entities[i]
+		mask := world.Mask(e)
+		for _, info := range infos {
+			if !mask.Get(info.ID) {
+				continue
+			}
+			if info.IsRelation {
+				add(world.Relations().Get(e, info.ID))
+			}
+			comp := reflect.NewAt(info.Type, world.GetUnchecked(e, info.ID)).Elem()
+			visitEntities(comp, add)
+		}
+	}
+	return entities
+}
@@ -0,0 +1,147 @@
+package archeserde_test
+
+import (
+	"fmt"
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/mlange-42/arche/generic"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefab(t *testing.T) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	childId := ecs.ComponentID[ChildOf](&w)
+	relId := ecs.ComponentID[ChildRelation](&w)
+	selId := ecs.ComponentID[Selection](&w)
+
+	w.NewEntity(posId)
+	external := w.NewEntity(posId)
+
+	root := w.NewEntity(posId, selId, childId)
+	*(*Position)(w.Get(root, posId)) = Position{X: 1, Y: 2}
+	*(*ChildOf)(w.Get(root, childId)) = ChildOf{Entity: external}
+
+	weapon := w.NewEntity(posId, relId)
+	*(*Position)(w.Get(weapon, posId)) = Position{X: 3, Y: 4}
+	w.Relations().Set(weapon, relId, root)
+	*(*Selection)(w.Get(root, selId)) = Selection{Selected: weapon}
+
+	jsonData, err := archeserde.SerializePrefab(&w, root)
+	assert.Nil(t, err)
+
+	w2 := ecs.NewWorld()
+	posId2 := ecs.ComponentID[Position](&w2)
+	childId2 := ecs.ComponentID[ChildOf](&w2)
+	relId2 := ecs.ComponentID[ChildRelation](&w2)
+	selId2 := ecs.ComponentID[Selection](&w2)
+	roots, err := archeserde.SpawnPrefab(jsonData, &w2, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(roots))
+
+	query := w2.Query(ecs.All())
+	assert.Equal(t, 2, query.Count())
+	query.Close()
+	for _, r := range roots {
+		assert.Equal(t, Position{X: 1, Y: 2}, *(*Position)(w2.Get(r, posId2)))
+		assert.True(t, (*Selection)(w2.Get(r, selId2)).Selected.IsZero())
+		assert.True(t, (*ChildOf)(w2.Get(r, childId2)).Entity.IsZero())
+	}
+
+	jsonData, err = archeserde.SerializePrefab(&w, root, archeserde.Opts.Reachable())
+	assert.Nil(t, err)
+
+	w2 = ecs.NewWorld()
+	posId2 = ecs.ComponentID[Position](&w2)
+	childId2 = ecs.ComponentID[ChildOf](&w2)
+	relId2 = ecs.ComponentID[ChildRelation](&w2)
+	selId2 = ecs.ComponentID[Selection](&w2)
+	existing := w2.NewEntity()
+	roots, err = archeserde.SpawnPrefab(jsonData, &w2, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(roots))
+	assert.True(t, w2.Alive(existing))
+
+	query = w2.Query(ecs.All())
+	assert.Equal(t, 1+3*3, query.Count())
+	query.Close()
+
+	weapons := map[ecs.Entity]bool{}
+	for _, r := range roots {
+		assert.NotEqual(t, existing, r)
+		assert.Equal(t, Position{X: 1, Y: 2}, *(*Position)(w2.Get(r, posId2)))
+
+		wp := (*Selection)(w2.Get(r, selId2)).Selected
+		assert.False(t, weapons[wp])
+		weapons[wp] = true
+		assert.Equal(t, Position{X: 3, Y: 4}, *(*Position)(w2.Get(wp, posId2)))
+		assert.Equal(t, r, w2.Relations().Get(wp, relId2))
+
+		ext := (*ChildOf)(w2.Get(r, childId2)).Entity
+		assert.False(t, ext.IsZero())
+		assert.Equal(t, Position{}, *(*Position)(w2.Get(ext, posId2)))
+	}
+
+	w3 := ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&w3)
+	_ = ecs.ComponentID[ChildOf](&w3)
+	_ = ecs.ComponentID[ChildRelation](&w3)
+	_ = ecs.ComponentID[Selection](&w3)
+	err = archeserde.Deserialize(jsonData, &w3)
+	assert.Nil(t, err)
+	query = w3.Query(ecs.All())
+	assert.Equal(t, 3, query.Count())
+	query.Close()
+}
+
+func TestPrefabErrors(t *testing.T) {
+	w := ecs.NewWorld()
+	e := w.NewEntity()
+	w.RemoveEntity(e)
+
+	_, err := archeserde.SerializePrefab(&w, e)
+	assert.Contains(t, err.Error(), "prefab root entity")
+
+	_, err = archeserde.SpawnPrefab([]byte(`{"Version" : 1, "World" : {"Entities":[[0,4294967295]],"Alive":[],"Next":0,"Available":0}}`), &w, 1)
+	assert.Contains(t, err.Error(), "prefab contains no entities")
+
+	_, err = archeserde.SpawnPrefab([]byte(`{"Version" : 1, "World" : {"Entities":[[0,4294967295],[1,0]],"Alive":[1],"Next":0,"Available":0}}`), &w, -1)
+	assert.Contains(t, err.Error(), "invalid number of prefab instances -1")
+
+	_, err = archeserde.SpawnPrefab([]byte(`{"Version" : 1, "Types" : ["archeserde_test.Position"], "World" : {"Entities":[[0,4294967295],[1,0]],"Alive":[1],"Next":0,"Available":0}, "Components" : [{"archeserde_test.Position" : {"X":"a"}}]}`), &w, 2)
+	assert.NotNil(t, err)
+}
+
+func TestSpawnPrefabRollback(t *testing.T) {
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	bitsId := ecs.ComponentID[Bits](&w)
+
+	w.NewEntity(posId)
+	root := w.NewEntity(posId, bitsId)
+
+	jsonData, err := archeserde.SerializePrefab(&w, root, archeserde.Opts.Codec(generic.T[Bits](), bitsCodec))
+	assert.Nil(t, err)
+
+	w2 := ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&w2)
+	_ = ecs.ComponentID[Bits](&w2)
+	w2.NewEntity(posId)
+
+	// Fails when decoding the second instance.
+	calls := 0
+	failing := archeserde.Codec{
+		Decode: func(data []byte, value any) error {
+			calls++
+			if calls > 1 {
+				return fmt.Errorf("test error")
+			}
+			return bitsCodec.Decode(data, value)
+		},
+	}
+	_, err = archeserde.SpawnPrefab(jsonData, &w2, 3, archeserde.Opts.Codec(generic.T[Bits](), failing))
+	assert.Contains(t, err.Error(), "test error")
+	assert.Equal(t, 1, len(w2.DumpEntities().Alive))
+}
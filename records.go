@@ -0,0 +1,260 @@
+package archeserde
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// entityRecord is a single entity in [RecordLayout].
+type entityRecord struct {
+	Entity     ecs.Entity
+	Target     json.RawMessage
+	Components map[string]json.RawMessage
+}
+
+// serializeRecords writes the free entities of the saved dump in section "Free",
+// and one record for each alive entity in the dump in section "Entities".
+//
+// If local is not nil, entities, relation targets and entities in components are mapped to local entities.
+func serializeRecords(world *ecs.World, dump, saved *ecs.EntityDump, infos []compType, writer *bufio.Writer, opts *serdeOptions, local entityRemap, toc *tableOfContents) error {
+	if opts.skipEntities {
+		writer.WriteString("\"Entities\" : []")
+		return nil
+	}
+
+	start := toc.offset()
+	freeJSON, err := json.Marshal(freeEntities(saved))
+	if err != nil {
+		return sectionError(SectionFree, err)
+	}
+	fmt.Fprintf(writer, "\"Free\" : %s", freeJSON)
+	toc.section(SectionFree, start)
+	writer.WriteString(",\n")
+
+	start = toc.offset()
+	writer.WriteString("\"Entities\" : [\n")
+	err = encodeChunks(writer, len(dump.Alive), opts.workers, func(w stringWriter, start, end int) error {
+		return writeRecords(world, dump, infos, w, opts, local, start, end)
+	})
+	if err != nil {
+		return err
+	}
+	writer.WriteString("]")
+	toc.section(SectionEntities, start)
+
+	return nil
+}
+
+// writeRecords writes the records of the alive entities in the given index range of the dump.
+func writeRecords(world *ecs.World, dump *ecs.EntityDump, infos []compType, writer stringWriter, opts *serdeOptions, local entityRemap, start, end int) error {
+	lastEntity := len(dump.Alive) - 1
+	tempInfos := []compType{}
+	for counter := start; counter < end; counter++ {
+		entity := dump.Entities[dump.Alive[counter]]
+
+		saved := entity
+		if local != nil {
+			saved = local.get(entity)
+		}
+		eJSON, err := saved.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		writer.WriteString("  {\n")
+		fmt.Fprintf(writer, "    \"Entity\" : %s,\n", eJSON)
+
+		mask := world.Mask(entity)
+		tempInfos = tempInfos[:0]
+		if !opts.skipAllComponents {
+			for _, info := range infos {
+				if mask.Get(info.ID) {
+					tempInfos = append(tempInfos, info)
+				}
+			}
+		}
+
+		for _, info := range tempInfos {
+			if !info.IsRelation {
+				continue
+			}
+			target := relationTarget(world, dump, entity, info.ID, local)
+			eJSON, err := target.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(writer, "    \"Target\" : %s,\n", eJSON)
+		}
+
+		if len(tempInfos) == 0 {
+			writer.WriteString("    \"Components\" : {}\n")
+		} else {
+			writer.WriteString("    \"Components\" : {\n")
+			last := len(tempInfos) - 1
+			for i, info := range tempInfos {
+				comp := local.localCopy(reflect.NewAt(info.Type, world.GetUnchecked(entity, info.ID)))
+				jsonData, err := opts.codecs.marshalJSON(comp)
+				if err != nil {
+					return newError(SectionEntities, counter, entity, info.Name, err)
+				}
+				fmt.Fprintf(writer, "      %s : ", info.quoted)
+				writer.Write(jsonData)
+				if i < last {
+					writer.WriteString(",")
+				}
+				writer.WriteString("\n")
+			}
+			writer.WriteString("    }\n")
+		}
+
+		writer.WriteString("  }")
+		if counter < lastEntity {
+			writer.WriteString(",")
+		}
+		if _, err := writer.WriteString("\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freeEntities returns the free entities of a dump, in the order they are recycled.
+// Each free entity has the generation its ID gets when recycled.
+func freeEntities(dump *ecs.EntityDump) []ecs.Entity {
+	free := make([]ecs.Entity, 0, dump.Available)
+	next := dump.Next
+	for i := uint32(0); i < dump.Available && int(next) < len(dump.Entities); i++ {
+		free = append(free, newEntity(next, dump.Entities[next].Generation()))
+		next = dump.Entities[next].ID()
+	}
+	return free
+}
+
+// maxRecordGap is the number of IDs in [RecordLayout] that may be unused, i.e. neither used by a record nor listed as free.
+const maxRecordGap = 1 << 16
+
+// recordDump reconstructs an entity dump from the entities of the records in [RecordLayout],
+// and the free entities in section "Free".
+//
+// Entities are alive in the order of the records.
+// Free entities are recycled in the given order.
+// IDs that are neither used by a record nor listed as free are recycled afterwards, in ascending order.
+// They get generation 1, so that references to entities with generation 0 that were removed by hand are not alive.
+//
+// IDs must not exceed the number of records and free entities by more than [maxRecordGap],
+// as all IDs up to the largest one are part of the dump.
+//
+// Returns all problems found, as [*Error]. The dump is only valid if there are none.
+func recordDump(entities []ecs.Entity, free []ecs.Entity) (ecs.EntityDump, []error) {
+	errs := []error{}
+	used := map[uint32]bool{}
+	maxID := uint32(0)
+	limit := uint64(len(entities)) + uint64(len(free)) + maxRecordGap
+	check := func(section string, index int, entity ecs.Entity) bool {
+		if entity.ID() == 0 {
+			errs = append(errs, newError(section, index, entity, "", fmt.Errorf("entity %v has the reserved ID 0", entity)))
+			return false
+		}
+		if uint64(entity.ID()) > limit {
+			errs = append(errs, newError(section, index, entity, "", fmt.Errorf("entity ID %d exceeds the maximum of %d for %d records and %d free entities", entity.ID(), limit, len(entities), len(free))))
+			return false
+		}
+		if used[entity.ID()] {
+			errs = append(errs, newError(section, index, entity, "", fmt.Errorf("entity ID %d is used multiple times", entity.ID())))
+			return false
+		}
+		used[entity.ID()] = true
+		maxID = max(maxID, entity.ID())
+		return true
+	}
+
+	dump := ecs.EntityDump{Alive: make([]uint32, 0, len(entities))}
+	for i, entity := range entities {
+		if check(SectionEntities, i, entity) {
+			dump.Alive = append(dump.Alive, entity.ID())
+		}
+	}
+	freeIDs := make([]uint32, 0, len(free))
+	for i, entity := range free {
+		if check(SectionFree, i, entity) {
+			freeIDs = append(freeIDs, entity.ID())
+		}
+	}
+	if len(errs) > 0 {
+		return dump, errs
+	}
+
+	dump.Entities = make([]ecs.Entity, maxID+1)
+	dump.Entities[0] = newEntity(0, math.MaxUint32)
+	for _, entity := range entities {
+		dump.Entities[entity.ID()] = entity
+	}
+	for _, entity := range free {
+		dump.Entities[entity.ID()] = entity
+	}
+	for id := uint32(1); id <= maxID; id++ {
+		if !used[id] {
+			dump.Entities[id] = newEntity(id, 1)
+			freeIDs = append(freeIDs, id)
+		}
+	}
+
+	// Free entities store the ID of the next free entity instead of their own.
+	for i, id := range freeIDs {
+		link := uint32(0)
+		if i < len(freeIDs)-1 {
+			link = freeIDs[i+1]
+		}
+		dump.Entities[id] = newEntity(link, dump.Entities[id].Generation())
+	}
+	if len(freeIDs) > 0 {
+		dump.Next = freeIDs[0]
+	}
+	dump.Available = uint32(len(freeIDs))
+
+	return dump, nil
+}
+
+// recordEntities returns the entities of records.
+func recordEntities(records []entityRecord) []ecs.Entity {
+	entities := make([]ecs.Entity, len(records))
+	for i := range records {
+		entities[i] = records[i].Entity
+	}
+	return entities
+}
+
+// loadRecords creates the entities of the records in the world, and adds their components.
+func loadRecords(world *ecs.World, records []entityRecord, free []ecs.Entity, deserial *deserializer, opts *serdeOptions) error {
+	dump, errs := recordDump(recordEntities(records), free)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	deserial.World = dump
+	deserial.loadEntities(world)
+
+	loader, err := newComponentLoader(world, deserial, opts)
+	if err != nil {
+		return err
+	}
+
+	mp := map[string]entry{}
+	for i := range records {
+		record := &records[i]
+		clear(mp)
+		for name, value := range record.Components {
+			mp[name] = entry{Bytes: value}
+		}
+		if record.Target != nil {
+			mp[targetTag] = entry{Bytes: record.Target}
+		}
+		if err := loader.load(loader.entity(record.Entity.ID()), mp); err != nil {
+			return newError(SectionEntities, i, record.Entity, "", err)
+		}
+	}
+	return nil
+}
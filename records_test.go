@@ -0,0 +1,191 @@
+package archeserde_test
+
+import (
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+func recordTarget() *ecs.World {
+	w := ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&w)
+	_ = ecs.ComponentID[Velocity](&w)
+	_ = ecs.ComponentID[ChildOf](&w)
+	_ = ecs.ComponentID[ChildRelation](&w)
+	_ = ecs.ResourceID[Velocity](&w)
+	return &w
+}
+
+func TestRecordLayout(t *testing.T) {
+	w := parallelWorld(100)
+	dead1, dead2 := w.NewEntity(), w.NewEntity(ecs.ComponentID[Position](w))
+	w.RemoveEntity(dead1)
+	w.RemoveEntity(dead2)
+
+	jsonData, err := archeserde.Serialize(w, archeserde.Opts.Layout(archeserde.RecordLayout))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(jsonData), `"World"`)
+	assert.Contains(t, string(jsonData), `"Version" : 2,`)
+	assert.Contains(t, string(jsonData), `"Free" : [[103,1],[102,1]]`)
+
+	assert.Nil(t, archeserde.Validate(jsonData, recordTarget()))
+	assert.Nil(t, archeserde.ValidateStructure(jsonData))
+
+	w2 := recordTarget()
+	assert.Nil(t, archeserde.Deserialize(jsonData, w2))
+	assert.Equal(t, w.DumpEntities(), w2.DumpEntities())
+
+	expected, err := archeserde.Serialize(w, archeserde.Opts.Canonical())
+	assert.Nil(t, err)
+	actual, err := archeserde.Serialize(w2, archeserde.Opts.Canonical())
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), string(actual))
+
+	parallel, err := archeserde.Serialize(w, archeserde.Opts.Layout(archeserde.RecordLayout), archeserde.Opts.Workers(4))
+	assert.Nil(t, err)
+	assert.Equal(t, string(jsonData), string(parallel))
+
+	file, err := archeserde.ReadFile(jsonData)
+	assert.Nil(t, err)
+	assert.Equal(t, w.DumpEntities(), file.World)
+	assert.Equal(t, 33, len(file.Targets))
+	marshaled, err := file.Marshal(archeserde.Opts.Layout(archeserde.RecordLayout))
+	assert.Nil(t, err)
+	assert.Equal(t, string(jsonData), string(marshaled))
+
+	indexed, err := archeserde.Serialize(w, archeserde.Opts.Layout(archeserde.RecordLayout), archeserde.Opts.Index())
+	assert.Nil(t, err)
+	for _, data := range [][]byte{jsonData, indexed} {
+		dump, err := archeserde.ReadEntityDump(data)
+		assert.Nil(t, err)
+		assert.Equal(t, w.DumpEntities(), dump)
+
+		entities, values, err := archeserde.ReadComponents[Velocity](data)
+		assert.Nil(t, err)
+		assert.Equal(t, 33, len(entities))
+		assert.Equal(t, Velocity{X: 1, Y: -1}, values[0])
+		assert.Equal(t, Velocity{X: 1, Y: -1}, *(*Velocity)(w.Get(entities[0], ecs.ComponentID[Velocity](w))))
+	}
+
+	compact, err := archeserde.Serialize(w, archeserde.Opts.Layout(archeserde.RecordLayout), archeserde.Opts.CompactEntities())
+	assert.Nil(t, err)
+	assert.Contains(t, string(compact), `"Free" : []`)
+
+	w3 := recordTarget()
+	w3.NewEntity()
+	mapping, err := archeserde.DeserializeMerge(jsonData, w3)
+	assert.Nil(t, err)
+	assert.Equal(t, 101, len(mapping))
+	assert.Equal(t, 102, len(w3.DumpEntities().Alive))
+}
+
+func TestRecordLayoutEdit(t *testing.T) {
+	jsonData := []byte(`{
+		"Version" : 2,
+		"Types" : ["archeserde_test.Position", "archeserde_test.ChildRelation"],
+		"Free" : [[4,5]],
+		"Entities" : [
+		  {
+			"Entity" : [3,0],
+			"Target" : [1,2],
+			"Components" : {
+			  "archeserde_test.Position" : {"X":3,"Y":4},
+			  "archeserde_test.ChildRelation" : {"Dummy":5}
+			}
+		  },
+		  {"Entity" : [1,2], "Components" : {"archeserde_test.Position" : {"X":1,"Y":2}}},
+		  {"Entity" : [6,0], "Components" : {}}
+		],
+		"Resources" : {}
+	}`)
+
+	w := recordTarget()
+	assert.Nil(t, archeserde.Validate(jsonData, w))
+	assert.Nil(t, archeserde.Deserialize(jsonData, w))
+
+	dump := w.DumpEntities()
+	assert.ElementsMatch(t, []uint32{3, 1, 6}, dump.Alive)
+	assert.Equal(t, uint32(3), dump.Available)
+
+	posId := ecs.ComponentID[Position](w)
+	relId := ecs.ComponentID[ChildRelation](w)
+	query := w.Query(ecs.All(posId, relId))
+	assert.True(t, query.Next())
+	child, parent := query.Entity(), query.Relation(relId)
+	query.Close()
+	assert.Equal(t, uint32(3), child.ID())
+	assert.Equal(t, uint32(1), parent.ID())
+	assert.Equal(t, uint32(2), parent.Generation())
+	assert.Equal(t, Position{X: 1, Y: 2}, *(*Position)(w.Get(parent, posId)))
+	assert.Equal(t, ChildRelation{Dummy: 5}, *(*ChildRelation)(w.Get(child, relId)))
+
+	recycled := []ecs.Entity{w.NewEntity(), w.NewEntity(), w.NewEntity(), w.NewEntity()}
+	ids := [][2]uint32{}
+	for _, e := range recycled {
+		ids = append(ids, [2]uint32{e.ID(), e.Generation()})
+	}
+	assert.Equal(t, [][2]uint32{{4, 5}, {2, 1}, {5, 1}, {7, 0}}, ids)
+
+	readDump, err := archeserde.ReadEntityDump(jsonData)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{3, 1, 6}, readDump.Alive)
+	assert.Equal(t, dump.Entities, readDump.Entities)
+	assert.Equal(t, dump.Next, readDump.Next)
+	assert.Equal(t, dump.Available, readDump.Available)
+}
+
+func TestRecordLayoutErrors(t *testing.T) {
+	tests := []struct {
+		document string
+		err      string
+	}{
+		{
+			document: `{"Version":2, "Free":[[2,1]], "Entities":[{"Entity":[1,0]}, {"Entity":[2,0]}]}`,
+			err:      "section 'Free', index 0, entity {2 1}: entity ID 2 is used multiple times",
+		},
+		{
+			document: `{"Version":2, "Entities":[{"Entity":[0,0]}]}`,
+			err:      "section 'Entities', index 0: entity {0 0} has the reserved ID 0",
+		},
+		{
+			document: `{"Version":2, "Entities":[{"Entity":[1,0]}, {"Entity":[4294967295,0]}]}`,
+			err:      "section 'Entities', index 1, entity {4294967295 0}: entity ID 4294967295 exceeds the maximum of 65538 for 2 records and 0 free entities",
+		},
+		{
+			document: `{"Version":2, "Free":[[1000000000,0]], "Entities":[{"Entity":[1,0]}]}`,
+			err:      "section 'Free', index 0, entity {1000000000 0}: entity ID 1000000000 exceeds the maximum",
+		},
+		{
+			document: `{"Version":2, "World":{"Entities":[[0,4294967295]],"Alive":[],"Next":0,"Available":0}, "Entities":[]}`,
+			err:      "sections 'World' and 'Entities' can't be used together",
+		},
+		{
+			document: `{"Version":2, "Entities":[], "Free":[]}`,
+			err:      "section 'Free' must precede section 'Entities'",
+		},
+		{
+			document: `{"Version":1, "Entities":[]}`,
+			err:      "section 'Entities' is not supported by format version 1",
+		},
+	}
+
+	for _, test := range tests {
+		err := archeserde.Deserialize([]byte(test.document), recordTarget())
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), test.err)
+
+		errs := archeserde.Validate([]byte(test.document), recordTarget())
+		assert.NotEmpty(t, errs)
+		assert.Contains(t, errs[0].Error(), test.err)
+	}
+
+	errs := archeserde.Validate([]byte(`{"Version":2, "Types":[], "Entities":[
+		{"Entity":[1,0], "Target":[2,0], "Components":{"archeserde_test.Position":{"X":"a"}}}
+	]}`), recordTarget())
+	assert.Equal(t, 3, len(errs))
+	assert.Contains(t, errs[0].Error(), "section 'Entities', index 0, entity {1 0}, type archeserde_test.Position")
+	assert.Contains(t, errs[1].Error(), "found relation target {2 0}, but no relation component")
+	assert.Contains(t, errs[2].Error(), "relation target {2 0} is not alive")
+}
@@ -0,0 +1,99 @@
+package archeserde
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/mlange-42/arche/generic"
+)
+
+// Registry assigns stable names to component and resource types.
+//
+// By default, types are identified by [reflect.Type.String] in serialized data.
+// These names are not unique for types with the same name from different packages,
+// and they change when types are renamed or moved to another package.
+// A registry solves this by assigning an explicit name to each type,
+// as well as alias names that are accepted when deserializing.
+//
+// Types that are not in the registry are still identified by [reflect.Type.String].
+//
+// When deserializing, component types from the registry that are listed in the data,
+// but not yet registered in the world, are registered automatically.
+// They are registered in the order in which they appear in the data,
+// so component IDs are deterministic.
+// Resource types from the registry are registered the same way,
+// in the order of their names for JSON, and in the order in which they appear in the data for the binary format.
+//
+// Use a registry with [Options.Registry].
+type Registry struct {
+	names map[reflect.Type]string
+	types map[string]reflect.Type
+}
+
+// NewRegistry creates a new, empty [Registry].
+func NewRegistry() *Registry {
+	return &Registry{
+		names: map[reflect.Type]string{},
+		types: map[string]reflect.Type{},
+	}
+}
+
+// Register a component or resource type under a stable name.
+//
+// The name is used for serialization. When deserializing, the name as well as all aliases are accepted.
+// Aliases can be used to load data where a type was serialized under a different name,
+// e.g. before it was renamed or moved to another package.
+//
+// Panics if the type is already registered, or if the name or any alias is already in use.
+// Returns the registry for method chaining.
+func (r *Registry) Register(tp generic.Comp, name string, aliases ...string) *Registry {
+	if _, ok := r.names[tp]; ok {
+		panic(fmt.Sprintf("type %s is already registered", tp))
+	}
+	r.names[tp] = name
+	for _, n := range append([]string{name}, aliases...) {
+		if other, ok := r.types[n]; ok {
+			panic(fmt.Sprintf("name %s of type %s is already used by type %s", n, tp, other))
+		}
+		r.types[n] = tp
+	}
+	return r
+}
+
+// Name returns the serialization name of a type.
+// Falls back to [reflect.Type.String] for types that are not registered.
+func (r *Registry) Name(tp generic.Comp) string {
+	if r != nil {
+		if name, ok := r.names[tp]; ok {
+			return name
+		}
+	}
+	return tp.String()
+}
+
+// Type returns the type for a name or alias, and whether it was found.
+func (r *Registry) Type(name string) (reflect.Type, bool) {
+	if r == nil {
+		return nil, false
+	}
+	tp, ok := r.types[name]
+	return tp, ok
+}
+
+// lookupNames returns all names a type is known by when deserializing.
+// These are the registered name and aliases for registered types, or [reflect.Type.String] otherwise.
+func (r *Registry) lookupNames(tp reflect.Type) []string {
+	if r == nil {
+		return []string{tp.String()}
+	}
+	if _, ok := r.names[tp]; !ok {
+		return []string{tp.String()}
+	}
+	names := []string{}
+	for name, t := range r.types {
+		if t == tp {
+			names = append(names, name)
+		}
+	}
+	return names
+}
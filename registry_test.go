@@ -0,0 +1,201 @@
+package archeserde_test
+
+import (
+	"bytes"
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/mlange-42/arche/generic"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := archeserde.NewRegistry().
+		Register(generic.T[Position](), "pos", "old.Position").
+		Register(generic.T[Velocity](), "vel")
+
+	assert.Equal(t, "pos", reg.Name(generic.T[Position]()))
+	assert.Equal(t, "archeserde_test.ChildOf", reg.Name(generic.T[ChildOf]()))
+
+	tp, ok := reg.Type("old.Position")
+	assert.True(t, ok)
+	assert.Equal(t, generic.T[Position](), tp)
+	_, ok = reg.Type("archeserde_test.Position")
+	assert.False(t, ok)
+
+	var nilReg *archeserde.Registry
+	assert.Equal(t, "archeserde_test.Position", nilReg.Name(generic.T[Position]()))
+
+	assert.Panics(t, func() { reg.Register(generic.T[Position](), "pos2") })
+	assert.Panics(t, func() { reg.Register(generic.T[ChildOf](), "vel") })
+	assert.Panics(t, func() { reg.Register(generic.T[ChildOf](), "child", "old.Position") })
+}
+
+func TestSerializeRegistry(t *testing.T) {
+	reg := archeserde.NewRegistry().
+		Register(generic.T[Position](), "pos", "archeserde_test.Position").
+		Register(generic.T[Velocity](), "vel")
+
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	e := w.NewEntity(posId)
+	*(*Position)(w.Get(e, posId)) = Position{X: 1, Y: 2}
+	_ = ecs.AddResource(&w, &Velocity{X: 3})
+
+	jsonData, err := archeserde.Serialize(&w, archeserde.Opts.Registry(reg))
+	assert.Nil(t, err)
+	assert.Contains(t, string(jsonData), `"pos" : {"X":1,"Y":2}`)
+	assert.Contains(t, string(jsonData), `"vel" : {"X":3,"Y":0}`)
+	assert.NotContains(t, string(jsonData), "archeserde_test")
+
+	binData, err := archeserde.SerializeBinary(&w, archeserde.Opts.Registry(reg))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(binData, []byte("archeserde_test")))
+
+	newWorld := func() ecs.World {
+		w2 := ecs.NewWorld()
+		_ = ecs.ComponentID[Position](&w2)
+		_ = ecs.AddResource(&w2, &Velocity{})
+		return w2
+	}
+
+	w2 := newWorld()
+	err = archeserde.Deserialize(jsonData, &w2, archeserde.Opts.Registry(reg))
+	assert.Nil(t, err)
+	assert.Equal(t, Position{X: 1, Y: 2}, *(*Position)(w2.Get(e, posId)))
+	assert.Equal(t, Velocity{X: 3}, *ecs.GetResource[Velocity](&w2))
+
+	w2 = newWorld()
+	err = archeserde.DeserializeBinary(binData, &w2, archeserde.Opts.Registry(reg))
+	assert.Nil(t, err)
+	assert.Equal(t, Position{X: 1, Y: 2}, *(*Position)(w2.Get(e, posId)))
+
+	// Data written without registry is loaded via the alias.
+	jsonData, err = archeserde.Serialize(&w, archeserde.Opts.SkipAllResources())
+	assert.Nil(t, err)
+	w2 = newWorld()
+	err = archeserde.Deserialize(jsonData, &w2, archeserde.Opts.Registry(reg))
+	assert.Nil(t, err)
+	assert.Equal(t, Position{X: 1, Y: 2}, *(*Position)(w2.Get(e, posId)))
+
+	// Without registry, registered names are unknown.
+	w2 = newWorld()
+	err = archeserde.DeserializeBinary(binData, &w2)
+	assert.Contains(t, err.Error(), "component type is not registered: pos")
+}
+
+func TestRegistryNameCollision(t *testing.T) {
+	outerPos := generic.T[Position]()
+
+	// Same name as the package-level Position.
+	type Position struct {
+		A int
+	}
+
+	w := ecs.NewWorld()
+	ecs.ComponentID[Position](&w)
+	ecs.TypeID(&w, outerPos)
+
+	_, err := archeserde.Serialize(&w)
+	assert.Contains(t, err.Error(), "multiple types with name archeserde_test.Position")
+
+	reg := archeserde.NewRegistry().Register(generic.T[Position](), "local.Position")
+	jsonData, err := archeserde.Serialize(&w, archeserde.Opts.Registry(reg))
+	assert.Nil(t, err)
+
+	w2 := ecs.NewWorld()
+	ecs.ComponentID[Position](&w2)
+	ecs.TypeID(&w2, outerPos)
+	err = archeserde.Deserialize(jsonData, &w2, archeserde.Opts.Registry(reg))
+	assert.Nil(t, err)
+
+	w2 = ecs.NewWorld()
+	ecs.ComponentID[Position](&w2)
+	ecs.TypeID(&w2, outerPos)
+	err = archeserde.Deserialize([]byte(`{"Version" : 1, "Types" : ["archeserde_test.Position"], "Components" : []}`), &w2)
+	assert.Contains(t, err.Error(), "component type name is ambiguous: archeserde_test.Position")
+}
+
+func TestDeserializeRegistryAutoRegister(t *testing.T) {
+	reg := archeserde.NewRegistry().
+		Register(generic.T[Position](), "pos").
+		Register(generic.T[Velocity](), "vel").
+		Register(generic.T[ChildRelation](), "rel")
+
+	w := ecs.NewWorld()
+	velId := ecs.ComponentID[Velocity](&w)
+	posId := ecs.ComponentID[Position](&w)
+	relId := ecs.ComponentID[ChildRelation](&w)
+
+	parent := w.NewEntity(posId)
+	child := w.NewEntity(velId, relId)
+	*(*Velocity)(w.Get(child, velId)) = Velocity{X: 1, Y: 2}
+	w.Relations().Set(child, relId, parent)
+
+	for _, layout := range []archeserde.Layout{archeserde.EntityLayout, archeserde.ArchetypeLayout} {
+		jsonData, err := archeserde.Serialize(&w, archeserde.Opts.Registry(reg), archeserde.Opts.Layout(layout))
+		assert.Nil(t, err)
+
+		w2 := ecs.NewWorld()
+		err = archeserde.Deserialize(jsonData, &w2, archeserde.Opts.Registry(reg))
+		assert.Nil(t, err)
+
+		assert.Equal(t, velId, ecs.ComponentID[Velocity](&w2))
+		assert.Equal(t, posId, ecs.ComponentID[Position](&w2))
+		assert.Equal(t, relId, ecs.ComponentID[ChildRelation](&w2))
+
+		assert.Equal(t, Velocity{X: 1, Y: 2}, *(*Velocity)(w2.Get(child, velId)))
+		assert.Equal(t, parent, w2.Relations().Get(child, relId))
+	}
+
+	binData, err := archeserde.SerializeBinary(&w, archeserde.Opts.Registry(reg))
+	assert.Nil(t, err)
+
+	w2 := ecs.NewWorld()
+	err = archeserde.DeserializeBinary(binData, &w2, archeserde.Opts.Registry(reg))
+	assert.Nil(t, err)
+	assert.Equal(t, velId, ecs.ComponentID[Velocity](&w2))
+	assert.True(t, w2.Has(parent, posId))
+
+	w2 = ecs.NewWorld()
+	err = archeserde.DeserializeBinary(binData, &w2)
+	assert.Contains(t, err.Error(), "component type is not registered: vel")
+}
+
+func TestDeserializeRegistryResourceOrder(t *testing.T) {
+	reg := archeserde.NewRegistry().
+		Register(generic.T[Position](), "a.pos").
+		Register(generic.T[Velocity](), "b.vel").
+		Register(generic.T[ChildOf](), "c.child").
+		Register(generic.T[Selection](), "d.selection")
+
+	w := ecs.NewWorld()
+	_ = ecs.AddResource(&w, &Selection{})
+	_ = ecs.AddResource(&w, &Velocity{})
+	_ = ecs.AddResource(&w, &ChildOf{})
+	_ = ecs.AddResource(&w, &Position{})
+
+	jsonData, err := archeserde.Serialize(&w, archeserde.Opts.Registry(reg))
+	assert.Nil(t, err)
+
+	// Resource types are registered in the order of their names.
+	expected := ecs.NewWorld()
+	ids := []ecs.ResID{
+		ecs.ResourceID[Position](&expected),
+		ecs.ResourceID[Velocity](&expected),
+		ecs.ResourceID[ChildOf](&expected),
+		ecs.ResourceID[Selection](&expected),
+	}
+
+	for i := 0; i < 20; i++ {
+		w2 := ecs.NewWorld()
+		assert.Nil(t, archeserde.Deserialize(jsonData, &w2, archeserde.Opts.Registry(reg)))
+		assert.Equal(t, ids, []ecs.ResID{
+			ecs.ResourceID[Position](&w2),
+			ecs.ResourceID[Velocity](&w2),
+			ecs.ResourceID[ChildOf](&w2),
+			ecs.ResourceID[Selection](&w2),
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package archeserde
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema generates a JSON Schema for the JSON documents written by [Serialize] for the given world.
+//
+// The schema covers the entity dump in section "World", the "Types" section,
+// the component objects in sections "Components" and "Archetypes", and the "Resources" section.
+// Schemas for components and resources are derived from the Go types of all registered
+// component and resource types, following the rules of [encoding/json] for struct fields and tags.
+// Values of types with a custom [Codec], or with custom JSON marshalling, are not restricted.
+//
+// Options [Options.Registry] and [Options.Codec] are considered,
+// as well as the options for selecting component and resource types, like [Options.SkipComponents] and [Options.OnlyComponents].
+//
+// See [RegistrySchema] for generating a schema from a [Registry] instead of a world.
+func Schema(world *ecs.World, options ...Option) ([]byte, error) {
+	opts := newSerdeOptions(options...)
+
+	components := []schemaType{}
+	for _, id := range ecs.ComponentIDs(world) {
+		if info, ok := ecs.ComponentInfo(world, id); ok && !opts.skipComponent(info.Type) {
+			components = append(components, schemaType{Name: opts.registry.Name(info.Type), Type: info.Type})
+		}
+	}
+	resources := []schemaType{}
+	for _, id := range ecs.ResourceIDs(world) {
+		if tp, ok := ecs.ResourceType(world, id); ok && !opts.skipResource(tp) {
+			resources = append(resources, schemaType{Name: opts.registry.Name(tp), Type: tp})
+		}
+	}
+	return buildSchema(components, resources, &opts)
+}
+
+// RegistrySchema generates a JSON Schema for the JSON documents written by [Serialize],
+// for all types in the given [Registry].
+//
+// As the registry does not distinguish between component and resource types,
+// all its types are allowed as components as well as resources.
+//
+// See [Schema] for details.
+func RegistrySchema(registry *Registry, options ...Option) ([]byte, error) {
+	opts := newSerdeOptions(options...)
+	opts.registry = registry
+
+	types := []schemaType{}
+	for tp, name := range registry.names {
+		types = append(types, schemaType{Name: name, Type: tp})
+	}
+	return buildSchema(types, types, &opts)
+}
+
+// schemaType is a named component or resource type for schema generation.
+type schemaType struct {
+	Name string
+	Type reflect.Type
+}
+
+// schema is a JSON Schema.
+type schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 any                `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	ContentEncoding      string             `json:"contentEncoding,omitempty"`
+	Const                any                `json:"const,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	PrefixItems          []*schema          `json:"prefixItems,omitempty"`
+	Items                any                `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	PropertyNames        *schema            `json:"propertyNames,omitempty"`
+	AnyOf                []*schema          `json:"anyOf,omitempty"`
+	Defs                 map[string]*schema `json:"$defs,omitempty"`
+}
+
+// schemaBuilder derives schemas from Go types.
+// Named struct types are collected in the definitions, and referenced.
+type schemaBuilder struct {
+	defs   map[string]*schema
+	names  map[reflect.Type]string
+	codecs codecs
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// buildSchema builds the schema of the whole document.
+func buildSchema(components, resources []schemaType, opts *serdeOptions) ([]byte, error) {
+	if err := checkNames(components, func(t schemaType) string { return t.Name }); err != nil {
+		return nil, err
+	}
+	if err := checkNames(resources, func(t schemaType) string { return t.Name }); err != nil {
+		return nil, err
+	}
+
+	b := schemaBuilder{
+		defs:   map[string]*schema{},
+		names:  map[reflect.Type]string{},
+		codecs: opts.codecs,
+	}
+	b.defs["Entity"] = &schema{
+		Description: "An entity as ID and generation",
+		Type:        "array",
+		PrefixItems: []*schema{b.typeSchema(reflect.TypeOf(uint32(0))), b.typeSchema(reflect.TypeOf(uint32(0)))},
+		Items:       false,
+		MinItems:    intPtr(2),
+	}
+	entity := &schema{Ref: "#/$defs/Entity"}
+	b.names[entityType] = "Entity"
+
+	names := make([]string, 0, len(components))
+	compProps := map[string]*schema{targetTag: entity}
+	for _, tp := range components {
+		names = append(names, tp.Name)
+		compProps[tp.Name] = b.typeSchema(tp.Type)
+	}
+	slices.Sort(names)
+	resProps := map[string]*schema{}
+	for _, tp := range resources {
+		resProps[tp.Name] = b.typeSchema(tp.Type)
+	}
+
+	typeName := &schema{Type: "string", Enum: names}
+	uintSchema := b.typeSchema(reflect.TypeOf(uint32(0)))
+
+	root := schema{
+		Schema: schemaDialect,
+		Title:  "Arche world",
+		Type:   "object",
+		Properties: map[string]*schema{
+			"Version": {Description: "Format version", Type: "integer", Minimum: floatPtr(1), Maximum: floatPtr(jsonVersion)},
+			"World": {
+				Description: "Entity dump",
+				Type:        "object",
+				Properties: map[string]*schema{
+					"Entities":  {Type: "array", Items: entity},
+					"Alive":     {Type: "array", Items: uintSchema},
+					"Next":      uintSchema,
+					"Available": uintSchema,
+				},
+				AdditionalProperties: false,
+			},
+			"Types": {
+				Description: "Component types",
+				Type:        "array",
+				Items:       typeName,
+			},
+			"Components": {
+				Description: "Components of alive entities, keyed by type name",
+				Type:        "array",
+				Items:       &schema{Ref: "#/$defs/Components"},
+			},
+			"Archetypes": {
+				Description: "Components of alive entities, grouped by archetype",
+				Type:        "array",
+				Items: &schema{
+					Type: "object",
+					Properties: map[string]*schema{
+						"Types":      {Type: "array", Items: typeName},
+						"Target":     entity,
+						"Entities":   {Type: "array", Items: entity},
+						"Components": {Type: "array", Items: &schema{Type: "array"}},
+					},
+					AdditionalProperties: false,
+				},
+			},
+			"Free": {
+				Description: "Free entities, with the generation their IDs get when recycled",
+				Type:        "array",
+				Items:       entity,
+			},
+			"Entities": {
+				Description: "Alive entities, with their relation target and components",
+				Type:        "array",
+				Items: &schema{
+					Type: "object",
+					Properties: map[string]*schema{
+						"Entity":     entity,
+						"Target":     entity,
+						"Components": {Ref: "#/$defs/Components"},
+					},
+					AdditionalProperties: false,
+				},
+			},
+			SectionIndex: {
+				Description: "Table of contents, with the byte ranges of sections and archetypes",
+				Type:        "object",
+			},
+			"Resources": {
+				Description:          "Resources, keyed by type name",
+				Type:                 "object",
+				Properties:           resProps,
+				AdditionalProperties: false,
+			},
+		},
+		AdditionalProperties: false,
+		Defs:                 b.defs,
+	}
+	b.defs["Components"] = &schema{
+		Type:                 "object",
+		Properties:           compProps,
+		AdditionalProperties: false,
+	}
+
+	return json.MarshalIndent(&root, "", "  ")
+}
+
+// typeSchema returns the schema for a Go type, following the rules of [encoding/json].
+func (b *schemaBuilder) typeSchema(tp reflect.Type) *schema {
+	if name, ok := b.names[tp]; ok {
+		return &schema{Ref: "#/$defs/" + name}
+	}
+	if _, ok := b.codecs[tp]; ok {
+		return &schema{Description: "Custom codec"}
+	}
+	if tp == timeType {
+		return &schema{Type: "string", Format: "date-time"}
+	}
+	if tp.Implements(jsonMarshalerType) || reflect.PointerTo(tp).Implements(jsonMarshalerType) {
+		return &schema{}
+	}
+	if tp.Implements(textMarshalerType) || reflect.PointerTo(tp).Implements(textMarshalerType) {
+		return &schema{Type: "string"}
+	}
+
+	switch tp.Kind() {
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		bits := tp.Bits()
+		return &schema{Type: "integer", Minimum: floatPtr(-math.Pow(2, float64(bits-1))), Maximum: floatPtr(math.Pow(2, float64(bits-1)) - 1)}
+	case reflect.Int, reflect.Int64:
+		return &schema{Type: "integer"}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &schema{Type: "integer", Minimum: floatPtr(0), Maximum: floatPtr(math.Pow(2, float64(tp.Bits())) - 1)}
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return &schema{Type: "integer", Minimum: floatPtr(0)}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Pointer:
+		return &schema{AnyOf: []*schema{b.typeSchema(tp.Elem()), {Type: "null"}}}
+	case reflect.Slice:
+		if tp.Elem().Kind() == reflect.Uint8 && !reflect.PointerTo(tp.Elem()).Implements(textMarshalerType) {
+			return &schema{Type: []string{"string", "null"}, ContentEncoding: "base64"}
+		}
+		return &schema{Type: []string{"array", "null"}, Items: b.typeSchema(tp.Elem())}
+	case reflect.Array:
+		return &schema{Type: "array", Items: b.typeSchema(tp.Elem()), MinItems: intPtr(tp.Len()), MaxItems: intPtr(tp.Len())}
+	case reflect.Map:
+		s := &schema{Type: []string{"object", "null"}, AdditionalProperties: b.typeSchema(tp.Elem())}
+		if tp.Key() == entityType {
+			s.PropertyNames = &schema{Description: "An entity as \"id,gen\"", Pattern: "^[0-9]+,[0-9]+$"}
+		}
+		return s
+	case reflect.Struct:
+		return b.structSchema(tp)
+	default:
+		return &schema{}
+	}
+}
+
+// structSchema returns the schema for a struct type.
+// Named types are added to the definitions, and referenced.
+func (b *schemaBuilder) structSchema(tp reflect.Type) *schema {
+	s := &schema{Type: "object", Properties: map[string]*schema{}, AdditionalProperties: false}
+	if tp.Name() == "" {
+		b.addFields(s, tp)
+		return s
+	}
+
+	name := tp.String()
+	for i := 2; b.defs[name] != nil; i++ {
+		name = fmt.Sprintf("%s_%d", tp.String(), i)
+	}
+	b.names[tp] = name
+	b.defs[name] = s
+	b.addFields(s, tp)
+	return &schema{Ref: "#/$defs/" + name}
+}
+
+// addFields adds the fields of a struct type to the properties of a schema.
+// Fields of embedded structs are promoted, unless shadowed by fields of the outer struct.
+func (b *schemaBuilder) addFields(s *schema, tp reflect.Type) {
+	embedded := []reflect.Type{}
+	for i := 0; i < tp.NumField(); i++ {
+		field := tp.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, tagOpts, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fieldSchema := b.typeSchema(field.Type)
+		if slices.Contains(strings.Split(tagOpts, ","), "string") {
+			switch field.Type.Kind() {
+			case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Float32, reflect.Float64, reflect.String:
+				fieldSchema = &schema{Type: "string"}
+			}
+		}
+		s.Properties[name] = fieldSchema
+	}
+
+	for _, ft := range embedded {
+		inner := &schema{Properties: map[string]*schema{}}
+		b.addFields(inner, ft)
+		for name, fieldSchema := range inner.Properties {
+			if _, ok := s.Properties[name]; !ok {
+				s.Properties[name] = fieldSchema
+			}
+		}
+	}
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,110 @@
+package archeserde_test
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/mlange-42/arche/generic"
+	"github.com/stretchr/testify/assert"
+)
+
+type Tagged struct {
+	embedded
+	Renamed int     `json:"renamed"`
+	Omitted float64 `json:"omitted,omitempty"`
+	Quoted  int     `json:",string"`
+	Skipped int     `json:"-"`
+	Inner   string
+	private int
+}
+
+func parseSchema(t *testing.T, jsonData []byte) map[string]any {
+	s := map[string]any{}
+	assert.Nil(t, json.Unmarshal(jsonData, &s))
+	return s
+}
+
+func lookup(s map[string]any, path ...string) any {
+	var v any = s
+	for _, p := range path {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[p]
+	}
+	return v
+}
+
+func TestSchema(t *testing.T) {
+	w := ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&w)
+	_ = ecs.ComponentID[Tagged](&w)
+	_ = ecs.ComponentID[Bits](&w)
+	_ = ecs.ComponentID[ChildRelation](&w)
+	_ = ecs.ResourceID[Velocity](&w)
+
+	reg := archeserde.NewRegistry().Register(generic.T[Position](), "pos")
+	jsonData, err := archeserde.Schema(&w,
+		archeserde.Opts.Registry(reg),
+		archeserde.Opts.Codec(generic.T[Bits](), bitsCodec),
+		archeserde.Opts.SkipComponents(generic.T[ChildRelation]()),
+	)
+	assert.Nil(t, err)
+	s := parseSchema(t, jsonData)
+
+	assert.Equal(t, "https://json-schema.org/draft/2020-12/schema", s["$schema"])
+	for _, section := range []string{"Version", "World", "Types", "Components", "Archetypes", "Resources"} {
+		assert.NotNil(t, lookup(s, "properties", section), section)
+	}
+	assert.Equal(t, []any{"archeserde_test.Bits", "archeserde_test.Tagged", "pos"}, lookup(s, "properties", "Types", "items", "enum"))
+
+	comps := lookup(s, "$defs", "Components", "properties").(map[string]any)
+	assert.Equal(t, "#/$defs/Entity", lookup(comps, "arche.relation.Target", "$ref"))
+	assert.Equal(t, "#/$defs/archeserde_test.Position", lookup(comps, "pos", "$ref"))
+	assert.Equal(t, "Custom codec", lookup(comps, "archeserde_test.Bits", "description"))
+	assert.Nil(t, comps["archeserde_test.ChildRelation"])
+
+	tagged := lookup(s, "$defs", "archeserde_test.Tagged", "properties").(map[string]any)
+	assert.Equal(t, []string{"Inner", "Quoted", "omitted", "renamed"}, keys(tagged))
+	assert.Equal(t, "string", lookup(tagged, "Quoted", "type"))
+	assert.Equal(t, "string", lookup(tagged, "Inner", "type"))
+	assert.Equal(t, false, lookup(s, "$defs", "archeserde_test.Tagged", "additionalProperties"))
+
+	assert.Equal(t, "#/$defs/archeserde_test.Velocity", lookup(s, "properties", "Resources", "properties", "archeserde_test.Velocity", "$ref"))
+
+	jsonData2, err := archeserde.Schema(&w,
+		archeserde.Opts.Registry(reg),
+		archeserde.Opts.Codec(generic.T[Bits](), bitsCodec),
+		archeserde.Opts.SkipComponents(generic.T[ChildRelation]()),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, string(jsonData), string(jsonData2))
+}
+
+func TestRegistrySchema(t *testing.T) {
+	reg := archeserde.NewRegistry().
+		Register(generic.T[Position](), "pos").
+		Register(generic.T[Node](), "node")
+
+	jsonData, err := archeserde.RegistrySchema(reg)
+	assert.Nil(t, err)
+	s := parseSchema(t, jsonData)
+
+	assert.Equal(t, []any{"node", "pos"}, lookup(s, "properties", "Types", "items", "enum"))
+	assert.Equal(t, "#/$defs/archeserde_test.Node", lookup(s, "properties", "Resources", "properties", "node", "$ref"))
+	assert.Equal(t, "#/$defs/archeserde_test.Node",
+		lookup(s, "$defs", "archeserde_test.Node", "properties", "Next", "anyOf").([]any)[0].(map[string]any)["$ref"])
+}
+
+func keys(m map[string]any) []string {
+	result := []string{}
+	for k := range m {
+		result = append(result, k)
+	}
+	slices.Sort(result)
+	return result
+}
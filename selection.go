@@ -0,0 +1,97 @@
+package archeserde
+
+import (
+	"reflect"
+
+	"github.com/mlange-42/arche/generic"
+)
+
+// typeSelection selects component or resource types by include and exclude rules.
+//
+// A type is selected if the include rules are empty or any of them matches,
+// and none of the exclude rules matches.
+type typeSelection struct {
+	include []typeRule
+	exclude []typeRule
+}
+
+// typeRule matches a type, either exactly or by a name pattern.
+type typeRule struct {
+	tp      reflect.Type // The type to match, or nil for a pattern.
+	pattern string       // The name pattern to match. See [matchName].
+}
+
+// typeRules creates rules for matching the given types exactly.
+func typeRules(types []generic.Comp) []typeRule {
+	rules := make([]typeRule, len(types))
+	for i, tp := range types {
+		rules[i] = typeRule{tp: reflect.Type(tp)}
+	}
+	return rules
+}
+
+// patternRules creates rules for matching types by the given name patterns.
+func patternRules(patterns []string) []typeRule {
+	rules := make([]typeRule, len(patterns))
+	for i, p := range patterns {
+		rules[i] = typeRule{pattern: p}
+	}
+	return rules
+}
+
+// selects checks whether a type is selected.
+// Type names for patterns are determined by the registry.
+func (s *typeSelection) selects(tp reflect.Type, registry *Registry) bool {
+	if len(s.include) == 0 && len(s.exclude) == 0 {
+		return true
+	}
+	name := registry.Name(tp)
+	if len(s.include) > 0 && !anyRule(s.include, tp, name) {
+		return false
+	}
+	return !anyRule(s.exclude, tp, name)
+}
+
+// anyRule checks whether any of the rules matches a type with the given name.
+func anyRule(rules []typeRule, tp reflect.Type, name string) bool {
+	for _, rule := range rules {
+		if rule.tp != nil {
+			if rule.tp == tp {
+				return true
+			}
+		} else if matchName(rule.pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchName checks whether a type name matches a pattern.
+//
+// In patterns, '*' matches any sequence of characters, including none, and '?' matches any single character.
+// All other characters match themselves.
+func matchName(pattern, name string) bool {
+	p, n := []rune(pattern), []rune(name)
+	// Position after the last '*' in the pattern, and the name position it was tried at.
+	star, retry := -1, 0
+	i, j := 0, 0
+	for j < len(n) {
+		switch {
+		case i < len(p) && p[i] == '*':
+			star, retry = i+1, j
+			i++
+		case i < len(p) && (p[i] == '?' || p[i] == n[j]):
+			i++
+			j++
+		case star >= 0:
+			retry++
+			i, j = star, retry
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
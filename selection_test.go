@@ -0,0 +1,142 @@
+package archeserde_test
+
+import (
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/mlange-42/arche/generic"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSerializeTypeSelection(t *testing.T) {
+	tests := []struct {
+		options    []archeserde.Option
+		components []string
+		resources  []string
+	}{
+		{
+			options:    []archeserde.Option{archeserde.Opts.OnlyComponents(generic.T[Position]())},
+			components: []string{"archeserde_test.Position"},
+			resources:  []string{"archeserde_test.Position", "archeserde_test.Velocity"},
+		},
+		{
+			options: []archeserde.Option{
+				archeserde.Opts.OnlyComponents(generic.T[Position]()),
+				archeserde.Opts.OnlyComponents(generic.T[ChildOf]()),
+			},
+			components: []string{"archeserde_test.ChildOf", "archeserde_test.Position"},
+			resources:  []string{"archeserde_test.Position", "archeserde_test.Velocity"},
+		},
+		{
+			options: []archeserde.Option{
+				archeserde.Opts.SkipComponents(generic.T[Position]()),
+				archeserde.Opts.SkipComponents(generic.T[Velocity]()),
+			},
+			components: []string{"archeserde_test.ChildOf"},
+			resources:  []string{"archeserde_test.Position", "archeserde_test.Velocity"},
+		},
+		{
+			options:    []archeserde.Option{archeserde.Opts.OnlyComponentsMatching("archeserde_test.*")},
+			components: []string{"archeserde_test.ChildOf", "archeserde_test.Position", "archeserde_test.Velocity"},
+			resources:  []string{"archeserde_test.Position", "archeserde_test.Velocity"},
+		},
+		{
+			options:    []archeserde.Option{archeserde.Opts.OnlyComponentsMatching("other.*")},
+			components: []string{},
+			resources:  []string{"archeserde_test.Position", "archeserde_test.Velocity"},
+		},
+		{
+			options:    []archeserde.Option{archeserde.Opts.SkipComponentsMatching("*.?osition", "*Of")},
+			components: []string{"archeserde_test.Velocity"},
+			resources:  []string{"archeserde_test.Position", "archeserde_test.Velocity"},
+		},
+		{
+			options: []archeserde.Option{
+				archeserde.Opts.OnlyComponentsMatching("archeserde_test.*"),
+				archeserde.Opts.SkipComponents(generic.T[Velocity]()),
+				archeserde.Opts.OnlyResources(generic.T[Velocity]()),
+			},
+			components: []string{"archeserde_test.ChildOf", "archeserde_test.Position"},
+			resources:  []string{"archeserde_test.Velocity"},
+		},
+		{
+			options: []archeserde.Option{
+				archeserde.Opts.OnlyResourcesMatching("*"),
+				archeserde.Opts.SkipResourcesMatching("*.Velocity"),
+			},
+			components: []string{"archeserde_test.ChildOf", "archeserde_test.Position", "archeserde_test.Velocity"},
+			resources:  []string{"archeserde_test.Position"},
+		},
+		{
+			options: []archeserde.Option{
+				archeserde.Opts.OnlyComponents(generic.T[Position]()),
+				archeserde.Opts.SkipAllComponents(),
+			},
+			components: []string{},
+			resources:  []string{"archeserde_test.Position", "archeserde_test.Velocity"},
+		},
+		{
+			options: []archeserde.Option{
+				archeserde.Opts.Registry(archeserde.NewRegistry().Register(generic.T[Position](), "game.pos")),
+				archeserde.Opts.OnlyComponentsMatching("game.*"),
+				archeserde.Opts.OnlyResourcesMatching("game.*"),
+			},
+			components: []string{"game.pos"},
+			resources:  []string{"game.pos"},
+		},
+	}
+
+	for _, test := range tests {
+		jsonData, _, _, err := serialize(test.options...)
+		assert.Nil(t, err)
+		file, err := archeserde.ReadFile(jsonData)
+		assert.Nil(t, err)
+
+		components := map[string]bool{}
+		for _, comps := range file.Components {
+			for name := range comps {
+				components[name] = true
+			}
+		}
+		for _, name := range test.components {
+			assert.True(t, components[name], "missing component %s", name)
+		}
+		assert.Equal(t, len(test.components), len(components))
+		assert.Equal(t, 3, len(file.Entities()))
+
+		resources := []string{}
+		for name := range file.Resources {
+			resources = append(resources, name)
+		}
+		assert.ElementsMatch(t, test.resources, resources)
+	}
+}
+
+func TestDeserializeTypeSelection(t *testing.T) {
+	jsonData, parent, child, err := serialize()
+	assert.Nil(t, err)
+
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	velId := ecs.ComponentID[Velocity](&w)
+	childId := ecs.ComponentID[ChildOf](&w)
+	_ = ecs.AddResource(&w, &Position{})
+	_ = ecs.AddResource(&w, &Velocity{})
+
+	options := []archeserde.Option{
+		archeserde.Opts.OnlyComponentsMatching("*.Position", "*.Velocity"),
+		archeserde.Opts.SkipComponents(generic.T[Velocity]()),
+		archeserde.Opts.OnlyResources(generic.T[Velocity]()),
+	}
+	assert.Nil(t, archeserde.Validate(jsonData, &w, options...))
+	assert.Nil(t, archeserde.Deserialize(jsonData, &w, options...))
+
+	assert.True(t, w.Alive(parent))
+	assert.True(t, w.Alive(child))
+	assert.Equal(t, Position{X: 3, Y: 4}, *(*Position)(w.Get(child, posId)))
+	assert.False(t, w.Has(child, velId))
+	assert.False(t, w.Has(child, childId))
+	assert.Equal(t, Velocity{X: 1000}, *ecs.GetResource[Velocity](&w))
+	assert.Equal(t, Position{}, *ecs.GetResource[Position](&w))
+}
@@ -1,8 +1,11 @@
 package archeserde
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"reflect"
 	"slices"
 	"strings"
@@ -15,6 +18,7 @@ const targetTag = "arche.relation.Target"
 // Serialize an Arche [ecs.World] to JSON.
 //
 // Serializes the following:
+//   - The format version
 //   - Entities and the entity pool
 //   - All components of all entities
 //   - All resources
@@ -23,199 +27,365 @@ const targetTag = "arche.relation.Target"
 //
 // The options can be used to skip some or all components,
 // entities entirely, and/or some or all resources.
+// Further, they can be used to select the [Layout] of the components section.
+//
+// See [SerializeTo] for writing directly to an [io.Writer].
 func Serialize(world *ecs.World, options ...Option) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	if err := SerializeTo(world, &buffer, options...); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// SerializeTo serializes an Arche [ecs.World] to JSON, and writes it to the given [io.Writer].
+//
+// In contrast to [Serialize], the document is not built in memory,
+// but streamed to the writer section by section, and entity by entity.
+// Output is buffered internally, so there is no need to wrap the writer in a [bufio.Writer].
+//
+// See [Serialize] for details and options.
+func SerializeTo(world *ecs.World, w io.Writer, options ...Option) error {
 	opts := newSerdeOptions(options...)
 
-	builder := strings.Builder{}
+	compressor := compressWriter(w, opts.compression)
+	counter := countingWriter{writer: compressor}
+	writer := bufio.NewWriter(&counter)
 
-	builder.WriteString("{\n")
+	var toc *tableOfContents
+	if opts.index {
+		toc = newTableOfContents(func() int { return counter.count + writer.Buffered() })
+	}
 
-	if err := serializeWorld(world, &builder, &opts); err != nil {
-		return nil, err
+	dump := entityDump(world, &opts)
+	saved, local := savedEntities(&dump, &opts)
+	infos, err := componentInfos(world, &opts)
+	if err != nil {
+		return err
 	}
-	if !opts.skipEntities {
-		builder.WriteString(",\n")
+
+	writer.WriteString("{\n")
+	fmt.Fprintf(writer, "\"Version\" : %d,\n", documentVersion(opts.layout, opts.index))
+
+	start := toc.offset()
+	if opts.layout != RecordLayout {
+		if err := serializeWorld(&saved, writer, &opts); err != nil {
+			return err
+		}
+		toc.section(SectionWorld, start)
+		if !opts.skipEntities {
+			writer.WriteString(",\n")
+		}
 	}
 
-	serializeTypes(world, &builder, &opts)
-	builder.WriteString(",\n")
+	start = toc.offset()
+	serializeTypes(infos, writer)
+	toc.section(SectionTypes, start)
+	writer.WriteString(",\n")
 
-	if err := serializeComponents(world, &builder, &opts); err != nil {
-		return nil, err
+	start = toc.offset()
+	if opts.layout == ArchetypeLayout {
+		if err := serializeArchetypes(world, &dump, infos, writer, &opts, toc, local); err != nil {
+			return err
+		}
+		toc.section(SectionArchetypes, start)
+	} else if opts.layout == RecordLayout {
+		if err := serializeRecords(world, &dump, &saved, infos, writer, &opts, local, toc); err != nil {
+			return err
+		}
+	} else {
+		if err := serializeComponents(world, &dump, infos, writer, &opts, local); err != nil {
+			return err
+		}
+		toc.section(SectionComponents, start)
 	}
-	builder.WriteString(",\n")
+	writer.WriteString(",\n")
 
-	if err := serializeResources(world, &builder, &opts); err != nil {
-		return nil, err
+	start = toc.offset()
+	if err := serializeResources(world, writer, &opts, local); err != nil {
+		return err
+	}
+	toc.section(SectionResources, start)
+
+	if toc != nil {
+		if err := toc.write(writer); err != nil {
+			return err
+		}
 	}
-	builder.WriteString("}\n")
+	writer.WriteString("}\n")
 
-	return []byte(builder.String()), nil
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return compressor.Close()
 }
 
-func serializeWorld(world *ecs.World, builder *strings.Builder, opts *serdeOptions) error {
+func serializeWorld(dump *ecs.EntityDump, writer *bufio.Writer, opts *serdeOptions) error {
 	if opts.skipEntities {
 		return nil
 	}
 
-	entities := world.DumpEntities()
-
-	jsonData, err := json.Marshal(entities)
+	jsonData, err := json.Marshal(dump)
 	if err != nil {
-		return err
+		return sectionError(SectionWorld, err)
 	}
-	builder.WriteString(fmt.Sprintf("\"World\" : %s", string(jsonData)))
+	fmt.Fprintf(writer, "\"World\" : %s", jsonData)
 	return nil
 }
 
-func serializeTypes(world *ecs.World, builder *strings.Builder, opts *serdeOptions) {
-	if opts.skipEntities || opts.skipAllComponents {
-		builder.WriteString("\"Types\" : []")
+func serializeTypes(infos []compType, writer *bufio.Writer) {
+	if len(infos) == 0 {
+		writer.WriteString("\"Types\" : []")
 		return
 	}
 
-	builder.WriteString("\"Types\" : [\n")
-
-	types := map[ecs.ID]reflect.Type{}
+	writer.WriteString("\"Types\" : [\n")
 
-	allComps := ecs.ComponentIDs(world)
-	for _, id := range allComps {
-		if info, ok := ecs.ComponentInfo(world, id); ok {
-			if !slices.Contains(opts.skipComponents, info.Type) {
-				types[id] = info.Type
-			}
-		}
-	}
-	maxComp := len(types) - 1
-	counter := 0
-	for _, tp := range types {
-		builder.WriteString(fmt.Sprintf("  \"%s\"", tp.String()))
-		if counter < maxComp {
-			builder.WriteString(",")
+	maxComp := len(infos) - 1
+	for i, info := range infos {
+		fmt.Fprintf(writer, "  %s", info.quoted)
+		if i < maxComp {
+			writer.WriteString(",")
 		}
-		builder.WriteString("\n")
-		counter++
+		writer.WriteString("\n")
 	}
 
-	builder.WriteString("]")
+	writer.WriteString("]")
 }
 
-func serializeComponents(world *ecs.World, builder *strings.Builder, opts *serdeOptions) error {
+// serializeComponents writes the components of all alive entities in the dump.
+//
+// If local is not nil, relation targets and entities in components are mapped to local entities.
+// This is used for prefabs, and for compacted entities.
+func serializeComponents(world *ecs.World, dump *ecs.EntityDump, infos []compType, writer *bufio.Writer, opts *serdeOptions, local entityRemap) error {
 	if opts.skipEntities {
-		builder.WriteString("\"Components\" : []")
+		writer.WriteString("\"Components\" : []")
 		return nil
 	}
 
-	skipComponents := ecs.Mask{}
-	for _, tp := range opts.skipComponents {
-		id := ecs.TypeID(world, tp)
-		skipComponents.Set(id, true)
+	writer.WriteString("\"Components\" : [\n")
+
+	err := encodeChunks(writer, len(dump.Alive), opts.workers, func(w stringWriter, start, end int) error {
+		return writeEntities(world, dump, infos, w, opts, local, start, end)
+	})
+	if err != nil {
+		return err
 	}
+	writer.WriteString("]")
 
-	builder.WriteString("\"Components\" : [\n")
+	return nil
+}
+
+// writeEntities writes the components of the alive entities in the given index range of the dump.
+func writeEntities(world *ecs.World, dump *ecs.EntityDump, infos []compType, writer stringWriter, opts *serdeOptions, local entityRemap, start, end int) error {
+	lastEntity := len(dump.Alive) - 1
+	tempInfos := []compType{}
+	for counter := start; counter < end; counter++ {
+		entity := dump.Entities[dump.Alive[counter]]
 
-	query := world.Query(ecs.All())
-	lastEntity := query.Count() - 1
-	counter := 0
-	tempIDs := []ecs.ID{}
-	for query.Next() {
 		if opts.skipAllComponents {
-			builder.WriteString("  {")
+			writer.WriteString("  {")
 		} else {
-			builder.WriteString("  {\n")
-
-			ids := query.Ids()
+			writer.WriteString("  {\n")
 
-			tempIDs = tempIDs[:0]
-			for _, id := range ids {
-				if !skipComponents.Get(id) {
-					tempIDs = append(tempIDs, id)
+			mask := world.Mask(entity)
+			tempInfos = tempInfos[:0]
+			for _, info := range infos {
+				if mask.Get(info.ID) {
+					tempInfos = append(tempInfos, info)
 				}
 			}
-			last := len(tempIDs) - 1
-
-			for i, id := range tempIDs {
-				info, _ := ecs.ComponentInfo(world, id)
+			last := len(tempInfos) - 1
 
+			for i, info := range tempInfos {
 				if info.IsRelation {
-					target := query.Relation(id)
+					target := relationTarget(world, dump, entity, info.ID, local)
 					eJSON, err := target.MarshalJSON()
 					if err != nil {
 						return err
 					}
-					builder.WriteString(fmt.Sprintf("    \"%s\" : %s,\n", targetTag, eJSON))
+					fmt.Fprintf(writer, "    \"%s\" : %s,\n", targetTag, eJSON)
 				}
 
-				comp := query.Get(id)
-				value := reflect.NewAt(info.Type, comp).Interface()
-				jsonData, err := json.Marshal(value)
+				comp := local.localCopy(reflect.NewAt(info.Type, world.GetUnchecked(entity, info.ID)))
+				jsonData, err := opts.codecs.marshalJSON(comp)
 				if err != nil {
-					return err
+					return newError(SectionComponents, counter, entity, info.Name, err)
 				}
-				builder.WriteString(fmt.Sprintf("    \"%s\" : ", info.Type.String()))
-				builder.WriteString(string(jsonData))
+				fmt.Fprintf(writer, "    %s : ", info.quoted)
+				writer.Write(jsonData)
 				if i < last {
-					builder.WriteString(",")
+					writer.WriteString(",")
 				}
-				builder.WriteString("\n")
+				writer.WriteString("\n")
 			}
 		}
-		builder.WriteString("  }")
+		writer.WriteString("  }")
 		if counter < lastEntity {
-			builder.WriteString(",")
+			writer.WriteString(",")
+		}
+		if _, err := writer.WriteString("\n"); err != nil {
+			return err
 		}
-		builder.WriteString("\n")
-
-		counter++
 	}
-	builder.WriteString("]")
-
 	return nil
 }
 
-func serializeResources(world *ecs.World, builder *strings.Builder, opts *serdeOptions) error {
+// serializeResources writes all resources.
+// If local is not nil, entities in resources are mapped to local entities.
+func serializeResources(world *ecs.World, writer *bufio.Writer, opts *serdeOptions, local entityRemap) error {
 	if opts.skipAllResources {
-		builder.WriteString("\"Resources\" : {}")
+		writer.WriteString("\"Resources\" : {}")
 		return nil
 	}
 
-	builder.WriteString("\"Resources\" : {\n")
+	writer.WriteString("\"Resources\" : {\n")
 
-	resTypes := map[ecs.ResID]reflect.Type{}
-	allRes := ecs.ResourceIDs(world)
-	for _, id := range allRes {
-		if tp, ok := ecs.ResourceType(world, id); ok {
-			if !slices.Contains(opts.skipResources, tp) {
-				resTypes[id] = tp
-			}
-		}
+	resources, err := resourceInfos(world, opts)
+	if err != nil {
+		return err
 	}
 
-	last := len(resTypes) - 1
-	counter := 0
-	for id, tp := range resTypes {
-		res := world.Resources().Get(id)
+	last := len(resources) - 1
+	for i, info := range resources {
+		res := world.Resources().Get(info.ID)
 		rValue := reflect.ValueOf(res)
 		ptr := rValue.UnsafePointer()
 
-		value := reflect.NewAt(tp, ptr).Interface()
-		jsonData, err := json.Marshal(value)
+		jsonData, err := opts.codecs.marshalJSON(local.localCopy(reflect.NewAt(info.Type, ptr)))
 		if err != nil {
-			return err
+			return newError(SectionResources, -1, ecs.Entity{}, info.Name, err)
 		}
 
-		builder.WriteString("    ")
-		builder.WriteString(fmt.Sprintf("\"%s\" : ", tp.String()))
-		builder.WriteString(string(jsonData))
+		writer.WriteString("    ")
+		fmt.Fprintf(writer, "%s : ", quote(info.Name))
+		writer.Write(jsonData)
+
+		if i < last {
+			writer.WriteString(",")
+		}
+		writer.WriteString("\n")
+	}
+
+	writer.WriteString("}")
+
+	return nil
+}
+
+// entityDump returns the world's entity dump.
+// Only entities selected by the options are contained as alive entities.
+// In canonical mode, alive entities are sorted by ID.
+func entityDump(world *ecs.World, opts *serdeOptions) ecs.EntityDump {
+	if opts.skipEntities {
+		return ecs.EntityDump{}
+	}
+	dump := world.DumpEntities()
+	selectEntities(world, &dump, opts)
+	if opts.canonical {
+		slices.Sort(dump.Alive)
+	}
+	return dump
+}
+
+// savedEntities returns the entity dump to write for the given dump of selected entities,
+// and the mapping from world entities to the written entities.
+//
+// With [Options.CompactEntities], the written dump contains only the alive entities, renumbered densely.
+// Otherwise, it is the given dump, and the mapping is nil.
+func savedEntities(dump *ecs.EntityDump, opts *serdeOptions) (ecs.EntityDump, entityRemap) {
+	if !opts.compactEntities || opts.skipEntities {
+		return *dump, nil
+	}
+	return compactEntities(dump)
+}
+
+// compType is a component type to serialize, with its name.
+type compType struct {
+	ecs.CompInfo
+	Name   string // Name of the type.
+	quoted string // Name of the type, as JSON string.
+}
+
+// componentInfos returns the infos of all component types to serialize.
+// They are sorted by ID, or by name in canonical mode.
+//
+// Returns an error if multiple types have the same name.
+func componentInfos(world *ecs.World, opts *serdeOptions) ([]compType, error) {
+	if opts.skipEntities || opts.skipAllComponents {
+		return nil, nil
+	}
 
-		if counter < last {
-			builder.WriteString(",")
+	infos := []compType{}
+	for _, id := range ecs.ComponentIDs(world) {
+		if info, ok := ecs.ComponentInfo(world, id); ok {
+			if !opts.skipComponent(info.Type) {
+				name := opts.registry.Name(info.Type)
+				infos = append(infos, compType{CompInfo: info, Name: name, quoted: quote(name)})
+			}
 		}
-		builder.WriteString("\n")
-		counter++
+	}
+	if err := checkNames(infos, func(c compType) string { return c.Name }); err != nil {
+		return nil, sectionError(SectionTypes, err)
+	}
+	if opts.canonical {
+		slices.SortFunc(infos, func(a, b compType) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+	return infos, nil
+}
+
+// resourceInfo is the ID, type and name of a resource.
+type resourceInfo struct {
+	ID   ecs.ResID
+	Type reflect.Type
+	Name string
+}
+
+// resourceInfos returns the IDs and types of all resources to serialize.
+// They are sorted by ID, or by name in canonical mode.
+// Resources that are registered but not present in the world are omitted.
+//
+// Returns an error if multiple resources have the same name.
+func resourceInfos(world *ecs.World, opts *serdeOptions) ([]resourceInfo, error) {
+	if opts.skipAllResources {
+		return nil, nil
 	}
 
-	builder.WriteString("}")
+	resources := []resourceInfo{}
+	for _, id := range ecs.ResourceIDs(world) {
+		if tp, ok := ecs.ResourceType(world, id); ok {
+			if !opts.skipResource(tp) && world.Resources().Has(id) {
+				resources = append(resources, resourceInfo{ID: id, Type: tp, Name: opts.registry.Name(tp)})
+			}
+		}
+	}
+	if err := checkNames(resources, func(r resourceInfo) string { return r.Name }); err != nil {
+		return nil, sectionError(SectionResources, err)
+	}
+	if opts.canonical {
+		slices.SortFunc(resources, func(a, b resourceInfo) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+	return resources, nil
+}
 
+// checkNames checks that no two types share the same name.
+func checkNames[T any](types []T, name func(T) string) error {
+	names := make(map[string]bool, len(types))
+	for _, tp := range types {
+		n := name(tp)
+		if names[n] {
+			return fmt.Errorf("multiple types with name %s; use a Registry to assign unique names", n)
+		}
+		names[n] = true
+	}
 	return nil
 }
+
+// quote returns a name as JSON string.
+func quote(name string) string {
+	jsonData, _ := json.Marshal(name)
+	return string(jsonData)
+}
@@ -1,6 +1,7 @@
 package archeserde_test
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 
@@ -339,3 +340,184 @@ func TestSerializeGeneric(t *testing.T) {
 
 	_, _, _ = e1, e2, e3
 }
+
+type failingWriter struct{}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("write failed")
+}
+
+func TestSerializeTo(t *testing.T) {
+	jsonData, parent, child, err := serialize()
+	assert.Nil(t, err)
+
+	w := ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&w)
+	_ = ecs.ComponentID[Velocity](&w)
+	_ = ecs.ComponentID[ChildOf](&w)
+	err = archeserde.Deserialize(jsonData, &w, archeserde.Opts.SkipAllResources())
+	assert.Nil(t, err)
+
+	buffer := bytes.Buffer{}
+	err = archeserde.SerializeTo(&w, &buffer, archeserde.Opts.SkipAllResources())
+	assert.Nil(t, err)
+
+	w2 := ecs.NewWorld()
+	childId := ecs.ComponentID[ChildOf](&w2)
+	_ = ecs.ComponentID[Position](&w2)
+	_ = ecs.ComponentID[Velocity](&w2)
+	err = archeserde.DeserializeFrom(&buffer, &w2)
+	assert.Nil(t, err)
+
+	assert.True(t, w2.Alive(parent))
+	assert.True(t, w2.Alive(child))
+	assert.Equal(t, ChildOf{Entity: parent}, *(*ChildOf)(w2.Get(child, childId)))
+
+	err = archeserde.SerializeTo(&w, failingWriter{})
+	assert.Contains(t, err.Error(), "write failed")
+}
+
+func TestSerializeArchetypeLayout(t *testing.T) {
+	jsonData, parent, child, err := serialize(archeserde.Opts.Layout(archeserde.ArchetypeLayout))
+	assert.Nil(t, err)
+
+	fmt.Println(string(jsonData))
+
+	w := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w)
+	velId := ecs.ComponentID[Velocity](&w)
+	childId := ecs.ComponentID[ChildOf](&w)
+	_ = ecs.AddResource[Position](&w, &Position{})
+	_ = ecs.AddResource[Velocity](&w, &Velocity{})
+
+	err = archeserde.Deserialize(jsonData, &w)
+	assert.Nil(t, err)
+
+	query := w.Query(ecs.All())
+	assert.Equal(t, query.Count(), 3)
+
+	query.Next()
+	assert.False(t, query.Has(posId))
+	assert.False(t, query.Has(velId))
+
+	query.Next()
+	assert.Equal(t, parent, query.Entity())
+	assert.Equal(t, Position{X: 1, Y: 2}, *(*Position)(query.Get(posId)))
+
+	query.Next()
+	assert.Equal(t, child, query.Entity())
+	assert.Equal(t, Position{X: 3, Y: 4}, *(*Position)(query.Get(posId)))
+	assert.Equal(t, Velocity{X: 5, Y: 6}, *(*Velocity)(query.Get(velId)))
+	assert.Equal(t, ChildOf{Entity: parent}, *(*ChildOf)(query.Get(childId)))
+
+	res := (*Velocity)(ecs.GetResource[Velocity](&w))
+	assert.Equal(t, Velocity{X: 1000}, *res)
+}
+
+func TestSerializeArchetypeLayoutRelation(t *testing.T) {
+	w := ecs.NewWorld()
+
+	posId := ecs.ComponentID[Position](&w)
+	relId := ecs.ComponentID[ChildRelation](&w)
+
+	parent1 := w.NewEntity(posId)
+	parent2 := w.NewEntity(posId)
+
+	builder := ecs.NewBuilder(&w, posId, relId).WithRelation(relId)
+	builder.NewBatch(5, parent1)
+	builder.NewBatch(5, parent2)
+	builder.NewBatch(5)
+
+	jsonData, err := archeserde.Serialize(&w,
+		archeserde.Opts.Layout(archeserde.ArchetypeLayout),
+		archeserde.Opts.SkipComponents(generic.T[Position]()),
+	)
+	assert.Nil(t, err)
+	fmt.Println(string(jsonData))
+
+	w2 := ecs.NewWorld()
+	posId = ecs.ComponentID[Position](&w2)
+	relId = ecs.ComponentID[ChildRelation](&w2)
+
+	err = archeserde.Deserialize(jsonData, &w2)
+	assert.Nil(t, err)
+
+	filter := ecs.All(relId)
+	query := w2.Query(&filter)
+	assert.Equal(t, 15, query.Count())
+	query.Close()
+
+	for _, target := range []ecs.Entity{parent1, parent2, {}} {
+		filter := ecs.NewRelationFilter(ecs.All(relId), target)
+		query := w2.Query(&filter)
+		assert.Equal(t, 5, query.Count())
+		for query.Next() {
+			assert.False(t, query.Has(posId))
+		}
+	}
+
+	query = w2.Query(ecs.All(posId))
+	assert.Equal(t, 0, query.Count())
+	query.Close()
+}
+
+func TestSerializeCanonical(t *testing.T) {
+	w1 := ecs.NewWorld()
+	posId := ecs.ComponentID[Position](&w1)
+	velId := ecs.ComponentID[Velocity](&w1)
+	relId := ecs.ComponentID[ChildRelation](&w1)
+
+	e1 := w1.NewEntity(posId)
+	e2 := w1.NewEntity(posId, velId, relId)
+	*(*Position)(w1.Get(e1, posId)) = Position{X: 1, Y: 2}
+	*(*Velocity)(w1.Get(e2, velId)) = Velocity{X: 3, Y: 4}
+	w1.Relations().Set(e2, relId, e1)
+	_ = ecs.AddResource(&w1, &Position{X: 1000})
+	_ = ecs.AddResource(&w1, &Velocity{X: 2000})
+
+	w2 := ecs.NewWorld()
+	relId = ecs.ComponentID[ChildRelation](&w2)
+	velId = ecs.ComponentID[Velocity](&w2)
+	posId = ecs.ComponentID[Position](&w2)
+
+	e1 = w2.NewEntity()
+	e2 = w2.NewEntity(relId, velId, posId)
+	w2.Add(e1, posId)
+	*(*Position)(w2.Get(e1, posId)) = Position{X: 1, Y: 2}
+	*(*Velocity)(w2.Get(e2, velId)) = Velocity{X: 3, Y: 4}
+	w2.Relations().Set(e2, relId, e1)
+	_ = ecs.AddResource(&w2, &Velocity{X: 2000})
+	_ = ecs.AddResource(&w2, &Position{X: 1000})
+
+	for _, layout := range []archeserde.Layout{archeserde.EntityLayout, archeserde.ArchetypeLayout} {
+		jsonData1, err := archeserde.Serialize(&w1, archeserde.Opts.Canonical(), archeserde.Opts.Layout(layout))
+		assert.Nil(t, err)
+		jsonData2, err := archeserde.Serialize(&w2, archeserde.Opts.Canonical(), archeserde.Opts.Layout(layout))
+		assert.Nil(t, err)
+		assert.Equal(t, string(jsonData1), string(jsonData2))
+
+		fmt.Println(string(jsonData1))
+
+		w3 := ecs.NewWorld()
+		posId = ecs.ComponentID[Position](&w3)
+		_ = ecs.ComponentID[Velocity](&w3)
+		relId = ecs.ComponentID[ChildRelation](&w3)
+		_ = ecs.AddResource(&w3, &Velocity{})
+		_ = ecs.AddResource(&w3, &Position{})
+
+		err = archeserde.Deserialize(jsonData1, &w3)
+		assert.Nil(t, err)
+		assert.Equal(t, Position{X: 1, Y: 2}, *(*Position)(w3.Get(e1, posId)))
+		assert.Equal(t, e1, w3.Relations().Get(e2, relId))
+
+		jsonData3, err := archeserde.Serialize(&w3, archeserde.Opts.Canonical(), archeserde.Opts.Layout(layout))
+		assert.Nil(t, err)
+		assert.Equal(t, string(jsonData1), string(jsonData3))
+	}
+
+	data1, err := archeserde.SerializeBinary(&w1, archeserde.Opts.Canonical())
+	assert.Nil(t, err)
+	data2, err := archeserde.SerializeBinary(&w2, archeserde.Opts.Canonical())
+	assert.Nil(t, err)
+	assert.Equal(t, data1, data2)
+}
@@ -7,8 +7,24 @@ type deserializer struct {
 	Types      []string
 	Components []entry
 	Resources  map[string]entry
+
+	remap entityRemap // Entity mapping when merging into a non-empty world, nil otherwise.
+}
+
+// loadEntities loads the entity dump into the world.
+// When merging, new entities are created instead.
+func (d *deserializer) loadEntities(world *ecs.World) {
+	if d.remap != nil {
+		d.remap.create(world, &d.World)
+		return
+	}
+	world.LoadEntities(&d.World)
 }
 
+// entry holds the raw JSON of a component or resource.
+//
+// The bytes are not copied on unmarshalling.
+// When used with a [json.Decoder], they are only valid until the next call to the decoder.
 type entry struct {
 	Bytes []byte
 }
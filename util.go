@@ -0,0 +1,20 @@
+package archeserde
+
+import (
+	"reflect"
+	"unsafe"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// entityType is the reflection type of an [ecs.Entity].
+var entityType = reflect.TypeOf(ecs.Entity{})
+
+// newEntity creates an entity from its ID and generation.
+//
+// Entities can't be created through the public API of Arche.
+// This relies on the memory layout of [ecs.Entity], which is an ID followed by a generation.
+func newEntity(id, gen uint32) ecs.Entity {
+	data := [2]uint32{id, gen}
+	return *(*ecs.Entity)(unsafe.Pointer(&data))
+}
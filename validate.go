@@ -0,0 +1,579 @@
+package archeserde
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/mlange-42/arche/ecs"
+)
+
+// relationType is the reflection type of an [ecs.Relation].
+var relationType = reflect.TypeOf(ecs.Relation{})
+
+// Validate checks whether JSON data can be deserialized into the given world, without modifying the world.
+//
+// Validate checks everything [Deserialize] checks, and additionally:
+//   - the consistency of the entity dump, i.e. the alive entities and the list of free entities
+//   - that all components and resources can be decoded into their types
+//   - that relation targets are alive, and only given for entities with exactly one relation component
+//
+// In contrast to [Deserialize], validation does not stop at the first problem.
+// All problems found are returned, or nil if the data is valid.
+// Problems with a known location are of type [*Error].
+// Only syntax errors in the JSON document and unsupported format versions stop validation early.
+//
+// The world and the options are expected like for [Deserialize].
+// Types from the [Registry] given via [Options.Registry] are considered,
+// but not registered in the world. Resource factories are not called.
+func Validate(jsonData []byte, world *ecs.World, options ...Option) []error {
+	opts := newSerdeOptions(options...)
+	v := validator{
+		world:    world,
+		opts:     &opts,
+		sections: map[string]json.RawMessage{},
+		reported: map[string]bool{},
+	}
+	return v.validate(jsonData)
+}
+
+// ValidateStructure checks the structure of JSON data, without a world and without knowing the Go types.
+//
+// It checks:
+//   - the consistency of the entity dump, i.e. the alive entities and the list of free entities
+//   - that the components match the alive entities, and that all component types are listed in section "Types"
+//   - that archetype columns match the archetype's types and entities
+//   - that relation targets are alive
+//
+// Component and resource values are not decoded, as their types are unknown.
+// Problems are reported like for [Validate].
+func ValidateStructure(jsonData []byte) []error {
+	opts := newSerdeOptions()
+	v := validator{
+		opts:       &opts,
+		structural: true,
+		sections:   map[string]json.RawMessage{},
+		reported:   map[string]bool{},
+	}
+	return v.validate(jsonData)
+}
+
+// validate runs all checks on a JSON document.
+func (v *validator) validate(jsonData []byte) []error {
+	jsonData, err := decompressBytes(jsonData)
+	if err != nil {
+		return []error{err}
+	}
+	if !v.readDocument(jsonData) {
+		return v.errs
+	}
+
+	if !v.opts.skipEntities {
+		v.checkEntities()
+		if !v.opts.skipAllComponents {
+			v.checkComponents()
+		}
+	}
+	if !v.opts.skipAllResources {
+		v.checkResources()
+	}
+	return v.errs
+}
+
+// validator collects the problems found in a JSON document.
+type validator struct {
+	world      *ecs.World
+	opts       *serdeOptions
+	structural bool // Check only the structure, without types. The world is nil.
+	sections   map[string]json.RawMessage
+	errs       []error
+
+	dump      ecs.EntityDump
+	records   []entityRecord // Entity records of RecordLayout, nil for other layouts.
+	alive     map[ecs.Entity]bool
+	types     map[string]reflect.Type
+	ambiguous map[string]bool
+	reported  map[string]bool
+}
+
+// add adds a problem, with its location.
+// Use an empty section or type name, an index of -1 and the zero entity for unknown parts of the location.
+func (v *validator) add(section string, index int, entity ecs.Entity, tpName string, err error) {
+	v.errs = append(v.errs, newError(section, index, entity, tpName, err))
+}
+
+// readDocument reads the sections of the document.
+// Returns false if the document can't be read any further.
+func (v *validator) readDocument(jsonData []byte) bool {
+	dec := json.NewDecoder(bytes.NewReader(jsonData))
+	if err := expectDelim(dec, '{', reflect.TypeOf(deserializer{})); err != nil {
+		v.errs = append(v.errs, err)
+		return false
+	}
+
+	version := 0
+	for first := true; dec.More(); first = false {
+		token, err := dec.Token()
+		if err != nil {
+			v.errs = append(v.errs, err)
+			return false
+		}
+		key, _ := token.(string)
+
+		if key == "Version" {
+			if !first {
+				v.errs = append(v.errs, fmt.Errorf("entry 'Version' must be the first entry of the document"))
+			}
+			if version, err = readVersion(dec); err != nil {
+				v.errs = append(v.errs, err)
+				return false
+			}
+			continue
+		}
+
+		raw := json.RawMessage{}
+		if err := dec.Decode(&raw); err != nil {
+			v.errs = append(v.errs, err)
+			return false
+		}
+		if err := checkSection(version, key); err != nil {
+			v.errs = append(v.errs, err)
+			continue
+		}
+		if _, ok := v.sections[SectionEntities]; ok && key == SectionFree {
+			v.add(SectionFree, -1, ecs.Entity{}, "", fmt.Errorf("section '%s' must precede section '%s'", SectionFree, SectionEntities))
+		}
+		v.sections[key] = raw
+	}
+
+	if _, err := dec.Token(); err != nil {
+		v.errs = append(v.errs, err)
+		return false
+	}
+	return true
+}
+
+// decodeSection decodes a section, if present.
+// Returns false if the section is missing or can't be decoded.
+func (v *validator) decodeSection(name string, value any) bool {
+	raw, ok := v.sections[name]
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(raw, value); err != nil {
+		v.add(name, -1, ecs.Entity{}, "", err)
+		return false
+	}
+	return true
+}
+
+// checkEntities checks the world for being fresh, and the entity dump for consistency.
+func (v *validator) checkEntities() {
+	v.alive = map[ecs.Entity]bool{}
+
+	if !v.structural {
+		if worldDump := v.world.DumpEntities(); len(worldDump.Entities) > 1 || worldDump.Available > 0 {
+			v.add(SectionWorld, -1, ecs.Entity{}, "", fmt.Errorf("world must not contain any alive or dead entities"))
+		}
+	}
+
+	if _, ok := v.sections[SectionEntities]; ok {
+		if _, ok := v.sections[SectionWorld]; ok {
+			v.errs = append(v.errs, errWorldAndRecords)
+			return
+		}
+		v.checkRecords()
+		return
+	}
+
+	if !v.decodeSection("World", &v.dump) {
+		return
+	}
+	dump := &v.dump
+
+	if len(dump.Entities) == 0 || dump.Entities[0].ID() != 0 {
+		v.add(SectionWorld, -1, ecs.Entity{}, "", fmt.Errorf("entity dump does not start with the reserved zero entity"))
+		return
+	}
+
+	for i, idx := range dump.Alive {
+		if idx == 0 || int(idx) >= len(dump.Entities) {
+			v.add(SectionWorld, i, ecs.Entity{}, "", fmt.Errorf("alive entity index %d is out of range of %d entities", idx, len(dump.Entities)))
+			continue
+		}
+		entity := dump.Entities[idx]
+		if entity.ID() != idx {
+			v.add(SectionWorld, i, entity, "", fmt.Errorf("alive entity %v is stored at index %d", entity, idx))
+			continue
+		}
+		if v.alive[entity] {
+			v.add(SectionWorld, i, entity, "", fmt.Errorf("alive entity %v is listed multiple times", entity))
+			continue
+		}
+		v.alive[entity] = true
+	}
+
+	free := map[uint32]bool{}
+	next := dump.Next
+	for i := uint32(0); i < dump.Available; i++ {
+		if next == 0 || int(next) >= len(dump.Entities) {
+			v.add(SectionWorld, -1, ecs.Entity{}, "", fmt.Errorf("free entity index %d is out of range of %d entities", next, len(dump.Entities)))
+			break
+		}
+		if free[next] {
+			v.add(SectionWorld, -1, ecs.Entity{}, "", fmt.Errorf("free entity list contains a cycle at index %d", next))
+			break
+		}
+		if v.alive[dump.Entities[next]] {
+			v.add(SectionWorld, -1, dump.Entities[next], "", fmt.Errorf("free entity list contains alive entity %v", dump.Entities[next]))
+			break
+		}
+		free[next] = true
+		next = dump.Entities[next].ID()
+	}
+
+	if dead := len(dump.Entities) - 1 - len(v.alive); dead != int(dump.Available) {
+		v.add(SectionWorld, -1, ecs.Entity{}, "", fmt.Errorf("entity dump has %d dead entities, but %d available entities", dead, dump.Available))
+	}
+}
+
+// checkRecords reconstructs the entity dump from the entity records and the free entities of [RecordLayout],
+// and checks them for consistency.
+func (v *validator) checkRecords() {
+	free := []ecs.Entity{}
+	if _, ok := v.sections[SectionFree]; ok && !v.decodeSection(SectionFree, &free) {
+		return
+	}
+	if !v.decodeSection(SectionEntities, &v.records) {
+		return
+	}
+
+	dump, errs := recordDump(recordEntities(v.records), free)
+	if len(errs) > 0 {
+		v.errs = append(v.errs, errs...)
+		return
+	}
+	v.dump = dump
+	for _, idx := range dump.Alive {
+		v.alive[dump.Entities[idx]] = true
+	}
+}
+
+// checkComponents checks the component types, and the components of all entities.
+func (v *validator) checkComponents() {
+	_, hasComponents := v.sections["Components"]
+	_, hasArchetypes := v.sections["Archetypes"]
+	if !hasComponents && !hasArchetypes && v.records == nil {
+		return
+	}
+
+	types := []string{}
+	v.decodeSection("Types", &types)
+	v.componentTypes(types)
+	for _, name := range types {
+		v.componentType(name)
+	}
+
+	if hasComponents {
+		v.checkEntityLayout()
+	}
+	if hasArchetypes {
+		v.checkArchetypeLayout()
+	}
+	if v.records != nil {
+		v.checkRecordLayout()
+	}
+}
+
+// componentTypes collects the component types available for deserialization, by name.
+// These are the types registered in the world, and the types from the registry listed in the "Types" section.
+// When checking only the structure, these are the names listed in the "Types" section, without types.
+func (v *validator) componentTypes(names []string) {
+	v.types = map[string]reflect.Type{}
+	v.ambiguous = map[string]bool{}
+
+	if v.structural {
+		for _, name := range names {
+			v.types[name] = nil
+		}
+		return
+	}
+
+	tps := []reflect.Type{}
+	for _, id := range ecs.ComponentIDs(v.world) {
+		if info, ok := ecs.ComponentInfo(v.world, id); ok {
+			tps = append(tps, info.Type)
+		}
+	}
+	for _, name := range names {
+		if tp, ok := v.opts.registry.Type(name); ok && !slices.Contains(tps, tp) {
+			tps = append(tps, tp)
+		}
+	}
+
+	for _, tp := range tps {
+		for _, name := range v.opts.registry.lookupNames(tp) {
+			if _, ok := v.types[name]; ok {
+				v.ambiguous[name] = true
+			}
+			v.types[name] = tp
+		}
+	}
+}
+
+// componentType returns the component type for a name.
+// Unknown and ambiguous names are reported once.
+//
+// When checking only the structure, names not listed in the "Types" section are reported,
+// and the result is always false.
+func (v *validator) componentType(name string) (reflect.Type, bool) {
+	tp, ok := v.types[name]
+	if v.structural {
+		if !ok && !v.reported[name] {
+			v.reported[name] = true
+			v.add(SectionTypes, -1, ecs.Entity{}, name, fmt.Errorf("component type is not listed in section '%s': %s", SectionTypes, name))
+		}
+		return nil, false
+	}
+	if ok && !v.ambiguous[name] {
+		return tp, true
+	}
+	if !v.reported[name] {
+		v.reported[name] = true
+		if ok {
+			v.add(SectionTypes, -1, ecs.Entity{}, name, fmt.Errorf("component type name is ambiguous: %s; use a Registry to assign unique names", name))
+		} else {
+			v.add(SectionTypes, -1, ecs.Entity{}, name, fmt.Errorf("component type is not registered: %s", name))
+		}
+	}
+	return nil, false
+}
+
+// checkEntityLayout checks the components in section "Components".
+func (v *validator) checkEntityLayout() {
+	components := []map[string]entry{}
+	if !v.decodeSection("Components", &components) {
+		return
+	}
+
+	alive := v.dump.Alive
+	if len(components) != len(alive) {
+		v.add(SectionComponents, -1, ecs.Entity{}, "", fmt.Errorf("found components for %d entities, but world has %d alive entities", len(components), len(alive)))
+	}
+
+	for i, mp := range components {
+		entity := ecs.Entity{}
+		if i < len(alive) && int(alive[i]) < len(v.dump.Entities) {
+			entity = v.dump.Entities[alive[i]]
+		}
+		v.checkEntity(SectionComponents, i, entity, mp)
+	}
+}
+
+// checkRecordLayout checks the components and relation targets of the entity records in section "Entities".
+func (v *validator) checkRecordLayout() {
+	mp := map[string]entry{}
+	for i := range v.records {
+		record := &v.records[i]
+		clear(mp)
+		for name, value := range record.Components {
+			mp[name] = entry{Bytes: value}
+		}
+		if record.Target != nil {
+			mp[targetTag] = entry{Bytes: record.Target}
+		}
+		v.checkEntity(SectionEntities, i, record.Entity, mp)
+	}
+}
+
+// checkEntity checks the components and the relation target of a single entity, keyed by type name.
+func (v *validator) checkEntity(section string, i int, entity ecs.Entity, mp map[string]entry) {
+	names := make([]string, 0, len(mp))
+	for name := range mp {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	target := ecs.Entity{}
+	relations, hasRelation := 0, false
+	for _, name := range names {
+		value := mp[name]
+		if name == targetTag {
+			if err := json.Unmarshal(value.Bytes, &target); err != nil {
+				v.add(section, i, entity, name, err)
+			}
+			continue
+		}
+
+		tp, ok := v.componentType(name)
+		if !ok {
+			continue
+		}
+		if isRelation(tp) {
+			hasRelation = true
+		}
+		if v.opts.skipComponent(tp) {
+			continue
+		}
+		if isRelation(tp) {
+			relations++
+		}
+		if err := v.opts.codecs.unmarshalJSON(value.Bytes, reflect.New(tp)); err != nil {
+			v.add(section, i, entity, name, err)
+		}
+	}
+
+	v.checkRelation(section, i, entity, relations, hasRelation, target)
+}
+
+// checkArchetypeLayout checks the components in section "Archetypes".
+func (v *validator) checkArchetypeLayout() {
+	archetypes := []archetypeEntry{}
+	if !v.decodeSection("Archetypes", &archetypes) {
+		return
+	}
+
+	seen := map[ecs.Entity]bool{}
+	for i := range archetypes {
+		arch := &archetypes[i]
+
+		hasColumns := len(arch.Components) == len(arch.Types)
+		if !hasColumns {
+			v.add(SectionArchetypes, i, ecs.Entity{}, "", fmt.Errorf("found %d component columns for %d types in archetype", len(arch.Components), len(arch.Types)))
+		}
+
+		relations, hasRelation := 0, false
+		for j, name := range arch.Types {
+			if v.structural {
+				v.componentType(name)
+				if hasColumns {
+					v.checkColumnLength(i, name, arch.Components[j].Bytes, len(arch.Entities))
+				}
+				continue
+			}
+			tp, ok := v.componentType(name)
+			if !ok {
+				continue
+			}
+			if isRelation(tp) {
+				hasRelation = true
+			}
+			if v.opts.skipComponent(tp) {
+				continue
+			}
+			if isRelation(tp) {
+				relations++
+			}
+			if !hasColumns {
+				continue
+			}
+
+			column, err := v.opts.codecs.unmarshalColumn(arch.Components[j].Bytes, tp)
+			if err != nil {
+				v.add(SectionArchetypes, i, ecs.Entity{}, name, err)
+				continue
+			}
+			if column.Len() != len(arch.Entities) {
+				v.add(SectionArchetypes, i, ecs.Entity{}, name, fmt.Errorf("found %d values of %s for %d entities in archetype", column.Len(), name, len(arch.Entities)))
+			}
+		}
+
+		v.checkRelation(SectionArchetypes, i, ecs.Entity{}, relations, hasRelation, arch.Target)
+
+		for _, entity := range arch.Entities {
+			if !v.alive[entity] {
+				v.add(SectionArchetypes, i, entity, "", fmt.Errorf("entity %v in archetype is not alive", entity))
+				continue
+			}
+			if seen[entity] {
+				v.add(SectionArchetypes, i, entity, "", fmt.Errorf("entity %v is contained in multiple archetypes", entity))
+			}
+			seen[entity] = true
+		}
+	}
+}
+
+// checkColumnLength checks the number of values in a component column of an archetype, without decoding them.
+func (v *validator) checkColumnLength(index int, tpName string, jsonData []byte, entities int) {
+	column := []json.RawMessage{}
+	if err := json.Unmarshal(jsonData, &column); err != nil {
+		v.add(SectionArchetypes, index, ecs.Entity{}, tpName, err)
+		return
+	}
+	if len(column) != entities {
+		v.add(SectionArchetypes, index, ecs.Entity{}, tpName, fmt.Errorf("found %d values of %s for %d entities in archetype", len(column), tpName, entities))
+	}
+}
+
+// checkRelation checks the relation components and the relation target of an entity or archetype.
+func (v *validator) checkRelation(section string, index int, entity ecs.Entity, relations int, hasRelation bool, target ecs.Entity) {
+	if relations > 1 {
+		v.add(section, index, entity, "", fmt.Errorf("found %d relation components, but at most one is allowed", relations))
+	}
+	if target.IsZero() {
+		return
+	}
+	if !hasRelation && !v.structural {
+		v.add(section, index, entity, "", fmt.Errorf("found relation target %v, but no relation component", target))
+	}
+	if !v.alive[target] {
+		v.add(section, index, entity, "", fmt.Errorf("relation target %v is not alive", target))
+	}
+}
+
+// checkResources checks the resources in section "Resources".
+func (v *validator) checkResources() {
+	resources := map[string]entry{}
+	if !v.decodeSection("Resources", &resources) || v.structural {
+		return
+	}
+
+	types := map[string]reflect.Type{}
+	ambiguous := map[string]bool{}
+	for _, id := range ecs.ResourceIDs(v.world) {
+		if tp, ok := ecs.ResourceType(v.world, id); ok {
+			for _, name := range v.opts.registry.lookupNames(tp) {
+				if _, ok := types[name]; ok {
+					ambiguous[name] = true
+				}
+				types[name] = tp
+			}
+		}
+	}
+
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		tp, ok := types[name]
+		if !ok {
+			if tp, ok = v.opts.registry.Type(name); !ok {
+				v.add(SectionResources, -1, ecs.Entity{}, name, fmt.Errorf("resource type is not registered: %s", name))
+				continue
+			}
+		} else if ambiguous[name] {
+			v.add(SectionResources, -1, ecs.Entity{}, name, fmt.Errorf("resource type name is ambiguous: %s; use a Registry to assign unique names", name))
+			continue
+		}
+		if v.opts.skipResource(tp) {
+			continue
+		}
+		if err := v.opts.codecs.unmarshalJSON(resources[name].Bytes, reflect.New(tp)); err != nil {
+			v.add(SectionResources, -1, ecs.Entity{}, name, err)
+		}
+	}
+}
+
+// isRelation checks whether a component type is a relation component, the same way as Arche does.
+func isRelation(tp reflect.Type) bool {
+	if tp.Kind() != reflect.Struct || tp.NumField() == 0 {
+		return false
+	}
+	field := tp.Field(0)
+	return field.Type == relationType && field.Name == "Relation"
+}
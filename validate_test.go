@@ -0,0 +1,208 @@
+package archeserde_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	archeserde "github.com/mlange-42/arche-serde"
+	"github.com/mlange-42/arche/ecs"
+	"github.com/mlange-42/arche/generic"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	jsonData, _, _, err := serialize()
+	assert.Nil(t, err)
+
+	world := ecs.NewWorld()
+	_ = ecs.ComponentID[Position](&world)
+	_ = ecs.ComponentID[Velocity](&world)
+	_ = ecs.ComponentID[ChildOf](&world)
+	_ = ecs.ResourceID[Position](&world)
+	_ = ecs.ResourceID[Velocity](&world)
+
+	assert.Nil(t, archeserde.Validate(jsonData, &world))
+
+	jsonData, _, _, err = serialize(archeserde.Opts.Layout(archeserde.ArchetypeLayout), archeserde.Opts.Compression(archeserde.Gzip))
+	assert.Nil(t, err)
+	assert.Nil(t, archeserde.Validate(jsonData, &world))
+
+	// The world is not modified.
+	query := world.Query(ecs.All())
+	assert.Equal(t, 0, query.Count())
+	query.Close()
+	assert.False(t, world.Resources().Has(ecs.ResourceID[Position](&world)))
+
+	err = archeserde.Deserialize(jsonData, &world)
+	assert.Nil(t, err)
+
+	errs := archeserde.Validate(jsonData, &world)
+	assert.Equal(t, 1, len(errs))
+	assert.Contains(t, errs[0].Error(), "world must not contain any alive or dead entities")
+
+	assert.Nil(t, archeserde.Validate(jsonData, &world, archeserde.Opts.SkipEntities()))
+}
+
+func TestValidateRegistry(t *testing.T) {
+	world := ecs.NewWorld()
+	posID := ecs.ComponentID[Position](&world)
+	e := world.NewEntity(posID)
+	*(*Position)(world.Get(e, posID)) = Position{X: 1, Y: 2}
+	ecs.AddResource(&world, &Velocity{X: 3})
+
+	registry := archeserde.NewRegistry().
+		Register(generic.T[Position](), "position").
+		Register(generic.T[Velocity](), "velocity")
+
+	jsonData, err := archeserde.Serialize(&world, archeserde.Opts.Registry(registry))
+	assert.Nil(t, err)
+
+	newWorld := ecs.NewWorld()
+	assert.Nil(t, archeserde.Validate(jsonData, &newWorld, archeserde.Opts.Registry(registry)))
+	assert.Equal(t, 0, len(ecs.ComponentIDs(&newWorld)))
+	assert.Equal(t, 0, len(ecs.ResourceIDs(&newWorld)))
+
+	errs := archeserde.Validate(jsonData, &newWorld)
+	assert.Equal(t, 2, len(errs))
+	assert.Contains(t, errs[0].Error(), "component type is not registered: position")
+	assert.Contains(t, errs[1].Error(), "resource type is not registered: velocity")
+}
+
+func TestValidateErrors(t *testing.T) {
+	newWorld := func() *ecs.World {
+		world := ecs.NewWorld()
+		_ = ecs.ComponentID[Position](&world)
+		_ = ecs.ComponentID[ChildRelation](&world)
+		_ = ecs.ResourceID[Velocity](&world)
+		return &world
+	}
+
+	errs := archeserde.Validate([]byte("{xxx}"), newWorld())
+	assert.Equal(t, 1, len(errs))
+	assert.Contains(t, errs[0].Error(), "invalid character 'x'")
+
+	errs = archeserde.Validate([]byte(`{"Version" : 3}`), newWorld())
+	assert.Equal(t, 1, len(errs))
+	assert.Contains(t, errs[0].Error(), "unsupported format version 3")
+
+	errs = archeserde.Validate([]byte(textValidateErrors), newWorld())
+	expected := []string{
+		"entry 'Version' must be the first entry of the document",
+		"section 'World', index 2, entity {2 0}: alive entity {2 0} is listed multiple times",
+		"section 'World', index 3: alive entity index 7 is out of range of 5 entities",
+		"section 'World': entity dump has 2 dead entities, but 1 available entities",
+		"section 'Types', type archeserde_test.Velocity: component type is not registered",
+		"section 'Components': found components for 3 entities, but world has 4 alive entities",
+		"section 'Components', index 0, entity {1 0}, type archeserde_test.Position: json: cannot unmarshal string",
+		"section 'Components', index 1, entity {2 0}: relation target {4 0} is not alive",
+		"section 'Components', index 2, entity {2 0}: found relation target {1 0}, but no relation component",
+		"section 'Resources', type archeserde_test.Position: resource type is not registered",
+		"section 'Resources', type archeserde_test.Velocity: json: cannot unmarshal array",
+	}
+	assert.Equal(t, len(expected), len(errs))
+	for i, msg := range expected {
+		if i < len(errs) {
+			assert.Contains(t, errs[i].Error(), msg)
+		}
+	}
+
+	errs = archeserde.Validate([]byte(textValidateFreeList), newWorld())
+	assert.Equal(t, 1, len(errs))
+	assert.Contains(t, errs[0].Error(), "section 'World': free entity list contains a cycle at index 1")
+
+	errs = archeserde.Validate([]byte(fmt.Sprintf(textArchetypes, `[[1,0],[1,0],[5,0]]`, `[{"X":1},{"X":"a"}]`)), newWorld())
+	expected = []string{
+		"section 'Archetypes', index 0, type archeserde_test.Position: json: cannot unmarshal string",
+		"section 'Archetypes', index 0, entity {1 0}: entity {1 0} is contained in multiple archetypes",
+		"section 'Archetypes', index 0, entity {5 0}: entity {5 0} in archetype is not alive",
+	}
+	assert.Equal(t, len(expected), len(errs))
+	for i, msg := range expected {
+		if i < len(errs) {
+			assert.Contains(t, errs[i].Error(), msg)
+		}
+	}
+
+	errs = archeserde.Validate([]byte(fmt.Sprintf(textArchetypes, `[[1,0]]`, `[{"X":1}],[{"X":2}]`)), newWorld())
+	assert.Equal(t, 1, len(errs))
+	assert.Contains(t, errs[0].Error(), "section 'Archetypes', index 0: found 2 component columns for 1 types")
+}
+
+func TestValidateStructure(t *testing.T) {
+	for _, options := range [][]archeserde.Option{
+		{},
+		{archeserde.Opts.Layout(archeserde.ArchetypeLayout), archeserde.Opts.Compression(archeserde.Zlib)},
+	} {
+		jsonData, err := archeserde.Serialize(parallelWorld(100), options...)
+		assert.Nil(t, err)
+		assert.Nil(t, archeserde.ValidateStructure(jsonData))
+	}
+
+	errs := archeserde.ValidateStructure([]byte(textValidateErrors))
+	expected := []string{
+		"entry 'Version' must be the first entry of the document",
+		"section 'World', index 2, entity {2 0}: alive entity {2 0} is listed multiple times",
+		"section 'World', index 3: alive entity index 7 is out of range of 5 entities",
+		"section 'World': entity dump has 2 dead entities, but 1 available entities",
+		"section 'Components': found components for 3 entities, but world has 4 alive entities",
+		"section 'Components', index 1, entity {2 0}: relation target {4 0} is not alive",
+	}
+	assert.Equal(t, len(expected), len(errs))
+	for i, msg := range expected {
+		if i < len(errs) {
+			assert.Contains(t, errs[i].Error(), msg)
+		}
+	}
+
+	errs = archeserde.ValidateStructure([]byte(fmt.Sprintf(textArchetypes, `[[1,0],[1,0],[5,0]]`, `[{"X":1},{"X":"a"}]`)))
+	expected = []string{
+		"section 'Archetypes', index 0, type archeserde_test.Position: found 2 values of archeserde_test.Position for 3 entities in archetype",
+		"section 'Archetypes', index 0, entity {1 0}: entity {1 0} is contained in multiple archetypes",
+		"section 'Archetypes', index 0, entity {5 0}: entity {5 0} in archetype is not alive",
+	}
+	assert.Equal(t, len(expected), len(errs))
+	for i, msg := range expected {
+		if i < len(errs) {
+			assert.Contains(t, errs[i].Error(), msg)
+		}
+	}
+
+	errs = archeserde.ValidateStructure([]byte(strings.Replace(fmt.Sprintf(textArchetypes, `[[1,0]]`, `[{"X":1}]`), `"Types" : [
+	  "archeserde_test.Position"
+	],`, `"Types" : [],`, 1)))
+	assert.Equal(t, 1, len(errs))
+	assert.Contains(t, errs[0].Error(), "section 'Types', type archeserde_test.Position: component type is not listed in section 'Types'")
+}
+
+const textValidateErrors = `{
+	"World" : {"Entities":[[0,4294967295],[1,0],[2,0],[0,1],[0,1]],"Alive":[1,2,2,7],"Next":3,"Available":1},
+	"Version" : 1,
+	"Types" : [
+	  "archeserde_test.Position",
+	  "archeserde_test.ChildRelation",
+	  "archeserde_test.Velocity"
+	],
+	"Components" : [
+	  {
+		"archeserde_test.Position" : {"X":"a","Y":2}
+	  },
+	  {
+		"archeserde_test.ChildRelation" : {},
+		"archeserde_test.Velocity" : {},
+		"arche.relation.Target" : [4,0]
+	  },
+	  {
+		"arche.relation.Target" : [1,0]
+	  }
+	],
+	"Resources" : {
+		"archeserde_test.Velocity" : [1,2],
+		"archeserde_test.Position" : {}
+	}
+}`
+
+const textValidateFreeList = `{
+	"World" : {"Entities":[[0,4294967295],[1,1],[1,1]],"Alive":[],"Next":1,"Available":2},
+	"Components" : []
+}`
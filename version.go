@@ -0,0 +1,64 @@
+package archeserde
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// jsonVersion is the latest version of the JSON format.
+//
+// Version 0 is the layout of arche-serde v0.2 and earlier, which has no "Version" entry.
+// An explicit "Version" of 0 is read in the same layout.
+// Deltas were introduced with version 1, so there are no deltas of version 0.
+// Version 2 adds the table of contents, and the sections of [RecordLayout].
+//
+// Documents are written in the lowest version that supports all their sections, see [documentVersion].
+// Readers skip sections they don't know, so new sections that are required for reading a document
+// must come with a new version. Otherwise, older readers would silently read incomplete data.
+const jsonVersion = 2
+
+// jsonSections lists the sections supported by each version of the JSON format, indexed by version.
+// Sections not listed for any version are ignored.
+var jsonSections = []map[string]bool{
+	{"World": true, "Types": true, "Components": true, "Resources": true},
+	{"World": true, "Types": true, "Components": true, "Archetypes": true, "Resources": true},
+	{"World": true, "Types": true, "Components": true, "Archetypes": true, "Entities": true, "Free": true, "Resources": true, "Index": true},
+}
+
+// documentVersion returns the format version to write for a document with the given layout,
+// and with or without a table of contents.
+// This is the lowest version that supports all sections of the document.
+func documentVersion(layout Layout, index bool) int {
+	if index || layout == RecordLayout {
+		return 2
+	}
+	return 1
+}
+
+// readVersion reads the value of the "Version" entry, and checks that it is supported.
+func readVersion(dec *json.Decoder) (int, error) {
+	version := 0
+	if err := dec.Decode(&version); err != nil {
+		return 0, err
+	}
+	if version < 0 || version > jsonVersion {
+		return 0, fmt.Errorf("unsupported format version %d, supported versions are 0 to %d", version, jsonVersion)
+	}
+	return version, nil
+}
+
+// checkSection checks whether a section is supported by a version of the JSON format.
+func checkSection(version int, section string) error {
+	if jsonSections[version][section] {
+		return nil
+	}
+	for _, sections := range jsonSections {
+		if sections[section] {
+			if version == 0 {
+				return fmt.Errorf("section '%s' is not supported by files without version", section)
+			}
+			return fmt.Errorf("section '%s' is not supported by format version %d", section, version)
+		}
+	}
+	return nil
+}